@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"os"
+	"time"
 
+	"github.com/caohoangphuctd97/go-test/internal/app/events"
+	"github.com/caohoangphuctd97/go-test/internal/app/jobs"
 	routes "github.com/caohoangphuctd97/go-test/internal/app/routers"
+	"github.com/caohoangphuctd97/go-test/internal/app/webhooks"
+	"github.com/caohoangphuctd97/go-test/internal/app/workers"
 	"github.com/caohoangphuctd97/go-test/pkg/configs"
 	middleware "github.com/caohoangphuctd97/go-test/pkg/middlewares"
 	"github.com/caohoangphuctd97/go-test/pkg/typapp"
@@ -16,7 +22,14 @@ import (
 	_ "github.com/caohoangphuctd97/go-test/internal/generated/ctor"
 )
 
-var BookRoutes routes.BookRoutes
+var (
+	BookRoutes  routes.BookRoutes
+	BookPurger  workers.BookPurger
+	OutboxRelay workers.OutboxRelay
+	Jobs        jobs.Queue
+	Stream      events.Stream
+	Webhooks    webhooks.Notifier
+)
 
 // @title GO exercise #2
 // @version 1.0
@@ -30,15 +43,20 @@ var BookRoutes routes.BookRoutes
 // @name Authorization
 func main() {
 	// Define Fiber config.
-	zerolog.TimeFieldFormat = zerolog.TimeFieldFormat
+	zerolog.TimeFieldFormat = time.RFC3339
 
 	log.Info().Msg("Start server")
 
 	config := configs.FiberConfig()
 
 	err := typapp.Invoke(
-		func(r routes.BookRoutes) {
+		func(r routes.BookRoutes, p workers.BookPurger, o workers.OutboxRelay, q jobs.Queue, s events.Stream, n webhooks.Notifier) {
 			BookRoutes = r
+			BookPurger = p
+			OutboxRelay = o
+			Jobs = q
+			Stream = s
+			Webhooks = n
 		},
 	)
 	if err != nil {
@@ -55,10 +73,24 @@ func main() {
 	routes.SwaggerRoute(app) // Register a swagger APIs
 	BookRoutes.SetRoute(app) // Register a public routes for app.
 
+	// Background workers, stopped once the server is.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go BookPurger.Run(ctx)
+	go OutboxRelay.Run(ctx)
+	go Stream.Run(ctx)
+	go Webhooks.Run(ctx)
+	Jobs.Start()
+
 	// Start server (with or without graceful shutdown).
 	if os.Getenv("STAGE_STATUS") == "dev" {
 		utils.StartServer(app)
 	} else {
-		utils.StartServerWithGracefulShutdown(app)
+		utils.StartServerWithGracefulShutdown(app, Stream.Close)
+	}
+
+	// Let running jobs finish.
+	if err := Jobs.Shutdown(context.Background()); err != nil {
+		log.Error().Err(err).Msg("jobs: shutdown")
 	}
 }
@@ -0,0 +1,4 @@
+-- Deleted books are kept until purged, deleted_at telling when they were deleted.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS books_deleted_at_idx ON books (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,13 @@
+-- Every change made to a book, written in the same transaction as the change.
+CREATE TABLE IF NOT EXISTS book_revisions(
+   id BIGSERIAL PRIMARY KEY,
+   book_id uuid NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+   version INTEGER NOT NULL,
+   action VARCHAR (16) NOT NULL,
+   actor VARCHAR (255) NOT NULL,
+   request_id VARCHAR (64) NOT NULL DEFAULT '',
+   changes JSONB NOT NULL,
+   snapshot JSONB NOT NULL,
+   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   UNIQUE (book_id, version)
+);
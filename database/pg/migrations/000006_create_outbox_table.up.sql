@@ -0,0 +1,17 @@
+-- Events raised by book changes, written in the same transaction as the
+-- change and published by the outbox relay.
+CREATE TABLE IF NOT EXISTS outbox(
+   id BIGSERIAL PRIMARY KEY,
+   event_id uuid NOT NULL UNIQUE,
+   event_type VARCHAR (64) NOT NULL,
+   book_id uuid NOT NULL,
+   version INTEGER NOT NULL,
+   actor VARCHAR (255) NOT NULL,
+   request_id VARCHAR (64) NOT NULL DEFAULT '',
+   payload JSONB NOT NULL,
+   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   published_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_published_at_idx ON outbox (published_at) WHERE published_at IS NOT NULL;
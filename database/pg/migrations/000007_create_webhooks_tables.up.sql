@@ -0,0 +1,27 @@
+-- Partners subscribed to book events, and every delivery made to them.
+CREATE TABLE IF NOT EXISTS webhooks(
+   id uuid PRIMARY KEY,
+   url VARCHAR (2048) NOT NULL,
+   events TEXT[] NOT NULL DEFAULT '{}',
+   secret VARCHAR (255) NOT NULL,
+   active BOOLEAN NOT NULL DEFAULT TRUE,
+   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries(
+   id uuid PRIMARY KEY,
+   webhook_id uuid NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+   event_id uuid NOT NULL,
+   event_type VARCHAR (64) NOT NULL,
+   payload JSONB NOT NULL,
+   status VARCHAR (16) NOT NULL,
+   attempts INTEGER NOT NULL DEFAULT 0,
+   response_status INTEGER NOT NULL DEFAULT 0,
+   error TEXT NOT NULL DEFAULT '',
+   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   delivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at DESC);
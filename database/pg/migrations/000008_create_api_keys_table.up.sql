@@ -0,0 +1,14 @@
+-- Keys of machine clients. Only a hash of each key is kept, the key being
+-- found by its prefix.
+CREATE TABLE IF NOT EXISTS api_keys(
+   id uuid PRIMARY KEY,
+   name VARCHAR (255) NOT NULL,
+   prefix VARCHAR (16) NOT NULL UNIQUE,
+   hash VARCHAR (64) NOT NULL,
+   scopes TEXT[] NOT NULL DEFAULT '{}',
+   created_by VARCHAR (255) NOT NULL DEFAULT '',
+   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   expires_at TIMESTAMP,
+   last_used_at TIMESTAMP,
+   revoked_at TIMESTAMP
+);
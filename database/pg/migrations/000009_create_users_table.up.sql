@@ -0,0 +1,12 @@
+-- Accounts of the people using the service, signing in with their email
+-- and password.
+CREATE TABLE IF NOT EXISTS users(
+   id uuid PRIMARY KEY,
+   email VARCHAR (255) NOT NULL,
+   password_hash VARCHAR (255) NOT NULL,
+   roles TEXT[] NOT NULL DEFAULT '{}',
+   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (lower(email));
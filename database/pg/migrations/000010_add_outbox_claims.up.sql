@@ -0,0 +1,3 @@
+-- Events being published are claimed by the relaying instance until
+-- claimed_until, so that they are published with no transaction open.
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS webhook_deliveries_unqueued_idx;
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS queued_at;
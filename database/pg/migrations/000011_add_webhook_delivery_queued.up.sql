@@ -0,0 +1,6 @@
+-- Deliveries are recorded, then queued: those recorded but never queued
+-- are swept and queued later.
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS queued_at TIMESTAMP;
+UPDATE webhook_deliveries SET queued_at = updated_at WHERE status <> 'pending';
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_unqueued_idx ON webhook_deliveries (created_at) WHERE queued_at IS NULL;
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/dig"
+)
+
+const (
+	// SchemeAPIKey is the Authorization scheme of API keys.
+	SchemeAPIKey = "ApiKey"
+	// APIKeyKeyPrefix namespaces the API key lookups cached in Redis.
+	APIKeyKeyPrefix = "book_app:apikeys:"
+
+	// An API key is "bk_", its prefix, "_" and its secret, both hex.
+	apiKeyTag          = "bk_"
+	apiKeyPrefixBytes  = 4
+	apiKeySecretBytes  = 32
+	apiKeySubjectLabel = "apikey:"
+)
+
+// Scopes lists the permissions that can be granted as scopes.
+var Scopes = []string{ScopeBooksRead, ScopeBooksWrite, ScopeAdmin}
+
+type (
+	// APIKeys issues keys to machine clients and verifies the keys they
+	// send. Only a hash of each key is stored, found by the key prefix.
+	APIKeys interface {
+		// Issue stores k, filling in its ID, prefix and hash, and returns
+		// the key. The key can't be read again.
+		Issue(ctx context.Context, k *repo.APIKey) (string, error)
+		// Verify returns the stored key matching key, and records it as
+		// used. Unknown, revoked and expired keys are ErrUnauthorized.
+		Verify(ctx context.Context, key string) (repo.APIKey, error)
+		// Revoke revokes the key by given ID, at once on every instance.
+		Revoke(ctx context.Context, id uuid.UUID) (repo.APIKey, error)
+	}
+	APIKeysImpl struct {
+		dig.In
+		Repo  repo.APIKeyRepo
+		Redis *configs.RedisStorage
+	}
+	// APIKeyCfg configures the API key lookups.
+	APIKeyCfg struct {
+		// CacheTTL is how long a key looked up is kept in Redis.
+		CacheTTL time.Duration `env:"API_KEY_CACHE_TTL" envDefault:"5m"`
+		// TouchInterval is how often the last use of a key is recorded.
+		TouchInterval time.Duration `env:"API_KEY_TOUCH_INTERVAL" envDefault:"1m"`
+	}
+	apiKeys struct {
+		APIKeysImpl
+		cfg   APIKeyCfg
+		cache *configs.RedisStorage
+	}
+	// cachedAPIKey is a key as cached in Redis, hash included.
+	cachedAPIKey struct {
+		repo.APIKey
+		Hash string `json:"hash"`
+	}
+)
+
+func NewAPIKeys(impl APIKeysImpl) APIKeys {
+	cfg := APIKeyCfg{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Error().Err(err).Msg("auth: API key config")
+	}
+	return &apiKeys{APIKeysImpl: impl, cfg: cfg, cache: impl.Redis.Namespace(APIKeyKeyPrefix)}
+}
+
+func (s *apiKeys) Issue(ctx context.Context, k *repo.APIKey) (string, error) {
+	b := make([]byte, apiKeyPrefixBytes+apiKeySecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	k.ID = uuid.New()
+	k.Prefix = hex.EncodeToString(b[:apiKeyPrefixBytes])
+	key := apiKeyTag + k.Prefix + "_" + hex.EncodeToString(b[apiKeyPrefixBytes:])
+	k.Hash = hashAPIKey(key)
+	return key, s.Repo.CreateAPIKey(ctx, k)
+}
+
+func (s *apiKeys) Verify(ctx context.Context, key string) (repo.APIKey, error) {
+	prefix, ok := apiKeyPrefix(key)
+	if !ok {
+		return repo.APIKey{}, errs.New(errs.ErrUnauthorized, "invalid API key")
+	}
+	k, err := s.lookup(ctx, prefix)
+	if errors.Is(err, errs.ErrNotFound) {
+		return k, errs.New(errs.ErrUnauthorized, "invalid API key")
+	}
+	if err != nil {
+		return k, err
+	}
+
+	now := time.Now()
+	switch {
+	case subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(k.Hash)) != 1:
+		return k, errs.New(errs.ErrUnauthorized, "invalid API key")
+	case k.RevokedAt != nil:
+		return k, errs.New(errs.ErrUnauthorized, "API key is revoked")
+	case k.ExpiresAt != nil && !now.Before(*k.ExpiresAt):
+		return k, errs.New(errs.ErrUnauthorized, "API key is expired")
+	}
+	s.touch(ctx, &k, now)
+	return k, nil
+}
+
+func (s *apiKeys) Revoke(ctx context.Context, id uuid.UUID) (repo.APIKey, error) {
+	k, err := s.Repo.RevokeAPIKey(ctx, id, time.Now())
+	if err != nil {
+		return k, err
+	}
+	if err := s.cache.Delete(k.Prefix); err != nil {
+		log.Error().Err(err).Str("api_key", k.Prefix).Msg("auth: evict API key")
+	}
+	return k, nil
+}
+
+// lookup returns the key by given prefix, from Redis when cached there.
+func (s *apiKeys) lookup(ctx context.Context, prefix string) (repo.APIKey, error) {
+	if b, err := s.cache.Get(prefix); err == nil {
+		cached := cachedAPIKey{}
+		if err := json.Unmarshal(b, &cached); err == nil {
+			cached.APIKey.Hash = cached.Hash
+			return cached.APIKey, nil
+		}
+	} else if !errors.Is(err, fiber.ErrNotFound) {
+		log.Error().Err(err).Msg("auth: cached API key")
+	}
+
+	k, err := s.Repo.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return k, err
+	}
+	if b, err := json.Marshal(cachedAPIKey{APIKey: k, Hash: k.Hash}); err == nil {
+		if err := s.cache.Set(prefix, b, s.cfg.CacheTTL); err != nil {
+			log.Error().Err(err).Msg("auth: cache API key")
+		}
+	}
+	return k, nil
+}
+
+// touch records key k as used at now, at most once per TouchInterval
+// across instances.
+func (s *apiKeys) touch(ctx context.Context, k *repo.APIKey, now time.Time) {
+	ok, err := s.Redis.Client().SetNX(ctx, APIKeyKeyPrefix+"touched:"+k.Prefix, 1, s.cfg.TouchInterval).Result()
+	if err != nil || !ok {
+		return
+	}
+	if err := s.Repo.TouchAPIKey(ctx, k.ID, now); err != nil {
+		log.Error().Err(err).Str("api_key", k.Prefix).Msg("auth: record API key use")
+		return
+	}
+	k.LastUsedAt = &now
+}
+
+// apiKeyClaims returns the claims of the caller sending key k: its prefix
+// as subject, and its scopes.
+func apiKeyClaims(k *repo.APIKey) *Claims {
+	claims := &Claims{Scope: strings.Join(k.Scopes, " ")}
+	claims.Subject = apiKeySubjectLabel + k.Prefix
+	claims.ID = k.ID.String()
+	return claims
+}
+
+// apiKeyPrefix returns the prefix of key, and whether key is well formed.
+func apiKeyPrefix(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyTag) {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(key[len(apiKeyTag):], "_")
+	if !ok || len(prefix) != 2*apiKeyPrefixBytes || len(secret) != 2*apiKeySecretBytes {
+		return "", false
+	}
+	if _, err := hex.DecodeString(prefix + secret); err != nil {
+		return "", false
+	}
+	return prefix, true
+}
+
+// hashAPIKey returns the hex SHA-256 of key. Keys are random enough for a
+// fast hash to resist guessing.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,207 @@
+// Package auth identifies the callers of the API from the bearer tokens
+// they send, and authorizes them per route from their roles and scopes.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/reqctx"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/dig"
+)
+
+const (
+	// SchemeBearer is the Authorization scheme of tokens.
+	SchemeBearer = "Bearer"
+	// realm is reported in the WWW-Authenticate challenge.
+	realm = "book_app"
+	// claimsLocal holds the claims of the caller of the current request.
+	claimsLocal = "auth_claims"
+)
+
+type (
+	// Authenticator identifies callers from the Authorization header: a
+	// JWT signed with HS256, RS256 or EdDSA, or an API key.
+	Authenticator interface {
+		// Authenticate middleware puts the claims of the bearer token or
+		// API key of the request on the context, and its subject as the
+		// actor of the changes made. Requests without credentials go on
+		// anonymously; requests with invalid ones are refused with 401.
+		Authenticate(c *fiber.Ctx) error
+		// Authorize returns a middleware letting through the callers
+		// granted given permission. Others are refused, with 401 when
+		// anonymous and 403 otherwise, and the denial is logged.
+		Authorize(permission string) fiber.Handler
+		// Guard returns a router adding to each route registered through
+		// it the Authorize middleware of the permission policy requires.
+		Guard(r fiber.Router, policy Policy) fiber.Router
+	}
+	// Cfg configures the keys verifying tokens and the claims expected.
+	Cfg struct {
+		// Secret verifies HS256 tokens.
+		Secret string `env:"JWT_SECRET"`
+		// PublicKey is the RSA or Ed25519 public key in PEM verifying RS256
+		// or EdDSA tokens.
+		PublicKey string `env:"JWT_PUBLIC_KEY"`
+		// PrivateKey is the RSA or Ed25519 private key in PEM signing the
+		// tokens issued by the service, with RS256 or EdDSA. Its public key
+		// verifies them. Issued tokens are signed with Secret without it.
+		PrivateKey string `env:"JWT_PRIVATE_KEY"`
+		// JWKSFile is a local JWKS file holding more keys, picked by the
+		// key ID in the token header.
+		JWKSFile string `env:"JWT_JWKS_FILE"`
+		// Issuer and Audience, when set, must match the iss and aud claims.
+		Issuer   string `env:"JWT_ISSUER"`
+		Audience string `env:"JWT_AUDIENCE"`
+		// Leeway absorbs clock skew with the issuer.
+		Leeway time.Duration `env:"JWT_LEEWAY" envDefault:"30s"`
+		// AnonymousScopes lists the permissions granted to every caller,
+		// identified or not.
+		AnonymousScopes []string `env:"AUTH_ANONYMOUS_SCOPES" envDefault:"books:read" envSeparator:","`
+	}
+	// Claims are the claims of a token.
+	Claims struct {
+		jwt.RegisteredClaims
+		// Scope lists the scopes granted, space separated.
+		Scope string `json:"scope,omitempty"`
+		// Roles lists the roles of the subject.
+		Roles []string `json:"roles,omitempty"`
+	}
+	AuthenticatorImpl struct {
+		dig.In
+		APIKeys     APIKeys
+		Revocations Revocations
+	}
+	authenticator struct {
+		AuthenticatorImpl
+		cfg    Cfg
+		keys   *KeySet
+		parser *jwt.Parser
+	}
+)
+
+// NewAuthenticator returns an Authenticator verifying tokens with the keys
+// set in environment.
+func NewAuthenticator(impl AuthenticatorImpl) Authenticator {
+	cfg := Cfg{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Error().Err(err).Msg("auth: config")
+	}
+	keys, err := NewKeySet(&cfg)
+	if err != nil {
+		log.Error().Err(err).Msg("auth: keys")
+	}
+	if keys.Len() == 0 {
+		log.Warn().Msg("auth: no JWT key set, every token is refused")
+	}
+	return &authenticator{
+		AuthenticatorImpl: impl,
+		cfg:               cfg,
+		keys:              keys,
+		// Time claims are checked by Claims.validate, with leeway.
+		parser: jwt.NewParser(jwt.WithValidMethods(Algorithms), jwt.WithoutClaimsValidation()),
+	}
+}
+
+// ClaimsOf returns the claims of the caller of given request, nil for
+// anonymous requests.
+func ClaimsOf(c *fiber.Ctx) *Claims {
+	claims, _ := c.Locals(claimsLocal).(*Claims)
+	return claims
+}
+
+// Scopes returns the scopes granted by the claims.
+func (cl *Claims) Scopes() []string {
+	return strings.Fields(cl.Scope)
+}
+
+// HasScope reports whether the claims grant given scope.
+func (cl *Claims) HasScope(scope string) bool {
+	return contains(cl.Scopes(), scope)
+}
+
+func (a *authenticator) Authenticate(c *fiber.Ctx) error {
+	header := c.Get(fiber.HeaderAuthorization)
+	if header == "" {
+		return c.Next()
+	}
+	scheme, credentials, _ := strings.Cut(header, " ")
+	credentials = strings.TrimSpace(credentials)
+
+	var claims *Claims
+	switch {
+	case strings.EqualFold(scheme, SchemeBearer):
+		claims = &Claims{}
+		if _, err := a.parser.ParseWithClaims(credentials, claims, a.keys.Keyfunc); err != nil {
+			return unauthorized(c, "invalid_token", "invalid token")
+		}
+		if err := claims.validate(&a.cfg, time.Now()); err != nil {
+			return unauthorized(c, "invalid_token", err.Error())
+		}
+		revoked, err := a.Revocations.Revoked(c.UserContext(), claims)
+		if err != nil {
+			return errs.Wrap(errs.ErrUnavailable, err, "token revocations are unavailable")
+		}
+		if revoked {
+			return unauthorized(c, "invalid_token", "token is revoked")
+		}
+	case strings.EqualFold(scheme, SchemeAPIKey):
+		k, err := a.APIKeys.Verify(c.UserContext(), credentials)
+		if errors.Is(err, errs.ErrUnauthorized) {
+			var de *errs.Error
+			errors.As(err, &de)
+			return unauthorized(c, "", de.Public())
+		}
+		if err != nil {
+			return err
+		}
+		claims = apiKeyClaims(&k)
+	default:
+		return unauthorized(c, "", "unsupported authorization scheme")
+	}
+
+	c.Locals(claimsLocal, claims)
+	c.SetUserContext(reqctx.WithActor(c.UserContext(), claims.Subject))
+	return c.Next()
+}
+
+// validate checks the claims of a token whose signature is verified.
+func (cl *Claims) validate(cfg *Cfg, now time.Time) error {
+	switch {
+	case cl.ExpiresAt == nil:
+		return errors.New("token has no expiry")
+	case !cl.VerifyExpiresAt(now.Add(-cfg.Leeway), true):
+		return errors.New("token is expired")
+	case !cl.VerifyNotBefore(now.Add(cfg.Leeway), false):
+		return errors.New("token is not valid yet")
+	case !cl.VerifyIssuedAt(now.Add(cfg.Leeway), false):
+		return errors.New("token is issued in the future")
+	case cfg.Issuer != "" && !cl.VerifyIssuer(cfg.Issuer, true):
+		return errors.New("token has another issuer")
+	case cfg.Audience != "" && !cl.VerifyAudience(cfg.Audience, true):
+		return errors.New("token is for another audience")
+	case cl.Subject == "":
+		return errors.New("token has no subject")
+	}
+	return nil
+}
+
+// unauthorized returns the 401 error of given message, challenging the
+// client for a bearer token or an API key. code is the RFC 6750 error code
+// of the bearer token, if any.
+func unauthorized(c *fiber.Ctx, code, msg string) error {
+	challenge := fmt.Sprintf("%s realm=%q", SchemeBearer, realm)
+	if code != "" {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", code, msg)
+	}
+	challenge += fmt.Sprintf(", %s realm=%q", SchemeAPIKey, realm)
+	c.Set(fiber.HeaderWWWAuthenticate, challenge)
+	return errs.New(errs.ErrUnauthorized, msg)
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Algorithms lists the signing algorithms tokens are accepted with.
+var Algorithms = []string{
+	jwt.SigningMethodHS256.Alg(),
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+type (
+	// KeySet holds the keys verifying token signatures.
+	KeySet struct {
+		keys []verifyingKey
+	}
+	// verifyingKey is a key verifying the tokens signed with alg, and
+	// carrying kid in their header when it is set.
+	verifyingKey struct {
+		kid string
+		alg string
+		key interface{}
+	}
+	// jwk is a JSON Web Key (RFC 7517), as read from a JWKS file.
+	jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		// RSA keys.
+		N string `json:"n"`
+		E string `json:"e"`
+		// OKP keys.
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		// Symmetric keys.
+		K string `json:"k"`
+	}
+)
+
+// NewKeySet returns the keys set in cfg: the HS256 secret, the RS256 or
+// EdDSA public keys in PEM, of the private key too, and the keys of the
+// JWKS file.
+func NewKeySet(cfg *Cfg) (*KeySet, error) {
+	ks := &KeySet{}
+	if cfg.Secret != "" {
+		ks.keys = append(ks.keys, verifyingKey{alg: jwt.SigningMethodHS256.Alg(), key: []byte(cfg.Secret)})
+	}
+	if cfg.PublicKey != "" {
+		k, err := parsePublicKey([]byte(cfg.PublicKey))
+		if err != nil {
+			return ks, fmt.Errorf("JWT_PUBLIC_KEY: %w", err)
+		}
+		ks.keys = append(ks.keys, k)
+	}
+	if cfg.PrivateKey != "" {
+		_, _, public, err := signingKey(cfg)
+		if err != nil {
+			return ks, err
+		}
+		ks.keys = append(ks.keys, public)
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := readJWKS(cfg.JWKSFile)
+		if err != nil {
+			return ks, fmt.Errorf("JWT_JWKS_FILE: %w", err)
+		}
+		ks.keys = append(ks.keys, keys...)
+	}
+	return ks, nil
+}
+
+// Len returns the number of keys in ks.
+func (ks *KeySet) Len() int {
+	return len(ks.keys)
+}
+
+// Keyfunc returns the key verifying token t: the key for its algorithm
+// with the ID in its header, or the first key for its algorithm when its
+// header has no key ID.
+func (ks *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	alg := t.Method.Alg()
+	kid, _ := t.Header["kid"].(string)
+	for _, k := range ks.keys {
+		if k.alg == alg && (kid == "" || k.kid == kid) {
+			return k.key, nil
+		}
+	}
+	if kid != "" {
+		return nil, fmt.Errorf("no %s key with ID %q", alg, kid)
+	}
+	return nil, fmt.Errorf("no %s key", alg)
+}
+
+// SigningKey returns the method and key signing the tokens issued by the
+// service: the private key in cfg if set, the HS256 secret otherwise.
+func SigningKey(cfg *Cfg) (jwt.SigningMethod, interface{}, error) {
+	method, key, _, err := signingKey(cfg)
+	return method, key, err
+}
+
+// signingKey returns the method and key signing the tokens issued by the
+// service, and the key verifying them.
+func signingKey(cfg *Cfg) (jwt.SigningMethod, interface{}, verifyingKey, error) {
+	if cfg.PrivateKey == "" {
+		if cfg.Secret == "" {
+			return nil, nil, verifyingKey{}, errors.New("JWT_PRIVATE_KEY or JWT_SECRET must be set to issue tokens")
+		}
+		key := []byte(cfg.Secret)
+		return jwt.SigningMethodHS256, key, verifyingKey{alg: jwt.SigningMethodHS256.Alg(), key: key}, nil
+	}
+
+	pem := []byte(cfg.PrivateKey)
+	if k, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+		return jwt.SigningMethodRS256, k, verifyingKey{alg: jwt.SigningMethodRS256.Alg(), key: &k.PublicKey}, nil
+	}
+	if k, err := jwt.ParseEdPrivateKeyFromPEM(pem); err == nil {
+		if signer, ok := k.(ed25519.PrivateKey); ok {
+			return jwt.SigningMethodEdDSA, k, verifyingKey{alg: jwt.SigningMethodEdDSA.Alg(), key: signer.Public()}, nil
+		}
+	}
+	return nil, nil, verifyingKey{}, errors.New("JWT_PRIVATE_KEY: not an RSA or Ed25519 private key in PEM")
+}
+
+// parsePublicKey reads an RSA or Ed25519 public key in PEM.
+func parsePublicKey(pem []byte) (verifyingKey, error) {
+	if k, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+		return verifyingKey{alg: jwt.SigningMethodRS256.Alg(), key: k}, nil
+	}
+	if k, err := jwt.ParseEdPublicKeyFromPEM(pem); err == nil {
+		return verifyingKey{alg: jwt.SigningMethodEdDSA.Alg(), key: k}, nil
+	}
+	return verifyingKey{}, errors.New("not an RSA or Ed25519 public key in PEM")
+}
+
+// readJWKS reads the signing keys of a JWKS file. Keys of other types or
+// uses are skipped.
+func readJWKS(path string) ([]verifyingKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make([]verifyingKey, 0, len(set.Keys))
+	for i, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		k, err := j.verifyingKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		if k.key != nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// verifyingKey decodes j. Keys of a type or algorithm not in Algorithms
+// are returned without a key.
+func (j *jwk) verifyingKey() (verifyingKey, error) {
+	k := verifyingKey{kid: j.Kid}
+	switch {
+	case j.Kty == "RSA" && (j.Alg == "" || j.Alg == jwt.SigningMethodRS256.Alg()):
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return k, fmt.Errorf("n: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return k, fmt.Errorf("e: %w", err)
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return k, errors.New("e: invalid exponent")
+		}
+		k.alg = jwt.SigningMethodRS256.Alg()
+		k.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+	case j.Kty == "OKP" && j.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return k, fmt.Errorf("x: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return k, errors.New("x: invalid Ed25519 key size")
+		}
+		k.alg = jwt.SigningMethodEdDSA.Alg()
+		k.key = ed25519.PublicKey(x)
+	case j.Kty == "oct" && (j.Alg == "" || j.Alg == jwt.SigningMethodHS256.Alg()):
+		secret, err := base64.RawURLEncoding.DecodeString(j.K)
+		if err != nil {
+			return k, fmt.Errorf("k: %w", err)
+		}
+		k.alg = jwt.SigningMethodHS256.Alg()
+		k.key = secret
+	}
+	return k, nil
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/reqctx"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Permissions, granted as OAuth scopes or through roles.
+const (
+	// Public is the permission of routes open to every caller.
+	Public          = ""
+	ScopeBooksRead  = "books:read"
+	ScopeBooksWrite = "books:write"
+	// ScopeAdmin grants managing the service: webhooks, jobs and cache.
+	ScopeAdmin = "admin"
+)
+
+// Roles of callers.
+const (
+	RoleReader = "reader"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// RolePermissions lists the permissions granted by each role.
+var RolePermissions = map[string][]string{
+	RoleReader: {ScopeBooksRead},
+	RoleEditor: {ScopeBooksRead, ScopeBooksWrite},
+	RoleAdmin:  {ScopeBooksRead, ScopeBooksWrite, ScopeAdmin},
+}
+
+type (
+	// Policy maps routes, as "METHOD /path" relative to the router they are
+	// registered on, to the permission they require. HEAD routes require
+	// the permission of their GET route.
+	Policy map[string]string
+	// guardedRouter adds the Authorize middleware of the permission of
+	// each route registered through Add and the method functions. Routes
+	// registered through All, Static, Mount and Use are not guarded.
+	guardedRouter struct {
+		fiber.Router
+		auth   *authenticator
+		policy Policy
+		prefix string
+	}
+)
+
+// Permission returns the permission required by given route. It panics if
+// the route is missing from the policy, so that no route is left open by
+// mistake.
+func (p Policy) Permission(method, path string) string {
+	if method == fiber.MethodHead {
+		method = fiber.MethodGet
+	}
+	perm, ok := p[method+" "+path]
+	if !ok {
+		panic(fmt.Sprintf("auth: route %s %s is missing from the policy", method, path))
+	}
+	return perm
+}
+
+// Grants reports whether the claims grant given permission, through their
+// scopes or their roles.
+func (cl *Claims) Grants(permission string) bool {
+	if cl == nil {
+		return false
+	}
+	if cl.HasScope(permission) {
+		return true
+	}
+	for _, role := range cl.Roles {
+		if contains(RolePermissions[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *authenticator) Authorize(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := ClaimsOf(c)
+		if permission == Public || contains(a.cfg.AnonymousScopes, permission) || claims.Grants(permission) {
+			return c.Next()
+		}
+
+		audit(c, claims, permission)
+		if claims == nil {
+			return unauthorized(c, "", "authentication required")
+		}
+		c.Set(fiber.HeaderWWWAuthenticate, fmt.Sprintf(`%s realm=%q, error="insufficient_scope", scope=%q`,
+			SchemeBearer, realm, permission))
+		return errs.New(errs.ErrForbidden, fmt.Sprintf("permission %s is required", permission))
+	}
+}
+
+func (a *authenticator) Guard(r fiber.Router, policy Policy) fiber.Router {
+	return &guardedRouter{Router: r, auth: a, policy: policy}
+}
+
+func (g *guardedRouter) Add(method, path string, handlers ...fiber.Handler) fiber.Router {
+	if perm := g.policy.Permission(method, g.prefix+path); perm != Public {
+		handlers = append([]fiber.Handler{g.auth.Authorize(perm)}, handlers...)
+	}
+	g.Router.Add(method, path, handlers...)
+	return g
+}
+
+func (g *guardedRouter) Get(path string, handlers ...fiber.Handler) fiber.Router {
+	g.Add(fiber.MethodHead, path, handlers...)
+	return g.Add(fiber.MethodGet, path, handlers...)
+}
+
+func (g *guardedRouter) Head(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Add(fiber.MethodHead, path, handlers...)
+}
+
+func (g *guardedRouter) Post(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Add(fiber.MethodPost, path, handlers...)
+}
+
+func (g *guardedRouter) Put(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Add(fiber.MethodPut, path, handlers...)
+}
+
+func (g *guardedRouter) Patch(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Add(fiber.MethodPatch, path, handlers...)
+}
+
+func (g *guardedRouter) Delete(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Add(fiber.MethodDelete, path, handlers...)
+}
+
+func (g *guardedRouter) Connect(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Add(fiber.MethodConnect, path, handlers...)
+}
+
+func (g *guardedRouter) Options(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Add(fiber.MethodOptions, path, handlers...)
+}
+
+func (g *guardedRouter) Trace(path string, handlers ...fiber.Handler) fiber.Router {
+	return g.Add(fiber.MethodTrace, path, handlers...)
+}
+
+func (g *guardedRouter) Group(prefix string, handlers ...fiber.Handler) fiber.Router {
+	return &guardedRouter{
+		Router: g.Router.Group(prefix, handlers...),
+		auth:   g.auth,
+		policy: g.policy,
+		prefix: g.prefix + prefix,
+	}
+}
+
+func (g *guardedRouter) Route(prefix string, fn func(router fiber.Router), name ...string) fiber.Router {
+	group := g.Group(prefix)
+	if len(name) > 0 {
+		group.Name(name[0])
+	}
+	fn(group)
+	return group
+}
+
+// audit logs the denial of given permission to the caller of c.
+func audit(c *fiber.Ctx, claims *Claims, permission string) {
+	event := log.Warn().
+		Str("actor", reqctx.Actor(c.UserContext())).
+		Str("permission", permission).
+		Str("method", c.Method()).
+		Str("path", c.Path()).
+		Str("ip", c.IP()).
+		Str("request_id", reqctx.RequestID(c.UserContext()))
+	if claims != nil {
+		event = event.Strs("roles", claims.Roles).Str("scope", claims.Scope)
+	}
+	event.Msg("auth: access denied")
+}
+
+// contains reports whether s is in list.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
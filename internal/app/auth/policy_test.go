@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestPolicyPermission(t *testing.T) {
+	p := Policy{
+		"GET /books":  ScopeBooksRead,
+		"POST /book":  ScopeBooksWrite,
+		"POST /login": Public,
+	}
+	for _, tt := range []struct {
+		method, path, want string
+	}{
+		{fiber.MethodGet, "/books", ScopeBooksRead},
+		{fiber.MethodHead, "/books", ScopeBooksRead},
+		{fiber.MethodPost, "/book", ScopeBooksWrite},
+		{fiber.MethodPost, "/login", Public},
+	} {
+		if got := p.Permission(tt.method, tt.path); got != tt.want {
+			t.Errorf("Permission(%s, %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyPermissionPanicsOnUnlistedRoute(t *testing.T) {
+	p := Policy{"GET /books": ScopeBooksRead}
+	for _, tt := range []struct{ method, path string }{
+		{fiber.MethodPost, "/books"},
+		{fiber.MethodGet, "/book/:id"},
+		{fiber.MethodDelete, "/books"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Permission(%s, %s) did not panic", tt.method, tt.path)
+				}
+			}()
+			p.Permission(tt.method, tt.path)
+		}()
+	}
+}
+
+func TestClaimsGrants(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		claims *Claims
+		grants []string
+	}{
+		{"anonymous", nil, nil},
+		{"no role nor scope", &Claims{}, nil},
+		{"reader", &Claims{Roles: []string{RoleReader}}, []string{ScopeBooksRead}},
+		{"editor", &Claims{Roles: []string{RoleEditor}}, []string{ScopeBooksRead, ScopeBooksWrite}},
+		{"admin", &Claims{Roles: []string{RoleAdmin}}, []string{ScopeBooksRead, ScopeBooksWrite, ScopeAdmin}},
+		{"unknown role", &Claims{Roles: []string{"owner"}}, nil},
+		{"write scope", &Claims{Scope: ScopeBooksWrite}, []string{ScopeBooksWrite}},
+		{"scopes", &Claims{Scope: ScopeBooksRead + " " + ScopeAdmin}, []string{ScopeBooksRead, ScopeAdmin}},
+		{"reader with write scope", &Claims{Roles: []string{RoleReader}, Scope: ScopeBooksWrite},
+			[]string{ScopeBooksRead, ScopeBooksWrite}},
+		{"reader and editor", &Claims{Roles: []string{RoleReader, RoleEditor}}, []string{ScopeBooksRead, ScopeBooksWrite}},
+		{"scope as a role", &Claims{Roles: []string{ScopeBooksWrite}}, nil},
+		{"role as a scope", &Claims{Scope: RoleEditor}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, perm := range Scopes {
+				want := contains(tt.grants, perm)
+				if got := tt.claims.Grants(perm); got != want {
+					t.Errorf("Grants(%s) = %v, want %v", perm, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// RevocationKeyPrefix namespaces the revoked token IDs in Redis.
+	RevocationKeyPrefix = "book_app:revoked:"
+	// revocationMargin keeps revocations past the expiry of tokens, which
+	// are accepted a leeway longer.
+	revocationMargin = 5 * time.Minute
+)
+
+type (
+	// Revocations lists the tokens revoked before they expire, by ID. Tokens
+	// without ID can't be revoked.
+	Revocations interface {
+		// Revoke revokes the token of given claims until it expires.
+		Revoke(ctx context.Context, claims *Claims) error
+		// Revoked reports whether the token of given claims is revoked.
+		Revoked(ctx context.Context, claims *Claims) (bool, error)
+	}
+	revocations struct {
+		client redis.UniversalClient
+	}
+)
+
+// NewRevocations returns Revocations kept in Redis.
+func NewRevocations(redis *configs.RedisStorage) Revocations {
+	return &revocations{client: redis.Client()}
+}
+
+func (r *revocations) Revoke(ctx context.Context, claims *Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	ttl := time.Until(claims.ExpiresAt.Time) + revocationMargin
+	if ttl <= revocationMargin {
+		return nil
+	}
+	return r.client.Set(ctx, RevocationKeyPrefix+claims.ID, 1, ttl).Err()
+}
+
+func (r *revocations) Revoked(ctx context.Context, claims *Claims) (bool, error) {
+	if claims.ID == "" {
+		return false, nil
+	}
+	n, err := r.client.Exists(ctx, RevocationKeyPrefix+claims.ID).Result()
+	return n > 0, err
+}
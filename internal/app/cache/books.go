@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	fibercache "github.com/gofiber/fiber/v2/middleware/cache"
+)
+
+const (
+	// Expiration is how long a cached response is served.
+	Expiration = 30 * time.Minute
+	// KeyPrefix namespaces the cache keys in Redis.
+	KeyPrefix = "book_app:cache:"
+
+	// cacheHeader reports whether a response was served from the cache.
+	cacheHeader = "X-Cache"
+	// keyLocal holds the cache key computed for the current request.
+	keyLocal = "cache_key"
+
+	collectionGenKey = "books:gen"
+	itemGenKeyFmt    = "book:%s:gen"
+)
+
+type (
+	// BookCache caches GET responses of the books collection and of each
+	// book, and evicts them when books change.
+	//
+	// Keys are scoped by a generation stored next to the cached responses.
+	// Evicting a scope moves its generation on, so every response cached
+	// under the old one stops being served, on every instance sharing the
+	// storage, and expires on its own.
+	BookCache interface {
+		// Collection caches responses of routes listing books.
+		Collection() fiber.Handler
+		// Item caches responses of routes about the book in given param.
+		Item(param string) fiber.Handler
+		// EvictBooks evicts every cached listing of books.
+		EvictBooks()
+		// EvictBook evicts everything cached about given book, listings
+		// included.
+		EvictBook(id uuid.UUID)
+		// Stats returns the cache counters.
+		Stats() Stats
+		// StatsHandler serves the cache counters.
+		StatsHandler(c *fiber.Ctx) error
+	}
+	// Stats counts cache lookups and evictions since start.
+	Stats struct {
+		Hits      uint64 `json:"hits"`
+		Misses    uint64 `json:"misses"`
+		Evictions uint64 `json:"evictions"`
+	}
+	bookCache struct {
+		storage fiber.Storage
+		handler fiber.Handler
+
+		hits, misses, evictions uint64
+	}
+)
+
+// NewBookCache returns a BookCache backed by Redis.
+func NewBookCache(redis *configs.RedisStorage) BookCache {
+	return newBookCache(redis.Namespace(KeyPrefix))
+}
+
+func newBookCache(storage fiber.Storage) *bookCache {
+	bc := &bookCache{storage: storage}
+	bc.handler = fibercache.New(fibercache.Config{
+		Next: func(c *fiber.Ctx) bool {
+			_, ok := c.Locals(keyLocal).(string)
+			return !ok || c.Query("refresh") == "true"
+		},
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return c.Locals(keyLocal).(string)
+		},
+		Methods:      []string{fiber.MethodGet},
+		Expiration:   Expiration,
+		CacheControl: true,
+		CacheHeader:  cacheHeader,
+		Storage:      storage,
+		// Keep the ETag of cached books.
+		StoreResponseHeaders: true,
+	})
+	return bc
+}
+
+func (bc *bookCache) Collection() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Listings of deleted books are for admins only: served from the
+		// cache, they would skip that check.
+		if c.QueryBool("include_deleted") {
+			return c.Next()
+		}
+		gen, ok := bc.generation(collectionGenKey)
+		if !ok {
+			return c.Next()
+		}
+		return bc.serve(c, "books:"+gen+":"+c.OriginalURL())
+	}
+}
+
+func (bc *bookCache) Item(param string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params(param))
+		if err != nil {
+			return c.Next()
+		}
+		gen, ok := bc.generation(itemGenKey(id))
+		if !ok {
+			return c.Next()
+		}
+		return bc.serve(c, "book:"+id.String()+":"+gen+":"+c.OriginalURL())
+	}
+}
+
+// serve runs the cache middleware for given key and counts the outcome.
+func (bc *bookCache) serve(c *fiber.Ctx, key string) error {
+	c.Locals(keyLocal, key)
+	err := bc.handler(c)
+	switch string(c.Response().Header.Peek(cacheHeader)) {
+	case "hit":
+		atomic.AddUint64(&bc.hits, 1)
+	case "miss":
+		atomic.AddUint64(&bc.misses, 1)
+	}
+	return err
+}
+
+// generation returns the current generation stored at given key, and false
+// when the storage can't be reached so the request bypasses the cache.
+func (bc *bookCache) generation(key string) (string, bool) {
+	gen, err := bc.storage.Get(key)
+	switch err {
+	case nil:
+		return string(gen), true
+	case fiber.ErrNotFound:
+		return "0", true
+	}
+	log.Warn().Err(err).Str("key", key).Msg("cache: read generation")
+	return "", false
+}
+
+func (bc *bookCache) EvictBooks() {
+	bc.evict(collectionGenKey)
+}
+
+func (bc *bookCache) EvictBook(id uuid.UUID) {
+	bc.evict(itemGenKey(id))
+	bc.evict(collectionGenKey)
+}
+
+// evict moves the generation stored at given key on.
+func (bc *bookCache) evict(key string) {
+	if err := bc.storage.Set(key, []byte(uuid.NewString()), 0); err != nil {
+		log.Error().Err(err).Str("key", key).Msg("cache: evict")
+		return
+	}
+	atomic.AddUint64(&bc.evictions, 1)
+}
+
+func (bc *bookCache) Stats() Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&bc.hits),
+		Misses:    atomic.LoadUint64(&bc.misses),
+		Evictions: atomic.LoadUint64(&bc.evictions),
+	}
+}
+
+// StatsHandler func gets the response cache counters.
+// @Description Get hit, miss and eviction counters of the response cache.
+// @Summary get response cache counters
+// @Tags Cache
+// @Accept json
+// @Produce json
+// @Success 200 {object} cache.Stats
+// @Security ApiKeyAuth
+// @Router /v1/cache/stats [get]
+func (bc *bookCache) StatsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"error": false,
+		"msg":   nil,
+		"stats": bc.Stats(),
+	})
+}
+
+func itemGenKey(id uuid.UUID) string {
+	return fmt.Sprintf(itemGenKeyFmt, id)
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/internal/app/auth"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/reqctx"
+	"github.com/caohoangphuctd97/go-test/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/dig"
+)
+
+type (
+	APIKeySvc interface {
+		GetAPIKeys(c *fiber.Ctx) error
+		CreateAPIKey(c *fiber.Ctx) error
+		DeleteAPIKey(c *fiber.Ctx) error
+	}
+	// APIKeySvcImpl is implementation of APIKeySvc
+	APIKeySvcImpl struct {
+		dig.In
+		Repo    repo.APIKeyRepo
+		APIKeys auth.APIKeys
+	}
+	// apiKeyRequest is the body issuing an API key.
+	apiKeyRequest struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		// ExpiresAt is when the key stops being accepted. Keys without one
+		// are accepted until revoked.
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+)
+
+func NewAPIKeySvc(impl APIKeySvcImpl) APIKeySvc {
+	return &impl
+}
+
+// GetAPIKeys func gets every API key.
+// @Description Get every API key, revoked ones included, latest first. Keys themselves are not shown.
+// @Summary get all API keys
+// @Tags API keys
+// @Produce json
+// @Success 200 {array} repo.APIKey
+// @Security ApiKeyAuth
+// @Router /v1/admin/api-keys [get]
+func (s *APIKeySvcImpl) GetAPIKeys(c *fiber.Ctx) error {
+	keys, err := s.Repo.GetAPIKeys(c.UserContext())
+	if err != nil {
+		return err
+	}
+
+	// Return status 200 OK.
+	return c.JSON(fiber.Map{
+		"error":    false,
+		"msg":      nil,
+		"count":    len(keys),
+		"api_keys": keys,
+	})
+}
+
+// CreateAPIKey func for issues a new API key.
+// @Description Issue an API key granted given scopes, until given expiry if any. The key is sent
+// @Description as "Authorization: ApiKey <key>", and shown in this response only.
+// @Summary issue a new API key
+// @Tags API keys
+// @Accept json
+// @Produce json
+// @Param body body object true "Name, scopes and expires_at"
+// @Success 201 {object} repo.APIKey
+// @Security ApiKeyAuth
+// @Router /v1/admin/api-keys [post]
+func (s *APIKeySvcImpl) CreateAPIKey(c *fiber.Ctx) error {
+	req := apiKeyRequest{}
+
+	// Check, if received JSON data is valid.
+	if err := c.BodyParser(&req); err != nil {
+		return bodyErr(err)
+	}
+
+	// Set initialized default data for API key:
+	k := &repo.APIKey{
+		Name:      req.Name,
+		Scopes:    []string{},
+		CreatedBy: reqctx.Actor(c.UserContext()),
+		CreatedAt: time.Now(),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := req.validate(k); err != nil {
+		return err
+	}
+
+	// Issue API key.
+	key, err := s.APIKeys.Issue(c.UserContext(), k)
+	if err != nil {
+		return err
+	}
+
+	// Return status 201 Created.
+	c.Location("/api/v1/admin/api-keys/" + k.ID.String())
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"error":   false,
+		"msg":     nil,
+		"key":     key,
+		"api_key": k,
+	})
+}
+
+// DeleteAPIKey func for revokes API key by given ID.
+// @Description Revoke API key by given ID. It is refused at once, and kept in the listing.
+// @Summary revoke API key by given ID
+// @Tags API keys
+// @Param id path string true "API key ID"
+// @Success 204 {string} status "ok"
+// @Security ApiKeyAuth
+// @Router /v1/admin/api-keys/{id} [delete]
+func (s *APIKeySvcImpl) DeleteAPIKey(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return errs.Wrap(errs.ErrValidation, err, "API key ID must be a UUID")
+	}
+
+	// Revoke API key by given ID.
+	_, err = s.APIKeys.Revoke(c.UserContext(), id)
+	if errors.Is(err, errs.ErrNotFound) {
+		return errs.Wrap(errs.ErrNotFound, err, "API key with the given ID is not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	// Return status 204 no content.
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// validate checks request r and sets its scopes on k.
+func (r *apiKeyRequest) validate(k *repo.APIKey) error {
+	known := map[string]bool{}
+	for _, scope := range auth.Scopes {
+		known[scope] = true
+	}
+
+	fields := []errs.FieldError{}
+	seen := map[string]bool{}
+	for i, scope := range r.Scopes {
+		if !known[scope] {
+			fields = append(fields, errs.FieldError{
+				Name:    "Scopes",
+				Pointer: fmt.Sprintf("/scopes/%d", i),
+				Detail:  fmt.Sprintf("unknown scope %q, scopes must be %s", scope, strings.Join(auth.Scopes, ", ")),
+			})
+			continue
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			k.Scopes = append(k.Scopes, scope)
+		}
+	}
+	if len(r.Scopes) == 0 {
+		fields = append(fields, errs.FieldError{Name: "Scopes", Pointer: "/scopes", Detail: "scopes is required"})
+	}
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(k.CreatedAt) {
+		fields = append(fields, errs.FieldError{Name: "ExpiresAt", Pointer: "/expires_at", Detail: "expires_at must be in the future"})
+	}
+	if len(fields) > 0 {
+		return errs.Invalid(fields...)
+	}
+
+	// Validate API key fields.
+	if err := utils.NewValidator().Struct(k); err != nil {
+		return utils.ValidationError(err)
+	}
+	return nil
+}
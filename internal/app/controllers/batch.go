@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Batch modes.
+const (
+	// batchAtomic applies every operation or none.
+	batchAtomic = "atomic"
+	// batchBestEffort applies every operation that can be.
+	batchBestEffort = "best_effort"
+)
+
+type (
+	// batchRequest is the body of a batch of book operations.
+	batchRequest struct {
+		Mode       string           `json:"mode"`
+		Operations []batchOperation `json:"operations"`
+	}
+	// batchOperation is one operation of a batchRequest.
+	batchOperation struct {
+		Op      string     `json:"op"`
+		ID      string     `json:"id"`
+		Version int        `json:"version"`
+		Book    *repo.Book `json:"book"`
+	}
+	// batchResult is the outcome of one operation of a batchRequest.
+	batchResult struct {
+		Index  int              `json:"index"`
+		Op     string           `json:"op"`
+		Status int              `json:"status"`
+		Book   *repo.Book       `json:"book,omitempty"`
+		Error  *configs.Problem `json:"error,omitempty"`
+	}
+)
+
+// BatchBooks func for creates, updates and deletes books in one go.
+// @Description Run create, update and delete operations on books in a single transaction.
+// @Description In atomic mode (the default) any failing operation rolls the whole batch back;
+// @Description in best_effort mode every operation that can be applied is. Update and delete
+// @Description operations give the version of the book they are based on.
+// @Summary create, update and delete books in one go
+// @Tags Books
+// @Accept json
+// @Produce json
+// @Param body body object true "Mode and operations"
+// @Success 200 {array} object "every operation was applied"
+// @Success 207 {array} object "some operations failed"
+// @Failure 422 {object} object "the batch was rolled back, every operation reported in results"
+// @Security ApiKeyAuth
+// @Router /v1/books:batch [post]
+func (b *BookSvcImpl) BatchBooks(c *fiber.Ctx) error {
+	req := batchRequest{}
+
+	// Check, if received JSON data is valid.
+	if err := c.BodyParser(&req); err != nil {
+		return bodyErr(err)
+	}
+	switch req.Mode {
+	case "":
+		req.Mode = batchAtomic
+	case batchAtomic, batchBestEffort:
+	default:
+		return errs.New(errs.ErrValidation, "mode must be atomic or best_effort")
+	}
+	if len(req.Operations) == 0 {
+		return errs.New(errs.ErrValidation, "batch holds no operation")
+	}
+	if len(req.Operations) > repo.MaxBatchSize {
+		return errs.New(errs.ErrValidation, fmt.Sprintf("a batch cannot hold more than %d operations", repo.MaxBatchSize))
+	}
+
+	// Check every operation, keeping the valid ones.
+	results := make([]batchResult, len(req.Operations))
+	ops := make([]repo.BookOp, 0, len(req.Operations))
+	indexes := make([]int, 0, len(req.Operations))
+	invalid := false
+	for i, o := range req.Operations {
+		results[i] = batchResult{Index: i, Op: o.Op}
+		op, err := batchOp(i, o)
+		if err != nil {
+			results[i].fail(c, err)
+			invalid = true
+			continue
+		}
+		ops = append(ops, op)
+		indexes = append(indexes, i)
+	}
+
+	atomic := req.Mode == batchAtomic
+	if invalid && atomic {
+		return batchAborted(c, results)
+	}
+
+	// Run valid operations.
+	outcomes, err := b.Repo.ExecBatch(c.UserContext(), ops, atomic)
+	if err != nil && !errors.Is(err, repo.ErrBatchAborted) {
+		return err
+	}
+	for k, out := range outcomes {
+		r := &results[indexes[k]]
+		if out.Err != nil {
+			r.fail(c, out.Err)
+			invalid = true
+		} else if out.Book != nil {
+			r.succeed(out.Book)
+		}
+	}
+	if err != nil {
+		return batchAborted(c, results)
+	}
+
+	// Evict cached books and listings.
+	b.Cache.EvictBooks()
+	for k, op := range ops {
+		if outcomes[k].Err == nil && op.Action != repo.ActionCreate {
+			b.Cache.EvictBook(op.ID)
+		}
+	}
+
+	// Return status 200 OK, or 207 when some operations failed.
+	status := fiber.StatusOK
+	if invalid {
+		status = fiber.StatusMultiStatus
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error":   false,
+		"msg":     nil,
+		"count":   len(results),
+		"results": results,
+	})
+}
+
+// batchOp checks operation at given index of a batch and turns it into a
+// repository operation.
+func batchOp(i int, o batchOperation) (repo.BookOp, error) {
+	op := repo.BookOp{Action: o.Op, Version: o.Version, Book: o.Book}
+	switch o.Op {
+	case repo.ActionCreate:
+		op.ID = uuid.New()
+	case repo.ActionUpdate, repo.ActionDelete:
+		id, err := uuid.Parse(o.ID)
+		if err != nil {
+			return op, errs.Wrap(errs.ErrValidation, err, "book ID must be a UUID")
+		}
+		if o.Version < 1 {
+			return op, errs.New(errs.ErrValidation, "version must be the current version of the book")
+		}
+		op.ID = id
+	default:
+		return op, errs.New(errs.ErrValidation, "op must be create, update or delete")
+	}
+	if o.Op == repo.ActionDelete {
+		return op, nil
+	}
+
+	if op.Book == nil {
+		return op, errs.New(errs.ErrValidation, "book is required")
+	}
+
+	// Set initialized default data for book:
+	now := time.Now()
+	op.Book.ID = op.ID
+	op.Book.UpdatedAt = now
+	op.Book.DeletedAt = nil
+	if o.Op == repo.ActionCreate {
+		op.Book.CreatedAt = now
+		op.Book.Version = 1
+	}
+
+	// Validate book fields.
+	if err := utils.NewValidator().Struct(op.Book); err != nil {
+		verr := utils.ValidationError(err)
+		var de *errs.Error
+		if errors.As(verr, &de) {
+			for k := range de.Fields {
+				de.Fields[k].Pointer = fmt.Sprintf("/operations/%d/book%s", i, de.Fields[k].Pointer)
+			}
+		}
+		return op, verr
+	}
+	return op, nil
+}
+
+// batchAborted returns the error replied to an atomic batch that was rolled
+// back, its results telling every operation that did not fail as not
+// applied.
+func batchAborted(c *fiber.Ctx, results []batchResult) error {
+	for i := range results {
+		if results[i].Error == nil {
+			results[i].Book = nil
+			results[i].fail(c, fiber.NewError(fiber.StatusFailedDependency, "not applied, the batch was rolled back"))
+		}
+	}
+	return repo.ErrBatchAborted.With("results", results)
+}
+
+// succeed records the book an operation left.
+func (r *batchResult) succeed(book *repo.Book) {
+	switch r.Op {
+	case repo.ActionCreate:
+		r.Status = fiber.StatusCreated
+		r.Book = book
+	case repo.ActionUpdate:
+		r.Status = fiber.StatusOK
+		r.Book = book
+	default:
+		r.Status = fiber.StatusNoContent
+	}
+}
+
+// fail records why an operation failed.
+func (r *batchResult) fail(c *fiber.Ctx, err error) {
+	p := configs.NewProblem(c, err)
+	r.Status = p.Status
+	r.Error = &p
+}
@@ -1,9 +1,16 @@
 package controllers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/caohoangphuctd97/go-test/internal/app/cache"
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/caohoangphuctd97/go-test/internal/app/jobs"
 	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
 	"github.com/caohoangphuctd97/go-test/pkg/utils"
 	"go.uber.org/dig"
 
@@ -16,48 +23,123 @@ type (
 		GetBooks(c *fiber.Ctx) error
 		GetBook(c *fiber.Ctx) error
 		UpdateBook(c *fiber.Ctx) error
+		PatchBook(c *fiber.Ctx) error
 		CreateBook(c *fiber.Ctx) error
 		DeleteBook(c *fiber.Ctx) error
+		RestoreBook(c *fiber.Ctx) error
+		GetHistory(c *fiber.Ctx) error
+		RevertBook(c *fiber.Ctx) error
+		BatchBooks(c *fiber.Ctx) error
+		ExportBooks(c *fiber.Ctx) error
+		QueueExport(c *fiber.Ctx) error
+		ImportBooks(c *fiber.Ctx) error
+		SearchBooks(c *fiber.Ctx) error
 	}
 	// BookSvcImpl is implementation of BookSvc
 	BookSvcImpl struct {
 		dig.In
-		Repo repo.BookRepo
+		Repo  repo.BookRepo
+		Cache cache.BookCache
+		Tx    databases.TxManager
+		Jobs  jobs.Queue
+		// Cfg bounds the transactions the service runs itself.
+		Cfg *databases.DatabaseCfg `name:"pg"`
 	}
 )
 
 func NewBookSvc(impl BookSvcImpl) BookSvc {
-	return &impl
+	svc := &impl
+	svc.Jobs.Register(jobImportBooks, svc.importJob)
+	svc.Jobs.Register(jobExportBooks, svc.exportJob)
+	return svc
 }
 
-// GetBooks func gets all exists books.
-// @Description Get all exists books.
-// @Summary get all exists books
+// GetBooks func gets one page of exists books.
+// @Description Get one page of exists books, optionally filtered and sorted.
+// @Summary get a page of exists books
 // @Tags Books
 // @Accept json
 // @Produce json
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of books to skip"
+// @Param cursor query string false "Opaque cursor from next_cursor or prev_cursor"
+// @Param sort query string false "Sort column: title, author, created_at or updated_at"
+// @Param order query string false "Sort direction: asc or desc"
+// @Param author query string false "Exact author"
+// @Param title_contains query string false "Case-insensitive title substring"
+// @Param created_after query string false "RFC 3339 timestamp"
+// @Param created_before query string false "RFC 3339 timestamp"
+// @Param include_deleted query bool false "List deleted books too, for admins only"
 // @Success 200
+// @Failure 403 {string} status "include_deleted without the admin permission"
 // @Router /v1/books [get]
 func (b *BookSvcImpl) GetBooks(c *fiber.Ctx) error {
+	// Build the listing filter from query string.
+	filter, err := bookFilter(c)
+	if err != nil {
+		return err
+	}
 
-	// Get all books.
-	books, err := b.Repo.GetBooks()
+	// Get one page of books.
+	page, err := b.Repo.GetBooks(c.UserContext(), filter)
 	if err != nil {
-		// Return, if books not found.
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": true,
-			"msg":   "books were not found",
-			"count": 0,
-			"books": nil,
-		})
+		return err
 	}
 
 	// Return status 200 OK.
 	return c.JSON(fiber.Map{
-		"error": false,
-		"msg":   nil,
-		"count": len(books),
-		"books": books,
+		"error":       false,
+		"msg":         nil,
+		"count":       len(page.Books),
+		"total":       page.Total,
+		"next_cursor": nullable(page.NextCursor),
+		"prev_cursor": nullable(page.PrevCursor),
+		"books":       page.Books,
+	})
+}
+
+// SearchBooks func searches books by title and author.
+// @Description Full-text search over title and author, best match first.
+// @Summary search books
+// @Tags Books
+// @Accept json
+// @Produce json
+// @Param q query string true "Search terms, each matched as a prefix"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of results to skip"
+// @Param cursor query string false "Opaque cursor from next_cursor or prev_cursor"
+// @Success 200
+// @Router /v1/books/search [get]
+func (b *BookSvcImpl) SearchBooks(c *fiber.Ctx) error {
+	// Build the search from query string.
+	q := searchQuery{}
+	if err := c.QueryParser(&q); err != nil {
+		return errs.Wrap(errs.ErrValidation, err, err.Error())
+	}
+	if q.Limit > repo.MaxPageLimit {
+		return errs.New(errs.ErrValidation, fmt.Sprintf("limit must not exceed %d", repo.MaxPageLimit))
+	}
+
+	// Search books.
+	page, err := b.Repo.SearchBooks(c.UserContext(), repo.BookSearch{
+		Query:  q.Q,
+		Limit:  q.Limit,
+		Offset: q.Offset,
+		Cursor: q.Cursor,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Return status 200 OK.
+	return c.JSON(fiber.Map{
+		"error":       false,
+		"msg":         nil,
+		"count":       len(page.Books),
+		"total":       page.Total,
+		"next_cursor": nullable(page.NextCursor),
+		"prev_cursor": nullable(page.PrevCursor),
+		"books":       page.Books,
 	})
 }
 
@@ -72,26 +154,19 @@ func (b *BookSvcImpl) GetBooks(c *fiber.Ctx) error {
 // @Router /v1/book/{id} [get]
 func (b *BookSvcImpl) GetBook(c *fiber.Ctx) error {
 	// Catch book ID from URL.
-	id, err := uuid.Parse(c.Params("id"))
+	id, err := bookID(c)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": true,
-			"msg":   err.Error(),
-		})
+		return err
 	}
 
 	// Get book by ID.
-	book, err := b.Repo.GetBook(id)
+	book, err := b.findBook(c.UserContext(), id)
 	if err != nil {
-		// Return, if book not found.
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": true,
-			"msg":   "book with the given ID is not found",
-			"book":  nil,
-		})
+		return err
 	}
 
 	// Return status 200 OK.
+	c.Set(fiber.HeaderETag, etag(book.Version))
 	return c.JSON(fiber.Map{
 		"error": false,
 		"msg":   nil,
@@ -107,6 +182,7 @@ func (b *BookSvcImpl) GetBook(c *fiber.Ctx) error {
 // @Produce json
 // @Param body body models.Book true "Book payload"
 // @Success 200 {object} models.Book
+// @Security ApiKeyAuth
 // @Router /v1/book [post]
 func (b *BookSvcImpl) CreateBook(c *fiber.Ctx) error {
 
@@ -115,11 +191,7 @@ func (b *BookSvcImpl) CreateBook(c *fiber.Ctx) error {
 
 	// Check, if received JSON data is valid.
 	if err := c.BodyParser(book); err != nil {
-		// Return status 400 and error message.
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": true,
-			"msg":   err.Error(),
-		})
+		return bodyErr(err)
 	}
 
 	// Create a new validator for a Book model.
@@ -129,26 +201,24 @@ func (b *BookSvcImpl) CreateBook(c *fiber.Ctx) error {
 	book.ID = uuid.New()
 	book.CreatedAt = time.Now()
 	book.UpdatedAt = time.Now()
+	book.Version = 1
+	book.DeletedAt = nil
 
 	// Validate book fields.
 	if err := validate.Struct(book); err != nil {
-		// Return, if some fields are not valid.
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": true,
-			"msg":   utils.ValidatorErrors(err),
-		})
+		return utils.ValidationError(err)
 	}
 
 	// Create book by given model.
-	if err := b.Repo.CreateBook(book); err != nil {
-		// Return status 500 and error message.
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": true,
-			"msg":   err.Error(),
-		})
+	if err := b.Repo.CreateBook(c.UserContext(), book); err != nil {
+		return err
 	}
 
+	// Evict cached listings.
+	b.Cache.EvictBooks()
+
 	// Return status 200 OK.
+	c.Set(fiber.HeaderETag, etag(book.Version))
 	return c.JSON(fiber.Map{
 		"error": false,
 		"msg":   nil,
@@ -156,23 +226,24 @@ func (b *BookSvcImpl) CreateBook(c *fiber.Ctx) error {
 	})
 }
 
-// UpdateBook func for updates book by given ID.
-// @Description Update book.
-// @Summary update book
+// UpdateBook func for replaces book by given ID.
+// @Description Replace every writable field of book.
+// @Summary replace book
 // @Tags Book
 // @Accept json
 // @Produce json
 // @Param id path string true "Book ID"
+// @Param If-Match header string true "ETag of the book being updated"
 // @Param body body models.Book true "Book payload"
 // @Success 204 {string} status "ok"
-// @Router /v1/book/{id} [patch]
+// @Failure 412 {string} status "book was modified since"
+// @Failure 428 {string} status "If-Match is missing"
+// @Security ApiKeyAuth
+// @Router /v1/book/{id} [put]
 func (b *BookSvcImpl) UpdateBook(c *fiber.Ctx) error {
-	id, err := uuid.Parse(c.Params("id"))
+	id, err := bookID(c)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": true,
-			"msg":   err.Error(),
-		})
+		return err
 	}
 
 	// Create new Book struct
@@ -180,25 +251,11 @@ func (b *BookSvcImpl) UpdateBook(c *fiber.Ctx) error {
 
 	// Check, if received JSON data is valid.
 	if err := c.BodyParser(book); err != nil {
-		// Return status 400 and error message.
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": true,
-			"msg":   err.Error(),
-		})
-	}
-
-	book.ID = id
-	// Checking, if book with given ID is exists.
-	foundedBook, err := b.Repo.GetBook(book.ID)
-	if err != nil {
-		// Return status 404 and book not found error.
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": true,
-			"msg":   "book with this ID not found",
-		})
+		return bodyErr(err)
 	}
 
 	// Set initialized default data for book:
+	book.ID = id
 	book.UpdatedAt = time.Now()
 
 	// Create a new validator for a Book model.
@@ -206,67 +263,318 @@ func (b *BookSvcImpl) UpdateBook(c *fiber.Ctx) error {
 
 	// Validate book fields.
 	if err := validate.Struct(book); err != nil {
-		// Return, if some fields are not valid.
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": true,
-			"msg":   utils.ValidatorErrors(err),
-		})
+		return utils.ValidationError(err)
 	}
 
-	// Update book by given ID.
-	if err := b.Repo.UpdateBook(foundedBook.ID, book); err != nil {
-		// Return status 500 and error message.
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": true,
-			"msg":   err.Error(),
-		})
+	// Check and update the book as one unit of work.
+	if err := b.Tx.WithinTx(c.UserContext(), func(ctx context.Context) error {
+		// Checking, if book with given ID is exists.
+		foundedBook, err := b.findBook(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		// Checking, if the client saw the current version of the book.
+		if err := checkIfMatch(c, foundedBook.Version); err != nil {
+			return err
+		}
+
+		// Update book by given ID.
+		return b.Repo.UpdateBook(ctx, foundedBook.ID, foundedBook.Version, book)
+	}); err != nil {
+		return err
 	}
 
+	// Evict cached book and listings.
+	b.Cache.EvictBook(id)
+
 	// Return status 204.
+	c.Set(fiber.HeaderETag, etag(book.Version))
 	return c.Status(fiber.StatusNoContent).JSON(fiber.Map{
 		"error": false,
 		"msg":   nil,
 	})
 }
 
+// PatchBook func for updates given fields of book by given ID.
+// @Description Update some fields of book with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902).
+// @Summary patch book
+// @Tags Book
+// @Accept application/merge-patch+json,application/json-patch+json,json
+// @Produce json
+// @Param id path string true "Book ID"
+// @Param If-Match header string true "ETag of the book being patched"
+// @Param body body object true "Merge patch object or JSON Patch operations"
+// @Success 200 {object} models.Book
+// @Failure 409 {string} status "a test operation failed"
+// @Failure 412 {string} status "book was modified since"
+// @Failure 415 {string} status "unsupported patch format"
+// @Failure 422 {string} status "patch can't be applied"
+// @Failure 428 {string} status "If-Match is missing"
+// @Security ApiKeyAuth
+// @Router /v1/book/{id} [patch]
+func (b *BookSvcImpl) PatchBook(c *fiber.Ctx) error {
+	id, err := bookID(c)
+	if err != nil {
+		return err
+	}
+
+	// Check, patch and write the book as one unit of work.
+	var book *repo.Book
+	var fields []string
+	if err := b.Tx.WithinTx(c.UserContext(), func(ctx context.Context) error {
+		// Checking, if book with given ID is exists.
+		foundedBook, err := b.findBook(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		// Checking, if the client saw the current version of the book.
+		if err := checkIfMatch(c, foundedBook.Version); err != nil {
+			return err
+		}
+
+		// Apply the patch to the current book.
+		if book, fields, err = applyPatch(c, &foundedBook); err != nil {
+			return err
+		}
+
+		// Leave the book as is, if the patch changed nothing.
+		if len(fields) == 0 {
+			return nil
+		}
+
+		// Validate changed fields only.
+		validate := utils.NewValidator()
+		if err := validate.StructPartial(book, structFields(fields)...); err != nil {
+			return utils.ValidationError(err)
+		}
+
+		// Set initialized default data for book:
+		book.UpdatedAt = time.Now()
+
+		// Write changed fields of book by given ID.
+		return b.Repo.PatchBook(ctx, foundedBook.ID, foundedBook.Version, book, fields)
+	}); err != nil {
+		return err
+	}
+
+	// Evict cached book and listings.
+	if len(fields) > 0 {
+		b.Cache.EvictBook(id)
+	}
+
+	// Return status 200 OK.
+	c.Set(fiber.HeaderETag, etag(book.Version))
+	return c.JSON(fiber.Map{
+		"error": false,
+		"msg":   nil,
+		"book":  book,
+	})
+}
+
 // DeleteBook func for deletes book by given ID.
-// @Description Delete book by given ID.
+// @Description Delete book by given ID. It can be restored until it is purged.
 // @Summary delete book by given ID
 // @Tags Book
 // @Accept json
 // @Produce json
 // @Param id path string true "Book ID"
+// @Param If-Match header string true "ETag of the book being deleted"
 // @Success 204 {string} status "ok"
+// @Failure 412 {string} status "book was modified since"
+// @Failure 428 {string} status "If-Match is missing"
+// @Security ApiKeyAuth
 // @Router /v1/book/{id} [delete]
 func (b *BookSvcImpl) DeleteBook(c *fiber.Ctx) error {
 
-	id, err := uuid.Parse(c.Params("id"))
+	id, err := bookID(c)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": true,
-			"msg":   err.Error(),
-		})
+		return err
 	}
 
-	// Checking, if book with given ID is exists.
-	foundedBook, err := b.Repo.GetBook(id)
-	if err != nil {
-		// Return status 404 and book not found error.
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": true,
-			"msg":   "book with this ID not found",
-		})
+	// Check and delete the book as one unit of work.
+	if err := b.Tx.WithinTx(c.UserContext(), func(ctx context.Context) error {
+		// Checking, if book with given ID is exists.
+		foundedBook, err := b.findBook(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		// Checking, if the client saw the current version of the book.
+		if err := checkIfMatch(c, foundedBook.Version); err != nil {
+			return err
+		}
+
+		// Delete book by given ID.
+		return b.Repo.DeleteBook(ctx, foundedBook.ID, foundedBook.Version)
+	}); err != nil {
+		return err
 	}
 
-	// Delete book by given ID.
-	if err := b.Repo.DeleteBook(foundedBook.ID); err != nil {
-		// Return status 500 and error message.
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": true,
-			"msg":   err.Error(),
-		})
-	}
+	// Evict cached book and listings.
+	b.Cache.EvictBook(id)
 
 	// Return status 204 no content.
 	return c.SendStatus(fiber.StatusNoContent)
 }
+
+// RestoreBook func for brings back deleted book by given ID.
+// @Description Restore deleted book by given ID, until it is purged.
+// @Summary restore deleted book by given ID
+// @Tags Book
+// @Accept json
+// @Produce json
+// @Param id path string true "Book ID"
+// @Success 200 {object} models.Book
+// @Failure 404 {string} status "no deleted book with the given ID"
+// @Security ApiKeyAuth
+// @Router /v1/book/{id}/restore [post]
+func (b *BookSvcImpl) RestoreBook(c *fiber.Ctx) error {
+	id, err := bookID(c)
+	if err != nil {
+		return err
+	}
+
+	// Restore book by given ID.
+	book, err := b.Repo.RestoreBook(c.UserContext(), id, time.Now())
+	if errors.Is(err, errs.ErrNotFound) {
+		return errs.Wrap(errs.ErrNotFound, err, "deleted book with the given ID is not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	// Evict cached book and listings.
+	b.Cache.EvictBook(book.ID)
+
+	// Return status 200 OK.
+	c.Set(fiber.HeaderETag, etag(book.Version))
+	return c.JSON(fiber.Map{
+		"error": false,
+		"msg":   nil,
+		"book":  book,
+	})
+}
+
+// GetHistory func gets every revision of book by given ID.
+// @Description Get the change history of book by given ID, latest first, deleted books included.
+// @Summary get history of book by given ID
+// @Tags Book
+// @Accept json
+// @Produce json
+// @Param id path string true "Book ID"
+// @Success 200 {array} repo.Revision
+// @Failure 404 {string} status "book has no history"
+// @Router /v1/book/{id}/history [get]
+func (b *BookSvcImpl) GetHistory(c *fiber.Ctx) error {
+	id, err := bookID(c)
+	if err != nil {
+		return err
+	}
+
+	// Get revisions of book by ID.
+	revisions, err := b.Repo.GetRevisions(c.UserContext(), id)
+	if errors.Is(err, errs.ErrNotFound) {
+		return errs.Wrap(errs.ErrNotFound, err, "book with the given ID is not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	// Return status 200 OK.
+	return c.JSON(fiber.Map{
+		"error":     false,
+		"msg":       nil,
+		"count":     len(revisions),
+		"revisions": revisions,
+	})
+}
+
+// RevertBook func for sets book by given ID back to a revision.
+// @Description Set the fields of book back to what they were at given revision.
+// @Summary revert book to a revision
+// @Tags Book
+// @Accept json
+// @Produce json
+// @Param id path string true "Book ID"
+// @Param version path int true "Revision version"
+// @Param If-Match header string true "ETag of the book being reverted"
+// @Success 200 {object} models.Book
+// @Failure 404 {string} status "no such book or revision"
+// @Failure 412 {string} status "book was modified since"
+// @Failure 428 {string} status "If-Match is missing"
+// @Security ApiKeyAuth
+// @Router /v1/book/{id}/history/{version}/revert [post]
+func (b *BookSvcImpl) RevertBook(c *fiber.Ctx) error {
+	id, err := bookID(c)
+	if err != nil {
+		return err
+	}
+	revision, err := c.ParamsInt("version")
+	if err != nil || revision < 1 {
+		return errs.New(errs.ErrValidation, "revision version must be a positive integer")
+	}
+
+	// Check and revert the book as one unit of work.
+	var book repo.Book
+	if err := b.Tx.WithinTx(c.UserContext(), func(ctx context.Context) error {
+		// Checking, if book with given ID is exists.
+		foundedBook, err := b.findBook(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		// Checking, if the client saw the current version of the book.
+		if err := checkIfMatch(c, foundedBook.Version); err != nil {
+			return err
+		}
+
+		// Revert book by given ID.
+		book, err = b.Repo.RevertBook(ctx, foundedBook.ID, foundedBook.Version, revision, time.Now())
+		if errors.Is(err, errs.ErrNotFound) {
+			return errs.Wrap(errs.ErrNotFound, err, "book has no such revision")
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// Evict cached book and listings.
+	b.Cache.EvictBook(book.ID)
+
+	// Return status 200 OK.
+	c.Set(fiber.HeaderETag, etag(book.Version))
+	return c.JSON(fiber.Map{
+		"error": false,
+		"msg":   nil,
+		"book":  book,
+	})
+}
+
+// findBook gets book by given ID, reporting a missing one as not found.
+func (b *BookSvcImpl) findBook(ctx context.Context, id uuid.UUID) (repo.Book, error) {
+	book, err := b.Repo.GetBook(ctx, id)
+	if errors.Is(err, errs.ErrNotFound) {
+		return book, errs.Wrap(errs.ErrNotFound, err, "book with the given ID is not found")
+	}
+	return book, err
+}
+
+// bookID parses the book ID in the URL of given request.
+func bookID(c *fiber.Ctx) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return id, errs.Wrap(errs.ErrValidation, err, "book ID must be a UUID")
+	}
+	return id, nil
+}
+
+// bodyErr reports a request body that can't be parsed.
+func bodyErr(err error) error {
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fe
+	}
+	return errs.Wrap(errs.ErrValidation, err, err.Error())
+}
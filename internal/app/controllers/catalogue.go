@@ -0,0 +1,553 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/caohoangphuctd97/go-test/internal/app/jobs"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/reqctx"
+	"github.com/caohoangphuctd97/go-test/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Catalogue formats.
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+
+	// mimeJSONL is the media type of JSON Lines.
+	mimeJSONL = "application/jsonl"
+	// maxLineSize caps a line of an imported JSON Lines catalogue.
+	maxLineSize = 1 << 20
+)
+
+// csvColumns lists the columns of an exported CSV catalogue.
+var csvColumns = []string{"id", "title", "author", "created_at", "updated_at", "version"}
+
+// Types of the jobs importing and exporting a catalogue.
+const (
+	jobImportBooks = "books.import"
+	jobExportBooks = "books.export"
+)
+
+type (
+	// importLine is a book read from a line of an imported catalogue.
+	importLine struct {
+		Line int
+		Book *repo.Book
+		Err  error
+	}
+	// importRequest is a catalogue to import, and the payload of an import
+	// job. The body of a job is its input, not to bloat its payload.
+	importRequest struct {
+		Format string `json:"format"`
+		DryRun bool   `json:"dry_run"`
+		Body   []byte `json:"-"`
+	}
+	// importReport tells how an import went, and is the result of an import
+	// job.
+	importReport struct {
+		DryRun   bool        `json:"dry_run"`
+		Lines    int         `json:"lines"`
+		Valid    int         `json:"valid"`
+		Invalid  int         `json:"invalid"`
+		Imported int         `json:"imported"`
+		Errors   []fiber.Map `json:"errors"`
+	}
+	// exportRequest is the payload of an export job.
+	exportRequest struct {
+		Format string `json:"format"`
+	}
+	// exportReport tells how an export job went, and where to download
+	// the catalogue.
+	exportReport struct {
+		Format   string `json:"format"`
+		Books    int    `json:"books"`
+		Download string `json:"download"`
+	}
+	// bookWriter writes a book of a catalogue.
+	bookWriter func(w *bufio.Writer, book *repo.Book) error
+)
+
+// ExportBooks func streams every book as CSV or JSON Lines.
+// @Description Stream the whole catalogue, oldest book first, without deleted books.
+// @Summary export books
+// @Tags Books
+// @Produce text/csv,application/jsonl
+// @Param format query string false "csv (default) or jsonl"
+// @Success 200 {string} string "the catalogue"
+// @Router /v1/books/export [get]
+func (b *BookSvcImpl) ExportBooks(c *fiber.Ctx) error {
+	format := strings.ToLower(c.Query("format", formatCSV))
+	contentType, write, err := exportFormat(format)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="books.%s"`, format))
+
+	// Stream rows as they are read, once the headers are sent, which is
+	// after the handler returns and its context is canceled: the export
+	// timeout bounds it instead, so that a client that stops reading does
+	// not hold a connection for good.
+	detached := reqctx.Detach(c.UserContext())
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := exportTimeout(detached, b.Cfg)
+		defer cancel()
+		if format == formatCSV {
+			cw := csv.NewWriter(w)
+			cw.Write(csvColumns)
+			cw.Flush()
+		}
+		err := b.Repo.ExportBooks(ctx, func(book *repo.Book) error {
+			if err := write(w, book); err != nil {
+				return err
+			}
+			return w.Flush()
+		})
+		if err != nil {
+			// The status is sent already, the client sees a truncated body.
+			log.Error().Err(err).Msg("export: books")
+		}
+	})
+	return nil
+}
+
+// QueueExport func queues an export of every book as CSV or JSON Lines.
+// @Description Export the whole catalogue in the background, oldest book first, without deleted books.
+// @Description The status of the export job is at the Location of the response, and the catalogue is
+// @Description downloaded from its download link once the job succeeded.
+// @Summary export books in the background
+// @Tags Books
+// @Produce json
+// @Param format query string false "csv (default) or jsonl"
+// @Success 202 {string} status "the export job was queued"
+// @Failure 401 {string} status "the caller is anonymous"
+// @Security ApiKeyAuth
+// @Router /v1/books/export [post]
+func (b *BookSvcImpl) QueueExport(c *fiber.Ctx) error {
+	format := strings.ToLower(c.Query("format", formatCSV))
+	if _, _, err := exportFormat(format); err != nil {
+		return err
+	}
+	// Jobs are only shown to who queued them.
+	if reqctx.Actor(c.UserContext()) == reqctx.Anonymous {
+		return errs.New(errs.ErrUnauthorized, "sign in to export in the background")
+	}
+	job, err := b.Jobs.Enqueue(c.UserContext(), jobExportBooks, exportRequest{Format: format})
+	if err != nil {
+		return err
+	}
+
+	// Return status 202 Accepted.
+	c.Location("/api/v1/jobs/" + job.ID.String())
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"error": false,
+		"msg":   nil,
+		"job":   job.View(),
+	})
+}
+
+// exportJob runs an export queued by QueueExport, keeping the catalogue as
+// the output of the job.
+func (b *BookSvcImpl) exportJob(ctx context.Context, job *jobs.Job) (interface{}, error) {
+	req := exportRequest{}
+	if err := json.Unmarshal(job.Payload, &req); err != nil {
+		return nil, err
+	}
+	contentType, write, err := exportFormat(req.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := exportTimeout(ctx, b.Cfg)
+	defer cancel()
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+	if req.Format == formatCSV {
+		cw := csv.NewWriter(w)
+		cw.Write(csvColumns)
+		cw.Flush()
+	}
+	report := exportReport{Format: req.Format, Download: "/api/v1/jobs/" + job.ID.String() + "/download"}
+	if err := b.Repo.ExportBooks(ctx, func(book *repo.Book) error {
+		report.Books++
+		return write(w, book)
+	}); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	file := &jobs.File{Name: "books." + req.Format, ContentType: contentType, Data: buf.Bytes()}
+	if err := b.Jobs.SetOutput(ctx, job.ID, file); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// exportFormat returns the content type of an exported catalogue of given
+// format, and the writer of its books.
+func exportFormat(format string) (string, bookWriter, error) {
+	switch format {
+	case formatCSV:
+		return "text/csv; charset=utf-8", csvBook, nil
+	case formatJSONL:
+		return mimeJSONL, jsonlBook, nil
+	}
+	return "", nil, errs.New(errs.ErrValidation, "format must be csv or jsonl")
+}
+
+// ImportBooks func creates books from a CSV or JSON Lines catalogue.
+// @Description Create one book per line of a CSV (with a title and an author column) or JSON Lines catalogue,
+// @Description sent as the body or as the "file" field of a multipart form. Every line is validated first:
+// @Description any invalid line fails the whole import. With dry_run nothing is written. With async the
+// @Description import runs as a background job, whose status is at the Location of the response.
+// @Summary import books
+// @Tags Books
+// @Accept text/csv,application/jsonl,multipart/form-data
+// @Produce json
+// @Param format query string false "csv or jsonl, guessed from the content type or file name when missing"
+// @Param dry_run query bool false "Validate only"
+// @Param async query bool false "Import in the background"
+// @Success 200 {string} status "ok"
+// @Success 202 {string} status "the import job was queued"
+// @Failure 422 {string} status "some lines are invalid"
+// @Security ApiKeyAuth
+// @Router /v1/books/import [post]
+func (b *BookSvcImpl) ImportBooks(c *fiber.Ctx) error {
+	body, name, err := importBody(c)
+	if err != nil {
+		return err
+	}
+	format, err := importFormat(c, name)
+	if err != nil {
+		return err
+	}
+	req := importRequest{Format: format, DryRun: c.QueryBool("dry_run"), Body: body}
+
+	if c.QueryBool("async") {
+		job, err := b.Jobs.Enqueue(c.UserContext(), jobImportBooks, req, jobs.WithInput(body))
+		if err != nil {
+			return err
+		}
+
+		// Return status 202 Accepted.
+		c.Location("/api/v1/jobs/" + job.ID.String())
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"error": false,
+			"msg":   nil,
+			"job":   job.View(),
+		})
+	}
+
+	report, err := b.importCatalogue(c.UserContext(), req)
+	if err != nil {
+		return err
+	}
+
+	// Return status 200 OK, or 422 when some lines are invalid.
+	status := fiber.StatusOK
+	if report.Invalid > 0 && !report.DryRun {
+		status = fiber.StatusUnprocessableEntity
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error":    report.Invalid > 0,
+		"msg":      nil,
+		"dry_run":  report.DryRun,
+		"lines":    report.Lines,
+		"valid":    report.Valid,
+		"invalid":  report.Invalid,
+		"imported": report.Imported,
+		"errors":   report.Errors,
+	})
+}
+
+// importJob runs an import queued by ImportBooks.
+func (b *BookSvcImpl) importJob(ctx context.Context, job *jobs.Job) (interface{}, error) {
+	req := importRequest{}
+	if err := json.Unmarshal(job.Payload, &req); err != nil {
+		return nil, err
+	}
+	body, err := b.Jobs.Input(ctx, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = body
+	return b.importCatalogue(ctx, req)
+}
+
+// importCatalogue reads and validates every line of a catalogue, then
+// creates its books unless some lines are invalid or it's a dry run.
+func (b *BookSvcImpl) importCatalogue(ctx context.Context, req importRequest) (importReport, error) {
+	report := importReport{DryRun: req.DryRun, Errors: []fiber.Map{}}
+
+	// Read and validate every line.
+	var lines []importLine
+	var err error
+	switch req.Format {
+	case formatCSV:
+		lines, err = readCSV(req.Body)
+	default:
+		lines, err = readJSONL(req.Body)
+	}
+	if err != nil {
+		return report, err
+	}
+
+	books := make([]*repo.Book, 0, len(lines))
+	for _, l := range lines {
+		if l.Err != nil {
+			report.Errors = append(report.Errors, lineErr(l.Line, l.Err))
+			continue
+		}
+		books = append(books, l.Book)
+	}
+	report.Lines = len(lines)
+	report.Valid = len(books)
+	report.Invalid = len(report.Errors)
+
+	// Create valid books, unless some lines are invalid or it's a dry run.
+	if report.Invalid > 0 || req.DryRun || len(books) == 0 {
+		return report, nil
+	}
+	// Bound the transaction as a whole by the import timeout: each bulk
+	// insert is bounded on its own too, by less.
+	importCtx, cancel := importTimeout(ctx, b.Cfg)
+	defer cancel()
+	if err := b.Tx.WithinTx(importCtx, func(ctx context.Context) error {
+		for i := 0; i < len(books); i += repo.MaxBatchSize {
+			end := i + repo.MaxBatchSize
+			if end > len(books) {
+				end = len(books)
+			}
+			if _, err := b.Repo.BulkInsert(ctx, books[i:end]...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return report, err
+	}
+	report.Imported = len(books)
+
+	// Evict cached listings.
+	b.Cache.EvictBooks()
+	return report, nil
+}
+
+// importTimeout bounds the transaction of an import by the import timeout
+// of cfg.
+func importTimeout(ctx context.Context, cfg *databases.DatabaseCfg) (context.Context, context.CancelFunc) {
+	if cfg == nil || cfg.ImportTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, cfg.ImportTimeout)
+}
+
+// exportTimeout bounds an export by the export timeout of cfg.
+func exportTimeout(ctx context.Context, cfg *databases.DatabaseCfg) (context.Context, context.CancelFunc) {
+	if cfg == nil || cfg.ExportTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, cfg.ExportTimeout)
+}
+
+// importBody returns the catalogue sent with given request, and its file
+// name if it was uploaded as a multipart form.
+func importBody(c *fiber.Ctx) ([]byte, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	if mediaType != fiber.MIMEMultipartForm {
+		return c.Body(), "", nil
+	}
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return nil, "", errs.Wrap(errs.ErrValidation, err, "the catalogue must be sent in the file field")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	body, err := io.ReadAll(f)
+	return body, fh.Filename, err
+}
+
+// importFormat tells the format of the catalogue sent with given request,
+// from the format parameter, the content type or the file name.
+func importFormat(c *fiber.Ctx, name string) (string, error) {
+	format := strings.ToLower(c.Query("format"))
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+		switch {
+		case mediaType == "text/csv", strings.EqualFold(filepath.Ext(name), ".csv"):
+			format = formatCSV
+		case mediaType == mimeJSONL, mediaType == "application/x-ndjson",
+			strings.EqualFold(filepath.Ext(name), ".jsonl"), strings.EqualFold(filepath.Ext(name), ".ndjson"):
+			format = formatJSONL
+		}
+	}
+	switch format {
+	case formatCSV, formatJSONL:
+		return format, nil
+	case "":
+		return "", fiber.ErrUnsupportedMediaType
+	}
+	return "", errs.New(errs.ErrValidation, "format must be csv or jsonl")
+}
+
+// readCSV reads the books of a CSV catalogue. Its header names the columns;
+// title and author are required, others are ignored.
+func readCSV(body []byte) ([]importLine, error) {
+	r := csv.NewReader(bytes.NewReader(body))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, errs.New(errs.ErrValidation, "the catalogue must start with a header")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range []string{"title", "author"} {
+		if _, ok := columns[name]; !ok {
+			return nil, errs.New(errs.ErrValidation, "the catalogue header must have a "+name+" column")
+		}
+	}
+	cell := func(record []string, name string) string {
+		if i := columns[name]; i < len(record) {
+			return unescapeCell(record[i])
+		}
+		return ""
+	}
+
+	lines := []importLine{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			line := 0
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				line = pe.Line
+			}
+			lines = append(lines, importLine{Line: line, Err: errs.Wrap(errs.ErrValidation, err, "malformed CSV line: "+err.Error())})
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		book := &repo.Book{Title: cell(record, "title"), Author: cell(record, "author")}
+		lines = append(lines, importLine{Line: line, Book: book, Err: importBook(book)})
+	}
+}
+
+// readJSONL reads the books of a JSON Lines catalogue, one book object per
+// line. Blank lines are skipped.
+func readJSONL(body []byte) ([]importLine, error) {
+	s := bufio.NewScanner(bytes.NewReader(body))
+	s.Buffer(nil, maxLineSize)
+
+	lines := []importLine{}
+	for n := 1; s.Scan(); n++ {
+		text := bytes.TrimSpace(s.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		book := &repo.Book{}
+		if err := json.Unmarshal(text, book); err != nil {
+			lines = append(lines, importLine{Line: n, Err: errs.Wrap(errs.ErrValidation, err, "malformed JSON line: "+err.Error())})
+			continue
+		}
+		lines = append(lines, importLine{Line: n, Book: book, Err: importBook(book)})
+	}
+	if err := s.Err(); err != nil {
+		return nil, errs.Wrap(errs.ErrValidation, err, err.Error())
+	}
+	return lines, nil
+}
+
+// importBook sets the defaults of a book read from a catalogue and
+// validates it.
+func importBook(book *repo.Book) error {
+	// Set initialized default data for book:
+	now := time.Now()
+	book.ID = uuid.New()
+	book.CreatedAt = now
+	book.UpdatedAt = now
+	book.Version = 1
+	book.DeletedAt = nil
+
+	// Validate book fields.
+	if err := utils.NewValidator().Struct(book); err != nil {
+		return utils.ValidationError(err)
+	}
+	return nil
+}
+
+// lineErr reports why given line of a catalogue was rejected.
+func lineErr(line int, err error) fiber.Map {
+	e := fiber.Map{"line": line, "detail": err.Error()}
+	var de *errs.Error
+	if errors.As(err, &de) {
+		e["detail"] = de.Public()
+		if len(de.Fields) > 0 {
+			e["errors"] = de.Fields
+		}
+	}
+	return e
+}
+
+// csvBook writes given book as a CSV record.
+func csvBook(w *bufio.Writer, book *repo.Book) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		book.ID.String(),
+		escapeCell(book.Title),
+		escapeCell(book.Author),
+		book.CreatedAt.Format(time.RFC3339),
+		book.UpdatedAt.Format(time.RFC3339),
+		strconv.Itoa(book.Version),
+	})
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonlBook writes given book as a JSON line.
+func jsonlBook(w *bufio.Writer, book *repo.Book) error {
+	return json.NewEncoder(w).Encode(book)
+}
+
+// escapeCell keeps spreadsheets from running a cell as a formula by
+// prefixing it with a quote.
+func escapeCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// unescapeCell reverts escapeCell.
+func unescapeCell(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
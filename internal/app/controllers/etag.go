@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/gofiber/fiber/v2"
+)
+
+// etag returns the entity tag of given book version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// checkIfMatch checks the If-Match header of given request against the
+// current version of a book. It fails with ErrPreconditionRequired when the
+// header is missing and ErrPrecondition when none of its tags is the
+// current one.
+func checkIfMatch(c *fiber.Ctx, current int) error {
+	header := c.Get(fiber.HeaderIfMatch)
+	if strings.TrimSpace(header) == "" {
+		return errs.New(errs.ErrPreconditionRequired, "If-Match must hold the current ETag of the book")
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// If-Match uses the strong comparison, weak tags never match.
+		if tag == "*" || tag == etag(current) {
+			return nil
+		}
+	}
+	return errs.New(errs.ErrPrecondition, "book was modified since the given ETag")
+}
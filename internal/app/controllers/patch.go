@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"sort"
+
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/jsonpatch"
+	"github.com/gofiber/fiber/v2"
+)
+
+// applyPatch applies the patch in the request body to given book, as a JSON
+// Patch or a JSON Merge Patch depending on Content-Type. Plain JSON is taken
+// as a merge patch. It returns the patched book and the JSON names of the
+// fields the patch changed.
+func applyPatch(c *fiber.Ctx, book *repo.Book) (*repo.Book, []string, error) {
+	doc, err := json.Marshal(book)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var patched []byte
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	switch mediaType {
+	case jsonpatch.JSONPatchType:
+		patched, err = jsonpatch.Apply(doc, c.Body())
+	case jsonpatch.MergePatchType, fiber.MIMEApplicationJSON:
+		patched, err = jsonpatch.MergePatch(doc, c.Body())
+	default:
+		return nil, nil, fiber.ErrUnsupportedMediaType
+	}
+	if err != nil {
+		return nil, nil, patchErr(err)
+	}
+
+	before, after := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	if err := json.Unmarshal(doc, &before); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(patched, &after); err != nil {
+		return nil, nil, errs.New(errs.ErrUnprocessable, "a book must be a JSON object")
+	}
+
+	// Collect every field added, removed or changed by the patch.
+	changed := map[string]bool{}
+	for k, v := range after {
+		if old, ok := before[k]; !ok || !bytes.Equal(old, v) {
+			changed[k] = true
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			changed[k] = true
+		}
+	}
+	fields := make([]string, 0, len(changed))
+	for k := range changed {
+		if _, ok := repo.PatchableBookFields[k]; !ok {
+			return nil, nil, errs.New(errs.ErrUnprocessable, fmt.Sprintf("field %s is read-only", k))
+		}
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+
+	result := &repo.Book{}
+	if err := json.Unmarshal(patched, result); err != nil {
+		return nil, nil, errs.Wrap(errs.ErrUnprocessable, err, "patched book is not valid")
+	}
+	return result, fields, nil
+}
+
+// patchErr maps an error of the jsonpatch package to a domain error.
+func patchErr(err error) error {
+	switch {
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		return errs.Wrap(errs.ErrConflict, err, err.Error())
+	case errors.Is(err, jsonpatch.ErrPath):
+		return errs.Wrap(errs.ErrUnprocessable, err, err.Error())
+	}
+	return errs.Wrap(errs.ErrValidation, err, err.Error())
+}
+
+// structFields maps JSON names of book fields to their struct field names.
+func structFields(fields []string) []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = repo.PatchableBookFields[f]
+	}
+	return names
+}
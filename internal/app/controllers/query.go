@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/internal/app/auth"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/gofiber/fiber/v2"
+)
+
+// listQuery is the query string accepted by listing endpoints.
+type listQuery struct {
+	Limit         uint64 `query:"limit"`
+	Offset        uint64 `query:"offset"`
+	Cursor        string `query:"cursor"`
+	Sort          string `query:"sort"`
+	Order         string `query:"order"`
+	Author        string `query:"author"`
+	TitleContains string `query:"title_contains"`
+	CreatedAfter  string `query:"created_after"`
+	CreatedBefore string `query:"created_before"`
+	// IncludeDeleted lists deleted books too, for admins only.
+	IncludeDeleted bool `query:"include_deleted"`
+}
+
+// searchQuery is the query string accepted by the search endpoint.
+type searchQuery struct {
+	Q      string `query:"q"`
+	Limit  uint64 `query:"limit"`
+	Offset uint64 `query:"offset"`
+	Cursor string `query:"cursor"`
+}
+
+// bookFilter parses the listing query string of given request.
+func bookFilter(c *fiber.Ctx) (repo.BookFilter, error) {
+	q := listQuery{}
+	if err := c.QueryParser(&q); err != nil {
+		return repo.BookFilter{}, errs.Wrap(errs.ErrValidation, err, err.Error())
+	}
+
+	f := repo.BookFilter{
+		Limit:         q.Limit,
+		Offset:        q.Offset,
+		Cursor:        q.Cursor,
+		Sort:          q.Sort,
+		Author:        q.Author,
+		TitleContains: q.TitleContains,
+
+		IncludeDeleted: q.IncludeDeleted,
+	}
+	if f.IncludeDeleted && !auth.ClaimsOf(c).Grants(auth.ScopeAdmin) {
+		return f, errs.New(errs.ErrForbidden, fmt.Sprintf("permission %s is required to list deleted books", auth.ScopeAdmin))
+	}
+	if f.Limit > repo.MaxPageLimit {
+		return f, errs.New(errs.ErrValidation, fmt.Sprintf("limit must not exceed %d", repo.MaxPageLimit))
+	}
+	if f.Sort != "" && !repo.BookSortColumns[f.Sort] {
+		return f, errs.New(errs.ErrValidation, fmt.Sprintf("cannot sort on %q", f.Sort))
+	}
+	switch strings.ToLower(q.Order) {
+	case "", "asc":
+	case "desc":
+		f.Desc = true
+	default:
+		return f, errs.New(errs.ErrValidation, "order must be asc or desc")
+	}
+
+	var err error
+	if f.CreatedAfter, err = parseTime("created_after", q.CreatedAfter); err != nil {
+		return f, err
+	}
+	if f.CreatedBefore, err = parseTime("created_before", q.CreatedBefore); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// parseTime parses an optional RFC 3339 query parameter.
+func parseTime(name, v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, errs.New(errs.ErrValidation, name+" must be an RFC 3339 timestamp")
+	}
+	return &t, nil
+}
+
+// nullable maps an empty string to JSON null.
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"github.com/caohoangphuctd97/go-test/internal/app/auth"
+	"github.com/caohoangphuctd97/go-test/internal/app/users"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/dig"
+)
+
+type (
+	UserSvc interface {
+		Signup(c *fiber.Ctx) error
+		Login(c *fiber.Ctx) error
+		Refresh(c *fiber.Ctx) error
+		Logout(c *fiber.Ctx) error
+		ForgotPassword(c *fiber.Ctx) error
+		ResetPassword(c *fiber.Ctx) error
+	}
+	// UserSvcImpl is implementation of UserSvc
+	UserSvcImpl struct {
+		dig.In
+		Users users.Service
+	}
+	// credentialsRequest is the body signing up or in.
+	credentialsRequest struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	// refreshRequest is the body exchanging or revoking a refresh token.
+	refreshRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// forgotPasswordRequest is the body asking for a password reset.
+	forgotPasswordRequest struct {
+		Email string `json:"email"`
+	}
+	// resetPasswordRequest is the body resetting a password.
+	resetPasswordRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+)
+
+func NewUserSvc(impl UserSvcImpl) UserSvc {
+	return &impl
+}
+
+// Signup func for creates a new account.
+// @Description Create an account signing in with given email and password.
+// @Summary sign up
+// @Tags Accounts
+// @Accept json
+// @Produce json
+// @Param body body object true "Email and password"
+// @Success 201 {object} repo.User
+// @Failure 409 {string} status "an account with this email already exists"
+// @Router /v1/auth/signup [post]
+func (u *UserSvcImpl) Signup(c *fiber.Ctx) error {
+	req := credentialsRequest{}
+
+	// Check, if received JSON data is valid.
+	if err := c.BodyParser(&req); err != nil {
+		return bodyErr(err)
+	}
+
+	// Create account.
+	user, err := u.Users.Signup(c.UserContext(), req.Email, req.Password)
+	if err != nil {
+		return err
+	}
+
+	// Return status 201 Created.
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"error": false,
+		"msg":   nil,
+		"user":  user,
+	})
+}
+
+// Login func for signs in with email and password.
+// @Description Sign in, getting an access token and a refresh token.
+// @Summary sign in
+// @Tags Accounts
+// @Accept json
+// @Produce json
+// @Param body body object true "Email and password"
+// @Success 200 {object} users.Tokens
+// @Failure 401 {string} status "invalid email or password"
+// @Router /v1/auth/login [post]
+func (u *UserSvcImpl) Login(c *fiber.Ctx) error {
+	req := credentialsRequest{}
+
+	// Check, if received JSON data is valid.
+	if err := c.BodyParser(&req); err != nil {
+		return bodyErr(err)
+	}
+
+	// Open session.
+	tokens, err := u.Users.Login(c.UserContext(), req.Email, req.Password)
+	if err != nil {
+		return err
+	}
+
+	// Return status 200 OK.
+	return c.JSON(fiber.Map{
+		"error":  false,
+		"msg":    nil,
+		"tokens": tokens,
+	})
+}
+
+// Refresh func for exchanges a refresh token for new tokens.
+// @Description Exchange a refresh token for a new access token and a new refresh token. Each
+// @Description refresh token is exchanged once: sending one again revokes its session.
+// @Summary refresh tokens
+// @Tags Accounts
+// @Accept json
+// @Produce json
+// @Param body body object true "Refresh token"
+// @Success 200 {object} users.Tokens
+// @Failure 401 {string} status "invalid refresh token"
+// @Router /v1/auth/refresh [post]
+func (u *UserSvcImpl) Refresh(c *fiber.Ctx) error {
+	req := refreshRequest{}
+
+	// Check, if received JSON data is valid.
+	if err := c.BodyParser(&req); err != nil {
+		return bodyErr(err)
+	}
+
+	// Rotate refresh token.
+	tokens, err := u.Users.Refresh(c.UserContext(), req.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	// Return status 200 OK.
+	return c.JSON(fiber.Map{
+		"error":  false,
+		"msg":    nil,
+		"tokens": tokens,
+	})
+}
+
+// Logout func for signs out.
+// @Description Revoke the session of given refresh token, and the access token sent if any.
+// @Summary sign out
+// @Tags Accounts
+// @Accept json
+// @Param body body object false "Refresh token"
+// @Success 204 {string} status "ok"
+// @Security ApiKeyAuth
+// @Router /v1/auth/logout [post]
+func (u *UserSvcImpl) Logout(c *fiber.Ctx) error {
+	req := refreshRequest{}
+
+	// Check, if received JSON data is valid.
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return bodyErr(err)
+		}
+	}
+
+	// Revoke session and access token.
+	if err := u.Users.Logout(c.UserContext(), req.RefreshToken, auth.ClaimsOf(c)); err != nil {
+		return err
+	}
+
+	// Return status 204 no content.
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ForgotPassword func for mails a password reset token.
+// @Description Mail a password reset token to the account of given email. The response is the
+// @Description same whether such an account exists or not.
+// @Summary ask for a password reset
+// @Tags Accounts
+// @Accept json
+// @Param body body object true "Email"
+// @Success 202 {string} status "ok"
+// @Router /v1/auth/password/forgot [post]
+func (u *UserSvcImpl) ForgotPassword(c *fiber.Ctx) error {
+	req := forgotPasswordRequest{}
+
+	// Check, if received JSON data is valid.
+	if err := c.BodyParser(&req); err != nil {
+		return bodyErr(err)
+	}
+
+	// Mail reset token.
+	if err := u.Users.RequestPasswordReset(c.UserContext(), req.Email); err != nil {
+		return err
+	}
+
+	// Return status 202 Accepted.
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"error": false,
+		"msg":   nil,
+	})
+}
+
+// ResetPassword func for sets a new password with a reset token.
+// @Description Set a new password with a password reset token, signing out every session.
+// @Summary reset password
+// @Tags Accounts
+// @Accept json
+// @Param body body object true "Token and password"
+// @Success 204 {string} status "ok"
+// @Router /v1/auth/password/reset [post]
+func (u *UserSvcImpl) ResetPassword(c *fiber.Ctx) error {
+	req := resetPasswordRequest{}
+
+	// Check, if received JSON data is valid.
+	if err := c.BodyParser(&req); err != nil {
+		return bodyErr(err)
+	}
+
+	// Set password.
+	if err := u.Users.ResetPassword(c.UserContext(), req.Token, req.Password); err != nil {
+		return err
+	}
+
+	// Return status 204 no content.
+	return c.SendStatus(fiber.StatusNoContent)
+}
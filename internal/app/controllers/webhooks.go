@@ -0,0 +1,384 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/internal/app/webhooks"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/dig"
+)
+
+// webhookEvents lists the event types a webhook can subscribe to.
+var webhookEvents = map[string]bool{
+	repo.EventBookCreated: true,
+	repo.EventBookUpdated: true,
+	repo.EventBookDeleted: true,
+}
+
+type (
+	WebhookSvc interface {
+		GetWebhooks(c *fiber.Ctx) error
+		GetWebhook(c *fiber.Ctx) error
+		CreateWebhook(c *fiber.Ctx) error
+		UpdateWebhook(c *fiber.Ctx) error
+		DeleteWebhook(c *fiber.Ctx) error
+		GetDeliveries(c *fiber.Ctx) error
+		Redeliver(c *fiber.Ctx) error
+	}
+	// WebhookSvcImpl is implementation of WebhookSvc
+	WebhookSvcImpl struct {
+		dig.In
+		Repo     repo.WebhookRepo
+		Notifier webhooks.Notifier
+	}
+	// webhookRequest is the body creating or replacing a webhook.
+	webhookRequest struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		// Secret signs the deliveries. It is generated when creating a
+		// webhook without one, and kept when replacing a webhook without
+		// one.
+		Secret string `json:"secret"`
+		Active *bool  `json:"active"`
+	}
+	// deliveriesQuery is the query string of the deliveries listing.
+	deliveriesQuery struct {
+		Limit  uint64 `query:"limit"`
+		Offset uint64 `query:"offset"`
+	}
+)
+
+func NewWebhookSvc(impl WebhookSvcImpl) WebhookSvc {
+	return &impl
+}
+
+// GetWebhooks func gets every webhook.
+// @Description Get every webhook, oldest first. Secrets are not shown.
+// @Summary get all webhooks
+// @Tags Webhooks
+// @Produce json
+// @Success 200 {array} repo.Webhook
+// @Security ApiKeyAuth
+// @Router /v1/webhooks [get]
+func (w *WebhookSvcImpl) GetWebhooks(c *fiber.Ctx) error {
+	hooks, err := w.Repo.GetWebhooks(c.UserContext())
+	if err != nil {
+		return err
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	// Return status 200 OK.
+	return c.JSON(fiber.Map{
+		"error":    false,
+		"msg":      nil,
+		"count":    len(hooks),
+		"webhooks": hooks,
+	})
+}
+
+// GetWebhook func gets webhook by given ID or 404 error.
+// @Description Get webhook by given ID. Its secret is not shown.
+// @Summary get webhook by given ID
+// @Tags Webhooks
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Success 200 {object} repo.Webhook
+// @Security ApiKeyAuth
+// @Router /v1/webhooks/{id} [get]
+func (w *WebhookSvcImpl) GetWebhook(c *fiber.Ctx) error {
+	hook, err := w.findWebhook(c)
+	if err != nil {
+		return err
+	}
+	hook.Secret = ""
+
+	// Return status 200 OK.
+	return c.JSON(fiber.Map{
+		"error":   false,
+		"msg":     nil,
+		"webhook": hook,
+	})
+}
+
+// CreateWebhook func for creates a new webhook.
+// @Description Subscribe an URL to book events. No events means every event. The secret signing
+// @Description the deliveries is generated when missing, and shown in this response only.
+// @Summary create a new webhook
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Param body body object true "URL, events, secret and active"
+// @Success 201 {object} repo.Webhook
+// @Security ApiKeyAuth
+// @Router /v1/webhooks [post]
+func (w *WebhookSvcImpl) CreateWebhook(c *fiber.Ctx) error {
+	req := webhookRequest{}
+
+	// Check, if received JSON data is valid.
+	if err := c.BodyParser(&req); err != nil {
+		return bodyErr(err)
+	}
+
+	// Set initialized default data for webhook:
+	now := time.Now()
+	hook := &repo.Webhook{ID: uuid.New(), Active: true, CreatedAt: now, UpdatedAt: now}
+	if req.Secret == "" {
+		req.Secret = newSecret()
+	}
+	if err := req.apply(hook); err != nil {
+		return err
+	}
+
+	// Create webhook by given model.
+	if err := w.Repo.CreateWebhook(c.UserContext(), hook); err != nil {
+		return err
+	}
+
+	// Return status 201 Created.
+	c.Location("/api/v1/webhooks/" + hook.ID.String())
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"error":   false,
+		"msg":     nil,
+		"webhook": hook,
+	})
+}
+
+// UpdateWebhook func for replaces webhook by given ID.
+// @Description Replace the URL, events and active flag of webhook. The secret is rotated when given.
+// @Summary replace webhook
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param body body object true "URL, events, secret and active"
+// @Success 200 {object} repo.Webhook
+// @Security ApiKeyAuth
+// @Router /v1/webhooks/{id} [put]
+func (w *WebhookSvcImpl) UpdateWebhook(c *fiber.Ctx) error {
+	hook, err := w.findWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	req := webhookRequest{}
+
+	// Check, if received JSON data is valid.
+	if err := c.BodyParser(&req); err != nil {
+		return bodyErr(err)
+	}
+	rotated := req.Secret != ""
+
+	// Set initialized default data for webhook:
+	hook.UpdatedAt = time.Now()
+	if err := req.apply(&hook); err != nil {
+		return err
+	}
+
+	// Update webhook by given ID.
+	if err := w.Repo.UpdateWebhook(c.UserContext(), &hook); err != nil {
+		return err
+	}
+	if !rotated {
+		hook.Secret = ""
+	}
+
+	// Return status 200 OK.
+	return c.JSON(fiber.Map{
+		"error":   false,
+		"msg":     nil,
+		"webhook": hook,
+	})
+}
+
+// DeleteWebhook func for deletes webhook by given ID.
+// @Description Delete webhook by given ID, with its delivery log.
+// @Summary delete webhook by given ID
+// @Tags Webhooks
+// @Param id path string true "Webhook ID"
+// @Success 204 {string} status "ok"
+// @Security ApiKeyAuth
+// @Router /v1/webhooks/{id} [delete]
+func (w *WebhookSvcImpl) DeleteWebhook(c *fiber.Ctx) error {
+	id, err := webhookID(c)
+	if err != nil {
+		return err
+	}
+
+	// Delete webhook by given ID.
+	err = w.Repo.DeleteWebhook(c.UserContext(), id)
+	if errors.Is(err, errs.ErrNotFound) {
+		return errs.Wrap(errs.ErrNotFound, err, "webhook with the given ID is not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	// Return status 204 no content.
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetDeliveries func gets one page of the delivery log of webhook by given ID.
+// @Description Get one page of the deliveries made to webhook, latest first.
+// @Summary get deliveries of webhook
+// @Tags Webhooks
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of deliveries to skip"
+// @Success 200 {array} repo.Delivery
+// @Security ApiKeyAuth
+// @Router /v1/webhooks/{id}/deliveries [get]
+func (w *WebhookSvcImpl) GetDeliveries(c *fiber.Ctx) error {
+	hook, err := w.findWebhook(c)
+	if err != nil {
+		return err
+	}
+	q := deliveriesQuery{}
+	if err := c.QueryParser(&q); err != nil {
+		return errs.Wrap(errs.ErrValidation, err, err.Error())
+	}
+	switch {
+	case q.Limit == 0:
+		q.Limit = repo.DefaultPageLimit
+	case q.Limit > repo.MaxPageLimit:
+		return errs.New(errs.ErrValidation, fmt.Sprintf("limit must not exceed %d", repo.MaxPageLimit))
+	}
+
+	// Get deliveries of webhook.
+	deliveries, err := w.Repo.GetDeliveries(c.UserContext(), hook.ID, q.Limit, q.Offset)
+	if err != nil {
+		return err
+	}
+
+	// Return status 200 OK.
+	return c.JSON(fiber.Map{
+		"error":      false,
+		"msg":        nil,
+		"count":      len(deliveries),
+		"deliveries": deliveries,
+	})
+}
+
+// Redeliver func for sends again the event of a delivery.
+// @Description Queue a new delivery of the event of given delivery, to the same webhook.
+// @Summary redeliver an event
+// @Tags Webhooks
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param delivery path string true "Delivery ID"
+// @Success 202 {object} repo.Delivery
+// @Security ApiKeyAuth
+// @Router /v1/webhooks/{id}/deliveries/{delivery}/redeliver [post]
+func (w *WebhookSvcImpl) Redeliver(c *fiber.Ctx) error {
+	id, err := webhookID(c)
+	if err != nil {
+		return err
+	}
+	deliveryID, err := uuid.Parse(c.Params("delivery"))
+	if err != nil {
+		return errs.Wrap(errs.ErrValidation, err, "delivery ID must be a UUID")
+	}
+
+	// Checking, if the delivery was made to given webhook.
+	d, err := w.Repo.GetDelivery(c.UserContext(), deliveryID)
+	if errors.Is(err, errs.ErrNotFound) || (err == nil && d.WebhookID != id) {
+		return errs.New(errs.ErrNotFound, "delivery with the given ID is not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	// Queue a new delivery.
+	redelivery, err := w.Notifier.Redeliver(c.UserContext(), &d)
+	if err != nil {
+		return err
+	}
+
+	// Return status 202 Accepted.
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"error":    false,
+		"msg":      nil,
+		"delivery": redelivery,
+	})
+}
+
+// findWebhook gets webhook by the ID in the URL of given request, reporting
+// a missing one as not found.
+func (w *WebhookSvcImpl) findWebhook(c *fiber.Ctx) (repo.Webhook, error) {
+	id, err := webhookID(c)
+	if err != nil {
+		return repo.Webhook{}, err
+	}
+	hook, err := w.Repo.GetWebhook(c.UserContext(), id)
+	if errors.Is(err, errs.ErrNotFound) {
+		return hook, errs.Wrap(errs.ErrNotFound, err, "webhook with the given ID is not found")
+	}
+	return hook, err
+}
+
+// apply checks request r and sets its fields on hook. The secret of hook is
+// kept when r has none.
+func (r *webhookRequest) apply(hook *repo.Webhook) error {
+	hook.URL = r.URL
+	hook.Events = []string{}
+	seen := map[string]bool{}
+	for _, e := range r.Events {
+		if !webhookEvents[e] {
+			return errs.Invalid(errs.FieldError{
+				Name:    "Events",
+				Pointer: "/events",
+				Detail:  fmt.Sprintf("unknown event %q, events must be BookCreated, BookUpdated or BookDeleted", e),
+			})
+		}
+		if !seen[e] {
+			seen[e] = true
+			hook.Events = append(hook.Events, e)
+		}
+	}
+	if r.Secret != "" {
+		hook.Secret = r.Secret
+	}
+	if r.Active != nil {
+		hook.Active = *r.Active
+	}
+
+	// Validate webhook fields.
+	if err := utils.NewValidator().Struct(hook); err != nil {
+		return utils.ValidationError(err)
+	}
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return errs.Invalid(errs.FieldError{
+			Name:    "URL",
+			Pointer: "/url",
+			Detail:  "url must be an http or https URL",
+		})
+	}
+	return nil
+}
+
+// webhookID parses the webhook ID in the URL of given request.
+func webhookID(c *fiber.Ctx) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return id, errs.Wrap(errs.ErrValidation, err, "webhook ID must be a UUID")
+	}
+	return id, nil
+}
+
+// newSecret returns a random webhook secret.
+func newSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
@@ -11,13 +11,15 @@ import (
 
 	"github.com/caarlos0/env/v10"
 	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
 )
 
 type (
 	// Databases setup output
 	Databases struct {
 		dig.Out
-		Pg *sql.DB `name:"pg"`
+		Pg    *sql.DB      `name:"pg"`
+		PgCfg *DatabaseCfg `name:"pg"`
 		// MySQL *sql.DB `name:"mysql"`
 	}
 	DatabaseCfgs struct {
@@ -36,6 +38,28 @@ type (
 		MaxOpenConns    int           `env:"MAX_OPEN_CONNS" envDefault:"30"`
 		MaxIdleConns    int           `env:"MAX_IDLE_CONNS" envDefault:"6"`
 		ConnMaxLifetime time.Duration `env:"CONN_MAX_LIFETIME" envDefault:"30m"`
+
+		// QueryTimeout bounds every single query issued by the repositories.
+		QueryTimeout time.Duration `env:"QUERY_TIMEOUT" envDefault:"5s"`
+		// BatchTimeout bounds the transaction of a batch or a bulk insert as
+		// a whole, each of its operations being bounded by QueryTimeout.
+		BatchTimeout time.Duration `env:"BATCH_TIMEOUT" envDefault:"2m"`
+		// ImportTimeout bounds the transaction of a catalogue import, made
+		// of as many bulk inserts as it takes.
+		ImportTimeout time.Duration `env:"IMPORT_TIMEOUT" envDefault:"10m"`
+		// ExportTimeout bounds a catalogue export, from its first row to
+		// its last, however slowly the client reads it.
+		ExportTimeout time.Duration `env:"EXPORT_TIMEOUT" envDefault:"10m"`
+
+		// TxIsolation is the isolation level of transactions: read_committed,
+		// repeatable_read or serializable, the server default when empty.
+		TxIsolation string `env:"TX_ISOLATION" envDefault:"read_committed"`
+		// TxRetries bounds how many times a transaction failing on a
+		// serialization failure or a deadlock is run again.
+		TxRetries int `env:"TX_RETRIES" envDefault:"3"`
+		// TxRetryBackoff is the mean wait before the first retry, doubled on
+		// each next one.
+		TxRetryBackoff time.Duration `env:"TX_RETRY_BACKOFF" envDefault:"20ms"`
 	}
 )
 
@@ -43,7 +67,8 @@ func NewDatabases() Databases {
 	cfg := DatabaseCfg{}
 	env.Parse(&cfg)
 	return Databases{
-		Pg: openPostgres(&cfg),
+		Pg:    openPostgres(&cfg),
+		PgCfg: &cfg,
 		// MySQL: openMySQL(cfgs.Mysql),
 	}
 }
@@ -55,7 +80,7 @@ func openPostgres(p *DatabaseCfg) *sql.DB {
 	)
 	db, err := sql.Open("postgres", conn)
 	if err != nil {
-		fmt.Errorf("postgres: %s", err.Error())
+		log.Error().Err(err).Msg("postgres: open")
 	}
 
 	db.SetConnMaxLifetime(p.ConnMaxLifetime)
@@ -63,7 +88,7 @@ func openPostgres(p *DatabaseCfg) *sql.DB {
 	db.SetMaxOpenConns(p.MaxOpenConns)
 
 	if err = db.Ping(); err != nil {
-		fmt.Errorf("postgres: %s", err.Error())
+		log.Error().Err(err).Msg("postgres: ping")
 	}
 
 	return db
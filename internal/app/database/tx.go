@@ -0,0 +1,141 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/dig"
+)
+
+type (
+	// Querier runs queries, on the database or within a transaction. Both
+	// *sql.DB and *sql.Tx are Queriers.
+	Querier interface {
+		Exec(query string, args ...interface{}) (sql.Result, error)
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+		Query(query string, args ...interface{}) (*sql.Rows, error)
+		QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+		QueryRow(query string, args ...interface{}) *sql.Row
+		QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	}
+	// TxManager runs units of work in transactions. Repositories ask it for
+	// their Querier, so that every call made with the context of a unit of
+	// work joins its transaction.
+	TxManager interface {
+		// WithinTx runs fn in a transaction carried by the context handed
+		// to fn, committed when fn succeeds and rolled back otherwise. When
+		// ctx already carries a transaction, fn joins it. A transaction
+		// failing on a serialization failure or a deadlock is retried with
+		// backoff, fn being run again from the start.
+		WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+		// Querier returns the transaction carried by ctx, or the database
+		// when there is none.
+		Querier(ctx context.Context) Querier
+	}
+	TxManagerImpl struct {
+		dig.In
+		DB  *sql.DB      `name:"pg"`
+		Cfg *DatabaseCfg `name:"pg"`
+	}
+	txManager struct {
+		TxManagerImpl
+		opts *sql.TxOptions
+	}
+	txKey struct{}
+)
+
+// isolationLevels maps the TX_ISOLATION values to isolation levels.
+var isolationLevels = map[string]sql.IsolationLevel{
+	"":                sql.LevelDefault,
+	"read_committed":  sql.LevelReadCommitted,
+	"repeatable_read": sql.LevelRepeatableRead,
+	"serializable":    sql.LevelSerializable,
+}
+
+func NewTxManager(impl TxManagerImpl) TxManager {
+	level, ok := isolationLevels[strings.ToLower(impl.Cfg.TxIsolation)]
+	if !ok {
+		log.Error().Str("isolation", impl.Cfg.TxIsolation).Msg("postgres: unknown isolation level, using the default")
+	}
+	return &txManager{TxManagerImpl: impl, opts: &sql.TxOptions{Isolation: level}}
+}
+
+func (m *txManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	backoff := m.Cfg.TxRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := m.run(ctx, fn)
+		if err == nil || !retryable(err) || attempt >= m.Cfg.TxRetries {
+			return err
+		}
+
+		log.Warn().Err(err).Int("attempt", attempt+1).Msg("postgres: retrying transaction")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff)+1))):
+		}
+		backoff *= 2
+	}
+}
+
+// run runs fn in one transaction.
+func (m *txManager) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := m.DB.BeginTx(ctx, m.opts)
+	if err != nil {
+		return txErr(err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	return txErr(tx.Commit())
+}
+
+func (m *txManager) Querier(ctx context.Context) Querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return m.DB
+}
+
+// txErr maps an error beginning or committing a transaction to a domain
+// error.
+func txErr(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case retryable(err):
+		return errs.Wrap(errs.ErrConflict, err, "concurrent update, retry")
+	case errors.Is(err, context.DeadlineExceeded):
+		return errs.Wrap(errs.ErrTimeout, err, "transaction timed out")
+	case errors.Is(err, context.Canceled):
+		return errs.Wrap(errs.ErrCanceled, err, "transaction canceled")
+	}
+	return errs.Wrap(errs.ErrUnavailable, err, "database is unavailable")
+}
+
+// retryable reports whether given error ended a transaction that may go
+// through when run again.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code.Name() {
+	case "serialization_failure", "deadlock_detected":
+		return true
+	}
+	return false
+}
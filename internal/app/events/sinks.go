@@ -0,0 +1,175 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/internal/app/webhooks"
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+)
+
+// Sink names, as listed in OUTBOX_SINKS.
+const (
+	SinkStdout  = "stdout"
+	SinkRedis   = "redis"
+	SinkWebhook = "webhook"
+)
+
+type (
+	// Sink delivers events to downstream services.
+	Sink interface {
+		Publish(ctx context.Context, e repo.Event) error
+	}
+	// Publisher delivers events to every configured sink. An event failing
+	// on any sink is reported as failed, to be published again to all of
+	// them: sinks see each event at least once.
+	Publisher interface {
+		Sink
+	}
+	// Cfg configures the sinks events are published to.
+	Cfg struct {
+		// Sinks lists the sinks events are published to, besides the
+		// change stream.
+		Sinks []string `env:"OUTBOX_SINKS" envDefault:"stdout" envSeparator:","`
+		// Stream is the Redis stream events are added to, trimmed to about
+		// StreamMaxLen entries.
+		Stream       string `env:"OUTBOX_STREAM" envDefault:"book_app:events"`
+		StreamMaxLen int64  `env:"OUTBOX_STREAM_MAXLEN" envDefault:"100000"`
+		// WebhookURL is where events are posted, one at a time.
+		WebhookURL     string        `env:"OUTBOX_WEBHOOK_URL"`
+		WebhookTimeout time.Duration `env:"OUTBOX_WEBHOOK_TIMEOUT" envDefault:"5s"`
+	}
+	publisher []Sink
+
+	// StdoutSink writes events to a writer as JSON lines.
+	StdoutSink struct {
+		mu sync.Mutex
+		w  io.Writer
+	}
+	// RedisSink adds events to a Redis stream.
+	RedisSink struct {
+		client redis.UniversalClient
+		stream string
+		maxLen int64
+	}
+	// WebhookSink posts events as JSON to an URL.
+	WebhookSink struct {
+		client *http.Client
+		url    string
+	}
+)
+
+// NewPublisher returns a Publisher to the sinks listed in OUTBOX_SINKS,
+// then to the webhooks subscribed to events and to the change stream.
+func NewPublisher(redis *configs.RedisStorage, notifier webhooks.Notifier, stream Stream) Publisher {
+	cfg := Cfg{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Error().Err(err).Msg("outbox: config")
+	}
+
+	p := publisher{}
+	for _, name := range cfg.Sinks {
+		switch strings.TrimSpace(name) {
+		case SinkStdout:
+			p = append(p, NewStdoutSink(os.Stdout))
+		case SinkRedis:
+			p = append(p, NewRedisSink(redis.Client(), cfg.Stream, cfg.StreamMaxLen))
+		case SinkWebhook:
+			if cfg.WebhookURL == "" {
+				log.Error().Msg("outbox: OUTBOX_WEBHOOK_URL is not set, webhook sink disabled")
+				continue
+			}
+			p = append(p, NewWebhookSink(cfg.WebhookURL, cfg.WebhookTimeout))
+		case "":
+		default:
+			log.Error().Str("sink", name).Msg("outbox: unknown sink")
+		}
+	}
+	return append(p, notifier, stream)
+}
+
+func (p publisher) Publish(ctx context.Context, e repo.Event) error {
+	for _, s := range p {
+		if err := s.Publish(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewStdoutSink returns a sink writing events to w.
+func NewStdoutSink(w io.Writer) *StdoutSink {
+	return &StdoutSink{w: w}
+}
+
+func (s *StdoutSink) Publish(ctx context.Context, e repo.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.NewEncoder(s.w).Encode(e)
+}
+
+// NewRedisSink returns a sink adding events to given stream, trimmed to
+// about maxLen entries unless maxLen is 0.
+func NewRedisSink(client redis.UniversalClient, stream string, maxLen int64) *RedisSink {
+	return &RedisSink{client: client, stream: stream, maxLen: maxLen}
+}
+
+func (s *RedisSink) Publish(ctx context.Context, e repo.Event) error {
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"id":         e.ID.String(),
+			"type":       e.Type,
+			"book_id":    e.BookID.String(),
+			"version":    e.Version,
+			"actor":      e.Actor,
+			"request_id": e.RequestID,
+			"payload":    string(e.Payload),
+			"created_at": e.CreatedAt.Format(time.RFC3339Nano),
+		},
+	}).Err()
+}
+
+// NewWebhookSink returns a sink posting events to url.
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{client: &http.Client{Timeout: timeout}, url: url}
+}
+
+func (s *WebhookSink) Publish(ctx context.Context, e repo.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", e.ID.String())
+	req.Header.Set("X-Event-Type", e.Type)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
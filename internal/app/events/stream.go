@@ -0,0 +1,471 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// StreamChannel is the Redis channel book changes are fanned out on, to
+	// the stream of every instance.
+	StreamChannel = "book_app:stream"
+	// replayKey is the Redis list of the latest changes, newest first.
+	replayKey = "book_app:stream:replay"
+	// subscriptionKey is the Locals key of the subscription of a WebSocket
+	// connection.
+	subscriptionKey = "stream.subscription"
+)
+
+// errStreamClosed is returned when subscribing to a closed stream.
+var errStreamClosed = errs.New(errs.ErrUnavailable, "change stream is shutting down")
+
+type (
+	// Change is a book change pushed to stream clients. Its ID is the
+	// sequence of the event in the outbox.
+	Change struct {
+		ID        int64      `json:"id"`
+		Type      string     `json:"type"`
+		BookID    uuid.UUID  `json:"book_id"`
+		Version   int        `json:"version"`
+		Actor     string     `json:"actor"`
+		Book      *repo.Book `json:"book"`
+		CreatedAt time.Time  `json:"created_at"`
+	}
+	// Filter selects the changes a client is sent: those of the books by
+	// one of Authors, or with one of IDs. An empty filter selects every
+	// change.
+	Filter struct {
+		Authors map[string]bool
+		IDs     map[uuid.UUID]bool
+	}
+	// Stream pushes book changes to Server-Sent Events and WebSocket
+	// clients. Changes are published by the outbox relay of one instance
+	// and fanned out through Redis pub/sub to the clients of all of them.
+	Stream interface {
+		Sink
+		// Run receives the changes published on StreamChannel until ctx is
+		// done.
+		Run(ctx context.Context)
+		// Close ends every connection, for the server to shut down.
+		Close()
+		// SSEHandler streams changes as Server-Sent Events.
+		SSEHandler(c *fiber.Ctx) error
+		// WebSocketHandler streams changes as WebSocket text messages.
+		WebSocketHandler(c *fiber.Ctx) error
+	}
+	// StreamCfg configures the change stream.
+	StreamCfg struct {
+		// ReplaySize is how many of the latest changes are kept for clients
+		// resuming with Last-Event-ID.
+		ReplaySize int64 `env:"STREAM_REPLAY_SIZE" envDefault:"1000"`
+		// Heartbeat is how often idle connections are pinged.
+		Heartbeat time.Duration `env:"STREAM_HEARTBEAT" envDefault:"15s"`
+		// Buffer is how many changes a connection may lag behind before it
+		// is dropped, to resume with Last-Event-ID.
+		Buffer int `env:"STREAM_BUFFER" envDefault:"256"`
+	}
+	stream struct {
+		client redis.UniversalClient
+		cfg    StreamCfg
+
+		mu     sync.Mutex
+		subs   map[*subscription]bool
+		closed bool
+	}
+	// subscription is the changes waiting to be sent to one connection.
+	subscription struct {
+		filter  Filter
+		changes chan Change
+	}
+)
+
+// NewStream returns a Stream fanned out through Redis.
+func NewStream(redis *configs.RedisStorage) Stream {
+	cfg := StreamCfg{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Error().Err(err).Msg("stream: config")
+	}
+	return &stream{
+		client: redis.Client(),
+		cfg:    cfg,
+		subs:   map[*subscription]bool{},
+	}
+}
+
+// Match reports whether change ch is selected by f.
+func (f Filter) Match(ch *Change) bool {
+	if len(f.Authors) == 0 && len(f.IDs) == 0 {
+		return true
+	}
+	return f.IDs[ch.BookID] || (ch.Book != nil && f.Authors[ch.Book.Author])
+}
+
+// Publish adds e to the replay buffer and publishes it to every instance.
+func (s *stream) Publish(ctx context.Context, e repo.Event) error {
+	p := repo.EventPayload{}
+	if err := json.Unmarshal(e.Payload, &p); err != nil {
+		return err
+	}
+	data, err := json.Marshal(Change{
+		ID:        e.Seq,
+		Type:      e.Type,
+		BookID:    e.BookID,
+		Version:   e.Version,
+		Actor:     e.Actor,
+		Book:      p.Book,
+		CreatedAt: e.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LPush(ctx, replayKey, data)
+		p.LTrim(ctx, replayKey, 0, s.cfg.ReplaySize-1)
+		p.Publish(ctx, StreamChannel, data)
+		return nil
+	})
+	return err
+}
+
+func (s *stream) Run(ctx context.Context) {
+	for {
+		s.receive(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// receive hands the changes published on StreamChannel to the matching
+// subscriptions until ctx is done or the subscription fails.
+func (s *stream) receive(ctx context.Context) {
+	ps := s.client.Subscribe(ctx, StreamChannel)
+	defer ps.Close()
+	if _, err := ps.Receive(ctx); err != nil {
+		if ctx.Err() == nil {
+			log.Error().Err(err).Msg("stream: subscribe")
+		}
+		return
+	}
+
+	ch := ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			change := Change{}
+			if err := json.Unmarshal([]byte(msg.Payload), &change); err != nil {
+				log.Error().Err(err).Msg("stream: change")
+				continue
+			}
+			s.dispatch(change)
+		}
+	}
+}
+
+// dispatch hands change to the matching subscriptions, dropping those
+// lagging behind.
+func (s *stream) dispatch(change Change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subs {
+		if !sub.filter.Match(&change) {
+			continue
+		}
+		select {
+		case sub.changes <- change:
+		default:
+			log.Warn().Msg("stream: dropping lagging connection")
+			delete(s.subs, sub)
+			close(sub.changes)
+		}
+	}
+}
+
+func (s *stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for sub := range s.subs {
+		delete(s.subs, sub)
+		close(sub.changes)
+	}
+}
+
+// subscribe registers a subscription with given filter, and returns it with
+// the changes published after the one by given ID, oldest first. No
+// change is replayed when lastID is 0.
+func (s *stream) subscribe(ctx context.Context, f Filter, lastID int64) (*subscription, []Change, error) {
+	sub := &subscription{filter: f, changes: make(chan Change, s.cfg.Buffer)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, nil, errStreamClosed
+	}
+	s.subs[sub] = true
+	s.mu.Unlock()
+
+	if lastID == 0 {
+		return sub, nil, nil
+	}
+	items, err := s.client.LRange(ctx, replayKey, 0, -1).Result()
+	if err != nil {
+		s.unsubscribe(sub)
+		return nil, nil, errs.Wrap(errs.ErrUnavailable, err, "change stream is unavailable")
+	}
+
+	// Replay what follows lastID in publication order, or every change
+	// after it when it fell out of the buffer.
+	changes := make([]Change, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		change := Change{}
+		if err := json.Unmarshal([]byte(items[i]), &change); err != nil {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	start := -1
+	for i, change := range changes {
+		if change.ID == lastID {
+			start = i + 1
+			break
+		}
+	}
+	replay := []Change{}
+	for i, change := range changes {
+		if (start >= 0 && i >= start) || (start < 0 && change.ID > lastID) {
+			if f.Match(&change) {
+				replay = append(replay, change)
+			}
+		}
+	}
+	return sub, replay, nil
+}
+
+// unsubscribe removes given subscription.
+func (s *stream) unsubscribe(sub *subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs[sub] {
+		delete(s.subs, sub)
+		close(sub.changes)
+	}
+}
+
+// serve sends the replayed changes then the live ones to a connection
+// through send, pinging it through ping when idle, until a send fails or
+// the stream closes.
+func (s *stream) serve(sub *subscription, replay []Change, send func(Change) error, ping func() error) {
+	defer s.unsubscribe(sub)
+
+	// Skip live changes that were replayed already.
+	replayed := make(map[int64]bool, len(replay))
+	for _, change := range replay {
+		if err := send(change); err != nil {
+			return
+		}
+		replayed[change.ID] = true
+	}
+
+	heartbeat := time.NewTicker(s.cfg.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case change, ok := <-sub.changes:
+			if !ok {
+				return
+			}
+			if replayed[change.ID] {
+				continue
+			}
+			if err := send(change); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// SSEHandler func streams book changes as Server-Sent Events.
+// @Description Push book changes as they happen, as Server-Sent Events named after the event type,
+// @Description with the change as JSON data. A client resuming with Last-Event-ID is first sent the
+// @Description changes it missed, as long as they are in the replay buffer.
+// @Summary stream book changes
+// @Tags Books
+// @Produce text/event-stream
+// @Param author query string false "Only books by this author, repeatable"
+// @Param id query string false "Only the book with this ID, repeatable"
+// @Param Last-Event-ID header string false "ID of the last change received"
+// @Success 200 {string} string "the event stream"
+// @Router /v1/books/stream [get]
+func (s *stream) SSEHandler(c *fiber.Ctx) error {
+	f, err := streamFilter(c)
+	if err != nil {
+		return err
+	}
+	lastID, err := lastEventID(c.Get("Last-Event-ID", c.Query("last_event_id")))
+	if err != nil {
+		return err
+	}
+	sub, replay, err := s.subscribe(c.UserContext(), f, lastID)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	// Stream changes as they come, once the headers are sent.
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		fmt.Fprintf(w, "retry: %d\n\n", time.Second.Milliseconds()*3)
+		if err := w.Flush(); err != nil {
+			s.unsubscribe(sub)
+			return
+		}
+		s.serve(sub, replay, func(change Change) error {
+			data, err := json.Marshal(change)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.ID, change.Type, data)
+			return w.Flush()
+		}, func() error {
+			w.WriteString(": heartbeat\n\n")
+			return w.Flush()
+		})
+	})
+	return nil
+}
+
+// WebSocketHandler func streams book changes over a WebSocket.
+// @Description Push book changes as they happen, one JSON text message per change. Filters and
+// @Description last_event_id work as for the Server-Sent Events stream.
+// @Summary stream book changes over a WebSocket
+// @Tags Books
+// @Param author query string false "Only books by this author, repeatable"
+// @Param id query string false "Only the book with this ID, repeatable"
+// @Param last_event_id query int false "ID of the last change received"
+// @Success 101 {string} string "switching protocols"
+// @Failure 426 {string} status "not a WebSocket upgrade"
+// @Router /v1/books/ws [get]
+func (s *stream) WebSocketHandler(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+	f, err := streamFilter(c)
+	if err != nil {
+		return err
+	}
+	lastID, err := lastEventID(c.Query("last_event_id"))
+	if err != nil {
+		return err
+	}
+	sub, replay, err := s.subscribe(c.UserContext(), f, lastID)
+	if err != nil {
+		return err
+	}
+	c.Locals(subscriptionKey, sub)
+
+	err = websocket.New(func(conn *websocket.Conn) {
+		sub := conn.Locals(subscriptionKey).(*subscription)
+		var mu sync.Mutex
+		write := func(messageType int, data []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(s.cfg.Heartbeat))
+			return conn.WriteMessage(messageType, data)
+		}
+
+		// Read until the client goes away, answering pings and closes.
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					s.unsubscribe(sub)
+					return
+				}
+			}
+		}()
+
+		s.serve(sub, replay, func(change Change) error {
+			data, err := json.Marshal(change)
+			if err != nil {
+				return err
+			}
+			return write(websocket.TextMessage, data)
+		}, func() error {
+			return write(websocket.PingMessage, nil)
+		})
+		write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+	})(c)
+	if err != nil {
+		s.unsubscribe(sub)
+	}
+	return err
+}
+
+// streamFilter parses the author and id query parameters of given request,
+// each repeatable or comma separated.
+func streamFilter(c *fiber.Ctx) (Filter, error) {
+	f := Filter{Authors: map[string]bool{}, IDs: map[uuid.UUID]bool{}}
+	args := c.Context().QueryArgs()
+	for _, v := range args.PeekMulti("author") {
+		for _, author := range strings.Split(string(v), ",") {
+			if author = strings.TrimSpace(author); author != "" {
+				f.Authors[author] = true
+			}
+		}
+	}
+	for _, v := range args.PeekMulti("id") {
+		for _, s := range strings.Split(string(v), ",") {
+			id, err := uuid.Parse(strings.TrimSpace(s))
+			if err != nil {
+				return f, errs.Wrap(errs.ErrValidation, err, "book ID must be a UUID")
+			}
+			f.IDs[id] = true
+		}
+	}
+	return f, nil
+}
+
+// lastEventID parses the ID of the last change a client received, 0 when
+// missing.
+func lastEventID(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0, errs.New(errs.ErrValidation, "last event ID must be a change ID")
+	}
+	return id, nil
+}
@@ -0,0 +1,221 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// newTestStream returns a stream over a miniredis server.
+func newTestStream(t *testing.T, cfg StreamCfg) *stream {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return &stream{client: client, cfg: cfg, subs: map[*subscription]bool{}}
+}
+
+// publish publishes an event by given sequence about a book by author.
+func publish(t *testing.T, s *stream, seq int64, author string) {
+	t.Helper()
+	book := &repo.Book{ID: uuid.New(), Author: author}
+	payload, err := json.Marshal(repo.EventPayload{Action: repo.ActionUpdate, Book: book})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := repo.Event{Seq: seq, ID: uuid.New(), Type: repo.EventBookUpdated, BookID: book.ID, Payload: payload}
+	if err := s.Publish(context.Background(), e); err != nil {
+		t.Fatalf("Publish(%d): %v", seq, err)
+	}
+}
+
+// ids returns the IDs of given changes.
+func ids(changes []Change) []int64 {
+	out := []int64{}
+	for _, ch := range changes {
+		out = append(out, ch.ID)
+	}
+	return out
+}
+
+func TestFilterMatch(t *testing.T) {
+	dune, emma := uuid.New(), uuid.New()
+	herbert := &Change{BookID: dune, Book: &repo.Book{ID: dune, Author: "Herbert"}}
+	austen := &Change{BookID: emma, Book: &repo.Book{ID: emma, Author: "Austen"}}
+	noBook := &Change{BookID: emma}
+	for _, tt := range []struct {
+		name   string
+		filter Filter
+		change *Change
+		want   bool
+	}{
+		{"empty filter", Filter{}, herbert, true},
+		{"empty filter without book", Filter{}, noBook, true},
+		{"author", Filter{Authors: map[string]bool{"Herbert": true}}, herbert, true},
+		{"other author", Filter{Authors: map[string]bool{"Herbert": true}}, austen, false},
+		{"author without book", Filter{Authors: map[string]bool{"Austen": true}}, noBook, false},
+		{"ID", Filter{IDs: map[uuid.UUID]bool{emma: true}}, austen, true},
+		{"ID without book", Filter{IDs: map[uuid.UUID]bool{emma: true}}, noBook, true},
+		{"other ID", Filter{IDs: map[uuid.UUID]bool{emma: true}}, herbert, false},
+		{"author or ID", Filter{Authors: map[string]bool{"Herbert": true}, IDs: map[uuid.UUID]bool{emma: true}}, austen, true},
+		{"neither author nor ID", Filter{Authors: map[string]bool{"Tolkien": true}, IDs: map[uuid.UUID]bool{emma: true}}, herbert, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(tt.change); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	s := newTestStream(t, StreamCfg{ReplaySize: 3, Buffer: 8, Heartbeat: time.Hour})
+	// The buffer keeps changes 3 to 5.
+	for i, author := range []string{"Herbert", "Austen", "Herbert", "Austen", "Herbert"} {
+		publish(t, s, int64(i+1), author)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		lastID int64
+		filter Filter
+		want   []int64
+	}{
+		{"no last ID", 0, Filter{}, nil},
+		{"last ID found", 3, Filter{}, []int64{4, 5}},
+		{"last ID newest", 5, Filter{}, []int64{}},
+		{"last ID found and filtered", 3, Filter{Authors: map[string]bool{"Herbert": true}}, []int64{5}},
+		{"last ID out of the buffer", 1, Filter{}, []int64{3, 4, 5}},
+		{"last ID out of the buffer and filtered", 2, Filter{Authors: map[string]bool{"Austen": true}}, []int64{4}},
+		{"last ID ahead of the buffer", 9, Filter{}, []int64{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, replay, err := s.subscribe(context.Background(), tt.filter, tt.lastID)
+			if err != nil {
+				t.Fatalf("subscribe: %v", err)
+			}
+			defer s.unsubscribe(sub)
+			if tt.want == nil {
+				if replay != nil {
+					t.Errorf("replay = %v, want none", ids(replay))
+				}
+				return
+			}
+			if got := ids(replay); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replay = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatchDropsLaggingSubscriber(t *testing.T) {
+	s := newTestStream(t, StreamCfg{Buffer: 2, Heartbeat: time.Hour})
+	lagging, _, err := s.subscribe(context.Background(), Filter{Authors: map[string]bool{"Herbert": true}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := s.subscribe(context.Background(), Filter{Authors: map[string]bool{"Austen": true}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The third change overflows the buffer of the lagging subscription,
+	// which the other one does not match.
+	for id := int64(1); id <= 3; id++ {
+		s.dispatch(Change{ID: id, Book: &repo.Book{Author: "Herbert"}})
+	}
+
+	got := []int64{}
+	for ch := range lagging.changes {
+		got = append(got, ch.ID)
+	}
+	if want := []int64{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lagging subscription got %v before being dropped, want %v", got, want)
+	}
+	s.mu.Lock()
+	dropped, kept := !s.subs[lagging], s.subs[other]
+	s.mu.Unlock()
+	if !dropped || !kept {
+		t.Errorf("lagging dropped = %v, other kept = %v; want both", dropped, kept)
+	}
+	if n := len(other.changes); n != 0 {
+		t.Errorf("other subscription got %d changes, want 0", n)
+	}
+
+	// Unsubscribing a dropped subscription is harmless.
+	s.unsubscribe(lagging)
+}
+
+func TestClose(t *testing.T) {
+	s := newTestStream(t, StreamCfg{Buffer: 2, Heartbeat: time.Hour})
+	sub, _, err := s.subscribe(context.Background(), Filter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.serve(sub, nil, func(Change) error { return nil }, func() error { return nil })
+	}()
+
+	s.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection still served after Close")
+	}
+	if _, ok := <-sub.changes; ok {
+		t.Error("subscription channel open after Close")
+	}
+
+	// Closing again, and dispatching to no one, are harmless.
+	s.Close()
+	s.dispatch(Change{ID: 1})
+
+	if _, _, err := s.subscribe(context.Background(), Filter{}, 0); !errors.Is(err, errs.ErrUnavailable) {
+		t.Errorf("subscribe after Close error = %v, want %v", err, errs.ErrUnavailable)
+	}
+}
+
+func TestServeSkipsReplayedChanges(t *testing.T) {
+	s := newTestStream(t, StreamCfg{Buffer: 8, Heartbeat: time.Hour})
+	sub, _, err := s.subscribe(context.Background(), Filter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Change 2 was published while the replay was read.
+	s.dispatch(Change{ID: 2})
+	s.dispatch(Change{ID: 3})
+
+	sent := make(chan int64, 8)
+	go s.serve(sub, []Change{{ID: 1}, {ID: 2}}, func(ch Change) error {
+		sent <- ch.ID
+		if ch.ID == 3 {
+			return errors.New("client gone")
+		}
+		return nil
+	}, func() error { return nil })
+
+	got := []int64{}
+	for len(got) < 3 {
+		select {
+		case id := <-sent:
+			got = append(got, id)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("sent %v, want 3 changes", got)
+		}
+	}
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,678 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/caohoangphuctd97/go-test/internal/app/auth"
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/reqctx"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// KeyPrefix namespaces the job keys in Redis. The hash tag keeps every
+	// key of the queue in the same cluster slot.
+	KeyPrefix = "book_app:{jobs}:"
+
+	queueKey      = KeyPrefix + "queue"
+	scheduledKey  = KeyPrefix + "scheduled"
+	processingKey = KeyPrefix + "processing"
+	deadKey       = KeyPrefix + "dead"
+	jobKeyFmt     = KeyPrefix + "job:%s"
+	inputKeyFmt   = KeyPrefix + "job:%s:input"
+	outputKeyFmt  = KeyPrefix + "job:%s:output"
+
+	// cancelGrace is how long a shutdown that ran out of time waits for
+	// the jobs it canceled to be queued again.
+	cancelGrace = 5 * time.Second
+)
+
+// Job statuses.
+const (
+	StatusQueued    = "queued"
+	StatusScheduled = "scheduled"
+	StatusRunning   = "running"
+	StatusRetrying  = "retrying"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+type (
+	// Job is a unit of work run in the background by a worker.
+	Job struct {
+		ID          uuid.UUID       `json:"id"`
+		Type        string          `json:"type"`
+		Payload     json.RawMessage `json:"payload,omitempty"`
+		Status      string          `json:"status"`
+		Attempts    int             `json:"attempts"`
+		MaxAttempts int             `json:"max_attempts"`
+		Error       string          `json:"error,omitempty"`
+		Result      json.RawMessage `json:"result,omitempty"`
+		// Actor and RequestID are those of the request that enqueued the
+		// job, handed down to its handler through the context.
+		Actor     string    `json:"actor,omitempty"`
+		RequestID string    `json:"request_id,omitempty"`
+		RunAt     time.Time `json:"run_at"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+		// HasInput tells whether the job was enqueued WithInput.
+		HasInput bool `json:"has_input,omitempty"`
+
+		input []byte
+	}
+	// View is a job as served to clients, without its payload.
+	View struct {
+		ID          uuid.UUID       `json:"id"`
+		Type        string          `json:"type"`
+		Status      string          `json:"status"`
+		Attempts    int             `json:"attempts"`
+		MaxAttempts int             `json:"max_attempts"`
+		Error       string          `json:"error,omitempty"`
+		Result      json.RawMessage `json:"result,omitempty"`
+		Actor       string          `json:"actor,omitempty"`
+		RunAt       time.Time       `json:"run_at"`
+		CreatedAt   time.Time       `json:"created_at"`
+		UpdatedAt   time.Time       `json:"updated_at"`
+	}
+	// File is the output of a job, downloaded as an attachment.
+	File struct {
+		Name        string
+		ContentType string
+		Data        []byte
+	}
+	// Handler runs a job and returns its result, to be stored as JSON. A
+	// failing job is retried with backoff until it runs out of attempts,
+	// then moved to the dead-letter queue.
+	Handler func(ctx context.Context, job *Job) (interface{}, error)
+	// Option sets up a job being enqueued.
+	Option func(*Job)
+
+	// Queue enqueues jobs in Redis and runs them on a pool of workers.
+	Queue interface {
+		// Register sets the handler of given job type.
+		Register(jobType string, h Handler)
+		// Enqueue adds a job of given type and payload, run as soon as a
+		// worker is free unless scheduled with At or After.
+		Enqueue(ctx context.Context, jobType string, payload interface{}, opts ...Option) (*Job, error)
+		// Get returns the job by given ID.
+		Get(ctx context.Context, id uuid.UUID) (*Job, error)
+		// Input returns the input of the job by given ID, enqueued
+		// WithInput. It is deleted once the job succeeds or is dead.
+		Input(ctx context.Context, id uuid.UUID) ([]byte, error)
+		// SetOutput stores given file as the output of the job by given
+		// ID, for as long as finished jobs are kept.
+		SetOutput(ctx context.Context, id uuid.UUID, file *File) error
+		// Start starts the workers and the scheduler.
+		Start()
+		// Shutdown stops taking jobs and waits for the running ones to
+		// finish, until ctx is done or the shutdown timeout is over. Jobs
+		// still running then are canceled and queued again, their attempt
+		// not counted.
+		Shutdown(ctx context.Context) error
+		// StatusHandler serves the job in the id param to the actor who
+		// enqueued it, or to an admin.
+		StatusHandler(c *fiber.Ctx) error
+		// DownloadHandler serves the output of the succeeded job in the id
+		// param, to the same callers as StatusHandler.
+		DownloadHandler(c *fiber.Ctx) error
+	}
+	// Cfg configures the job queue.
+	Cfg struct {
+		// Workers is how many jobs run at once on this instance.
+		Workers int `env:"JOB_WORKERS" envDefault:"4"`
+		// MaxAttempts is how many times a job runs before it is dead.
+		MaxAttempts int `env:"JOB_MAX_ATTEMPTS" envDefault:"5"`
+		// Backoff is the wait before the first retry, doubled on each next
+		// one up to MaxBackoff.
+		Backoff    time.Duration `env:"JOB_RETRY_BACKOFF" envDefault:"1s"`
+		MaxBackoff time.Duration `env:"JOB_MAX_RETRY_BACKOFF" envDefault:"5m"`
+		// Visibility is how long a running job may go without news before
+		// it is deemed lost with its worker, and queued again on start.
+		Visibility time.Duration `env:"JOB_VISIBILITY" envDefault:"15m"`
+		// Retention is how long finished jobs can be looked up.
+		Retention time.Duration `env:"JOB_RETENTION" envDefault:"168h"`
+		// ShutdownTimeout is how long a shutdown waits for running jobs.
+		ShutdownTimeout time.Duration `env:"JOB_SHUTDOWN_TIMEOUT" envDefault:"30s"`
+	}
+	queue struct {
+		client redis.UniversalClient
+		cfg    Cfg
+
+		mu       sync.RWMutex
+		handlers map[string]Handler
+
+		// run is the context of running jobs, canceled when a shutdown
+		// runs out of time.
+		run      context.Context
+		cancel   context.CancelFunc
+		stop     chan struct{}
+		stopOnce sync.Once
+		wg       sync.WaitGroup
+	}
+)
+
+// At schedules a job to run at given time.
+func At(t time.Time) Option {
+	return func(j *Job) {
+		j.RunAt = t
+	}
+}
+
+// After schedules a job to run after given delay.
+func After(d time.Duration) Option {
+	return func(j *Job) {
+		j.RunAt = time.Now().Add(d)
+	}
+}
+
+// MaxAttempts overrides how many times a job runs before it is dead.
+func MaxAttempts(n int) Option {
+	return func(j *Job) {
+		j.MaxAttempts = n
+	}
+}
+
+// WithInput stores given data apart from the job, for inputs too large
+// for its payload: it is read with Queue.Input, and deleted once the job
+// succeeds or is dead.
+func WithInput(data []byte) Option {
+	return func(j *Job) {
+		j.input = data
+		j.HasInput = true
+	}
+}
+
+// View returns the job as served to clients.
+func (j *Job) View() *View {
+	return &View{
+		ID:          j.ID,
+		Type:        j.Type,
+		Status:      j.Status,
+		Attempts:    j.Attempts,
+		MaxAttempts: j.MaxAttempts,
+		Error:       j.Error,
+		Result:      j.Result,
+		Actor:       j.Actor,
+		RunAt:       j.RunAt,
+		CreatedAt:   j.CreatedAt,
+		UpdatedAt:   j.UpdatedAt,
+	}
+}
+
+// NewQueue returns a Queue backed by Redis.
+func NewQueue(redis *configs.RedisStorage) Queue {
+	cfg := Cfg{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Error().Err(err).Msg("jobs: config")
+	}
+	return newQueue(redis.Client(), cfg)
+}
+
+func newQueue(client redis.UniversalClient, cfg Cfg) *queue {
+	run, cancel := context.WithCancel(context.Background())
+	return &queue{
+		client:   client,
+		cfg:      cfg,
+		handlers: map[string]Handler{},
+		run:      run,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (q *queue) Register(jobType string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = h
+}
+
+func (q *queue) Enqueue(ctx context.Context, jobType string, payload interface{}, opts ...Option) (*Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	job := &Job{
+		ID:          uuid.New(),
+		Type:        jobType,
+		Payload:     raw,
+		Status:      StatusQueued,
+		MaxAttempts: q.cfg.MaxAttempts,
+		Actor:       reqctx.Actor(ctx),
+		RequestID:   reqctx.RequestID(ctx),
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+	if job.MaxAttempts < 1 {
+		job.MaxAttempts = 1
+	}
+
+	scheduled := job.RunAt.After(now)
+	if scheduled {
+		job.Status = StatusScheduled
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	_, err = q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		if scheduled {
+			p.ZAdd(ctx, scheduledKey, &redis.Z{Score: float64(job.RunAt.UnixMilli()), Member: job.ID.String()})
+		} else {
+			p.LPush(ctx, queueKey, job.ID.String())
+		}
+		p.Set(ctx, jobKey(job.ID), data, 0)
+		if job.HasInput {
+			p.Set(ctx, inputKey(job.ID), job.input, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errs.Wrap(errs.ErrUnavailable, err, "job queue is unavailable")
+	}
+	return job, nil
+}
+
+func (q *queue) Get(ctx context.Context, id uuid.UUID) (*Job, error) {
+	data, err := q.client.Get(ctx, jobKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errs.New(errs.ErrNotFound, "job with the given ID is not found")
+	}
+	if err != nil {
+		return nil, errs.Wrap(errs.ErrUnavailable, err, "job queue is unavailable")
+	}
+	job := &Job{}
+	return job, json.Unmarshal(data, job)
+}
+
+func (q *queue) Input(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	data, err := q.client.Get(ctx, inputKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errs.New(errs.ErrNotFound, "job input is not found")
+	}
+	if err != nil {
+		return nil, errs.Wrap(errs.ErrUnavailable, err, "job queue is unavailable")
+	}
+	return data, nil
+}
+
+func (q *queue) SetOutput(ctx context.Context, id uuid.UUID, file *File) error {
+	key := outputKey(id)
+	_, err := q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, key, "name", file.Name, "content_type", file.ContentType, "data", file.Data)
+		p.Expire(ctx, key, q.cfg.Retention)
+		return nil
+	})
+	if err != nil {
+		return errs.Wrap(errs.ErrUnavailable, err, "job queue is unavailable")
+	}
+	return nil
+}
+
+func (q *queue) Start() {
+	q.recover()
+
+	q.wg.Add(1)
+	go q.schedule()
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	log.Info().Int("workers", q.cfg.Workers).Msg("jobs: started")
+}
+
+func (q *queue) Shutdown(ctx context.Context) error {
+	q.stopOnce.Do(func() { close(q.stop) })
+	ctx, cancel := context.WithTimeout(ctx, q.cfg.ShutdownTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info().Msg("jobs: drained")
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		select {
+		case <-done:
+		case <-time.After(cancelGrace):
+			log.Warn().Msg("jobs: canceled jobs still running, queued again on next start")
+		}
+		return ctx.Err()
+	}
+}
+
+func (q *queue) StatusHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return errs.Wrap(errs.ErrValidation, err, "job ID must be a UUID")
+	}
+	job, err := q.Get(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+	if !visible(c, job) {
+		return errs.New(errs.ErrNotFound, "job with the given ID is not found")
+	}
+
+	// Return status 200 OK.
+	return c.JSON(fiber.Map{
+		"error": false,
+		"msg":   nil,
+		"job":   job.View(),
+	})
+}
+
+func (q *queue) DownloadHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return errs.Wrap(errs.ErrValidation, err, "job ID must be a UUID")
+	}
+	job, err := q.Get(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+	if !visible(c, job) {
+		return errs.New(errs.ErrNotFound, "job with the given ID is not found")
+	}
+	if job.Status != StatusSucceeded {
+		return errs.New(errs.ErrConflict, fmt.Sprintf("job is %s, its output is ready once it succeeded", job.Status))
+	}
+	output, err := q.client.HGetAll(c.UserContext(), outputKey(id)).Result()
+	if err != nil {
+		return errs.Wrap(errs.ErrUnavailable, err, "job queue is unavailable")
+	}
+	if len(output) == 0 {
+		return errs.New(errs.ErrNotFound, "job has no output")
+	}
+
+	// Return status 200 OK.
+	c.Attachment(output["name"])
+	c.Set(fiber.HeaderContentType, output["content_type"])
+	return c.SendString(output["data"])
+}
+
+// visible reports whether the caller of c may see given job: the actor
+// who enqueued it, unless anonymous, or an admin. Others are told it is
+// not found, not to learn which jobs exist.
+func visible(c *fiber.Ctx, job *Job) bool {
+	if auth.ClaimsOf(c).Grants(auth.ScopeAdmin) {
+		return true
+	}
+	actor := reqctx.Actor(c.UserContext())
+	return actor != reqctx.Anonymous && actor == job.Actor
+}
+
+// stopped reports whether a shutdown began.
+func (q *queue) stopped() bool {
+	select {
+	case <-q.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for given delay, unless a shutdown begins.
+func (q *queue) wait(d time.Duration) {
+	select {
+	case <-q.stop:
+	case <-time.After(d):
+	}
+}
+
+// work takes queued jobs one at a time until a shutdown begins.
+func (q *queue) work() {
+	defer q.wg.Done()
+	for !q.stopped() {
+		id, err := q.client.BRPopLPush(context.Background(), queueKey, processingKey, time.Second).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("jobs: take")
+			q.wait(time.Second)
+			continue
+		}
+		q.process(id)
+	}
+}
+
+// schedule queues the scheduled jobs that are due, every second until a
+// shutdown begins.
+func (q *queue) schedule() {
+	defer q.wg.Done()
+	for !q.stopped() {
+		ctx := context.Background()
+		ids, err := q.client.ZRangeByScore(ctx, scheduledKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   fmt.Sprint(time.Now().UnixMilli()),
+			Count: 100,
+		}).Result()
+		if err != nil {
+			log.Error().Err(err).Msg("jobs: schedule")
+		}
+		for _, id := range ids {
+			// Only the instance removing the job queues it.
+			if n, err := q.client.ZRem(ctx, scheduledKey, id).Result(); err == nil && n == 1 {
+				q.client.LPush(ctx, queueKey, id)
+			}
+		}
+		if len(ids) < 100 {
+			q.wait(time.Second)
+		}
+	}
+}
+
+// recover queues again the jobs left running by workers that are gone.
+func (q *queue) recover() {
+	ctx := context.Background()
+	ids, err := q.client.LRange(ctx, processingKey, 0, -1).Result()
+	if err != nil {
+		log.Error().Err(err).Msg("jobs: recover")
+		return
+	}
+	for _, id := range ids {
+		jobID, err := uuid.Parse(id)
+		if err == nil {
+			job, err := q.Get(ctx, jobID)
+			if err == nil && time.Since(job.UpdatedAt) < q.cfg.Visibility {
+				continue
+			}
+		}
+		if n, err := q.client.LRem(ctx, processingKey, 1, id).Result(); err == nil && n == 1 {
+			q.client.RPush(ctx, queueKey, id)
+			log.Warn().Str("job", id).Msg("jobs: queued again")
+		}
+	}
+}
+
+// process runs the job by given ID and records its outcome.
+func (q *queue) process(id string) {
+	ctx := context.Background()
+	requeued := false
+	defer func() {
+		// Once queued again, the job may be processing on another worker.
+		if !requeued {
+			q.client.LRem(ctx, processingKey, 1, id)
+		}
+	}()
+
+	jobID, err := uuid.Parse(id)
+	if err != nil {
+		return
+	}
+	job, err := q.Get(ctx, jobID)
+	if err != nil {
+		log.Error().Err(err).Str("job", id).Msg("jobs: load")
+		return
+	}
+
+	job.Status = StatusRunning
+	job.Attempts++
+	job.UpdatedAt = time.Now()
+	if err := q.save(ctx, job, 0); err != nil {
+		log.Error().Err(err).Str("job", id).Msg("jobs: save")
+	}
+
+	result, err := q.call(job)
+	job.UpdatedAt = time.Now()
+	if err != nil && q.run.Err() != nil {
+		// Canceled by a shutdown: not the job's fault.
+		requeued = q.requeue(ctx, job)
+		return
+	}
+	if err == nil {
+		job.Status = StatusSucceeded
+		job.Error = ""
+		if job.Result, err = json.Marshal(result); err == nil {
+			if err := q.finish(ctx, job); err != nil {
+				log.Error().Err(err).Str("job", id).Msg("jobs: save")
+			}
+			return
+		}
+	}
+
+	job.Error = err.Error()
+	if job.Attempts < job.MaxAttempts {
+		// Retry with backoff.
+		job.Status = StatusRetrying
+		job.RunAt = time.Now().Add(q.backoff(job.Attempts))
+		_, err = q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			data, _ := json.Marshal(job)
+			p.Set(ctx, jobKey(job.ID), data, 0)
+			p.ZAdd(ctx, scheduledKey, &redis.Z{Score: float64(job.RunAt.UnixMilli()), Member: id})
+			return nil
+		})
+		log.Warn().Str("job", id).Str("type", job.Type).Int("attempt", job.Attempts).Str("error", job.Error).Msg("jobs: retrying")
+	} else {
+		// Out of attempts, dead-letter it.
+		job.Status = StatusDead
+		err = q.finish(ctx, job, func(p redis.Pipeliner) {
+			p.LPush(ctx, deadKey, id)
+		})
+		log.Error().Str("job", id).Str("type", job.Type).Str("error", job.Error).Msg("jobs: dead")
+	}
+	if err != nil {
+		log.Error().Err(err).Str("job", id).Msg("jobs: save")
+	}
+}
+
+// requeue queues again given job, interrupted by a shutdown, at the head
+// of the queue and without counting its attempt. It reports whether the
+// job left the processing list, recover queuing it again otherwise.
+func (q *queue) requeue(ctx context.Context, job *Job) bool {
+	id := job.ID.String()
+	job.Status = StatusQueued
+	job.Attempts--
+	job.Error = ""
+	job.RunAt = job.UpdatedAt
+	data, err := json.Marshal(job)
+	if err != nil {
+		log.Error().Err(err).Str("job", id).Msg("jobs: requeue")
+		return false
+	}
+	if _, err := q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, jobKey(job.ID), data, 0)
+		p.LRem(ctx, processingKey, 1, id)
+		// Taken from the right, so that it runs next.
+		p.RPush(ctx, queueKey, id)
+		return nil
+	}); err != nil {
+		log.Error().Err(err).Str("job", id).Msg("jobs: requeue")
+		return false
+	}
+	log.Warn().Str("job", id).Str("type", job.Type).Msg("jobs: interrupted by shutdown, queued again")
+	return true
+}
+
+// call runs the handler of given job, turning a panic into an error.
+func (q *queue) call(job *Job) (result interface{}, err error) {
+	q.mu.RLock()
+	h, ok := q.handlers[job.Type]
+	q.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no handler for job type %q", job.Type)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	ctx := reqctx.WithRequestID(reqctx.WithActor(q.run, job.Actor), job.RequestID)
+	return h(ctx, job)
+}
+
+// finish stores given job, done, to expire after the retention, and deletes
+// its input, along with the commands of more.
+func (q *queue) finish(ctx context.Context, job *Job, more ...func(p redis.Pipeliner)) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, jobKey(job.ID), data, q.cfg.Retention)
+		if job.HasInput {
+			p.Del(ctx, inputKey(job.ID))
+		}
+		for _, fn := range more {
+			fn(p)
+		}
+		return nil
+	})
+	return err
+}
+
+// save stores given job, expiring after ttl unless ttl is 0.
+func (q *queue) save(ctx context.Context, job *Job, ttl time.Duration) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.client.Set(ctx, jobKey(job.ID), data, ttl).Err()
+}
+
+// backoff returns the wait before given retry attempt, with jitter.
+func (q *queue) backoff(attempt int) time.Duration {
+	d := q.cfg.Backoff
+	for i := 1; i < attempt && d < q.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > q.cfg.MaxBackoff {
+		d = q.cfg.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func jobKey(id uuid.UUID) string {
+	return fmt.Sprintf(jobKeyFmt, id)
+}
+
+func inputKey(id uuid.UUID) string {
+	return fmt.Sprintf(inputKeyFmt, id)
+}
+
+func outputKey(id uuid.UUID) string {
+	return fmt.Sprintf(outputKeyFmt, id)
+}
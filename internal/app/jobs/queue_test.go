@@ -0,0 +1,476 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/caohoangphuctd97/go-test/internal/app/auth"
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/caohoangphuctd97/go-test/pkg/reqctx"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// testCfg is a queue config quick enough for tests.
+var testCfg = Cfg{
+	Workers:         2,
+	MaxAttempts:     3,
+	Backoff:         10 * time.Millisecond,
+	MaxBackoff:      40 * time.Millisecond,
+	Visibility:      time.Minute,
+	Retention:       time.Hour,
+	ShutdownTimeout: 5 * time.Second,
+}
+
+// newTestQueue returns a queue backed by an in-process Redis.
+func newTestQueue(t *testing.T, cfg Cfg) (*queue, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return newQueue(client, cfg), mr
+}
+
+// waitFor polls cond until it holds, failing the test after a while.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// jobStatus returns the status of the job by given ID.
+func jobStatus(t *testing.T, q *queue, id uuid.UUID) *Job {
+	t.Helper()
+	job, err := q.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// list returns the IDs in the list at given key.
+func list(t *testing.T, q *queue, key string) []string {
+	t.Helper()
+	ids, err := q.client.LRange(context.Background(), key, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func shutdown(t *testing.T, q *queue) {
+	t.Helper()
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+}
+
+func TestEnqueueThenProcess(t *testing.T) {
+	q, _ := newTestQueue(t, testCfg)
+	type payload struct{ N int }
+	q.Register("double", func(ctx context.Context, job *Job) (interface{}, error) {
+		p := payload{}
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"n": p.N * 2, "actor": reqctx.Actor(ctx)}, nil
+	})
+	q.Start()
+	defer shutdown(t, q)
+
+	ctx := reqctx.WithActor(context.Background(), "alice")
+	job, err := q.Enqueue(ctx, "double", payload{N: 21})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusQueued {
+		t.Fatalf("status = %q, want %q", job.Status, StatusQueued)
+	}
+
+	waitFor(t, "job to succeed", func() bool { return jobStatus(t, q, job.ID).Status == StatusSucceeded })
+	done := jobStatus(t, q, job.ID)
+	if done.Attempts != 1 {
+		t.Errorf("attempts = %d, want 1", done.Attempts)
+	}
+	if got := string(done.Result); got != `{"actor":"alice","n":42}` {
+		t.Errorf("result = %s, want {\"actor\":\"alice\",\"n\":42}", got)
+	}
+	waitFor(t, "processing list to empty", func() bool { return len(list(t, q, processingKey)) == 0 })
+}
+
+func TestRetryThenDeadLetter(t *testing.T) {
+	q, _ := newTestQueue(t, testCfg)
+	var calls int32
+	q.Register("fail", func(ctx context.Context, job *Job) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("boom")
+	})
+	q.Start()
+	defer shutdown(t, q)
+
+	job, err := q.Enqueue(context.Background(), "fail", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retried from the schedule after the first failure.
+	waitFor(t, "job to be retried", func() bool { return jobStatus(t, q, job.ID).Attempts >= 1 })
+	waitFor(t, "job to be dead", func() bool { return jobStatus(t, q, job.ID).Status == StatusDead })
+	dead := jobStatus(t, q, job.ID)
+	if dead.Attempts != testCfg.MaxAttempts {
+		t.Errorf("attempts = %d, want %d", dead.Attempts, testCfg.MaxAttempts)
+	}
+	if got := atomic.LoadInt32(&calls); got != int32(testCfg.MaxAttempts) {
+		t.Errorf("handler calls = %d, want %d", got, testCfg.MaxAttempts)
+	}
+	if dead.Error != "boom" {
+		t.Errorf("error = %q, want boom", dead.Error)
+	}
+	if ids := list(t, q, deadKey); len(ids) != 1 || ids[0] != job.ID.String() {
+		t.Errorf("dead-letter list = %v, want [%s]", ids, job.ID)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q, _ := newTestQueue(t, Cfg{Backoff: time.Second, MaxBackoff: 5 * time.Second})
+	for _, tt := range []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	} {
+		for i := 0; i < 20; i++ {
+			if d := q.backoff(tt.attempt); d < tt.max/2 || d > tt.max {
+				t.Fatalf("backoff(%d) = %s, want within [%s, %s]", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestScheduledJobQueuedWhenDue(t *testing.T) {
+	q, _ := newTestQueue(t, testCfg)
+	job, err := q.Enqueue(context.Background(), "later", nil, After(300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusScheduled {
+		t.Fatalf("status = %q, want %q", job.Status, StatusScheduled)
+	}
+	if ids := list(t, q, queueKey); len(ids) != 0 {
+		t.Fatalf("queue = %v before the job is due, want empty", ids)
+	}
+	due, err := q.client.ZScore(context.Background(), scheduledKey, job.ID.String()).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(due) != job.RunAt.UnixMilli() {
+		t.Errorf("scheduled at %d, want %d", int64(due), job.RunAt.UnixMilli())
+	}
+
+	// Run the scheduler alone, so that the job stays queued.
+	q.wg.Add(1)
+	go q.schedule()
+	defer shutdown(t, q)
+
+	waitFor(t, "job to be queued", func() bool { return len(list(t, q, queueKey)) == 1 })
+	if ids := list(t, q, queueKey); ids[0] != job.ID.String() {
+		t.Errorf("queue = %v, want [%s]", ids, job.ID)
+	}
+	if n := q.client.ZCard(context.Background(), scheduledKey).Val(); n != 0 {
+		t.Errorf("scheduled jobs = %d, want 0", n)
+	}
+	if time.Now().Before(job.RunAt) {
+		t.Error("job queued before it was due")
+	}
+}
+
+func TestRecoverQueuesStaleJobs(t *testing.T) {
+	q, _ := newTestQueue(t, testCfg)
+	ctx := context.Background()
+	stale, err := q.Enqueue(ctx, "stale", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := q.Enqueue(ctx, "fresh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both taken by workers, the stale one long gone.
+	for _, job := range []*Job{stale, fresh} {
+		if err := q.client.LMove(ctx, queueKey, processingKey, "RIGHT", "LEFT").Err(); err != nil {
+			t.Fatal(err)
+		}
+		job.Status = StatusRunning
+		job.Attempts = 1
+		job.UpdatedAt = time.Now()
+	}
+	stale.UpdatedAt = time.Now().Add(-2 * testCfg.Visibility)
+	for _, job := range []*Job{stale, fresh} {
+		if err := q.save(ctx, job, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q.recover()
+
+	if ids := list(t, q, queueKey); len(ids) != 1 || ids[0] != stale.ID.String() {
+		t.Errorf("queue = %v, want [%s]", ids, stale.ID)
+	}
+	if ids := list(t, q, processingKey); len(ids) != 1 || ids[0] != fresh.ID.String() {
+		t.Errorf("processing = %v, want [%s]", ids, fresh.ID)
+	}
+}
+
+func TestShutdownDrainsRunningJobs(t *testing.T) {
+	q, _ := newTestQueue(t, testCfg)
+	started := make(chan struct{})
+	q.Register("slow", func(ctx context.Context, job *Job) (interface{}, error) {
+		close(started)
+		select {
+		case <-time.After(300 * time.Millisecond):
+			return "done", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	q.Start()
+
+	job, err := q.Enqueue(context.Background(), "slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	shutdown(t, q)
+
+	done := jobStatus(t, q, job.ID)
+	if done.Status != StatusSucceeded {
+		t.Errorf("status after shutdown = %q, want %q", done.Status, StatusSucceeded)
+	}
+	if ids := list(t, q, processingKey); len(ids) != 0 {
+		t.Errorf("processing = %v, want empty", ids)
+	}
+}
+
+func TestShutdownTimeoutQueuesJobsAgain(t *testing.T) {
+	cfg := testCfg
+	cfg.ShutdownTimeout = 100 * time.Millisecond
+	q, _ := newTestQueue(t, cfg)
+	started := make(chan struct{})
+	q.Register("stuck", func(ctx context.Context, job *Job) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	q.Start()
+
+	job, err := q.Enqueue(context.Background(), "stuck", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := q.Shutdown(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Queued again on return, without waiting for the visibility timeout.
+	requeued := jobStatus(t, q, job.ID)
+	if requeued.Status != StatusQueued {
+		t.Errorf("status = %q, want %q", requeued.Status, StatusQueued)
+	}
+	if requeued.Attempts != 0 {
+		t.Errorf("attempts = %d, want 0: the canceled one is not counted", requeued.Attempts)
+	}
+	if ids := list(t, q, queueKey); len(ids) != 1 || ids[0] != job.ID.String() {
+		t.Errorf("queue = %v, want [%s]", ids, job.ID)
+	}
+	if ids := list(t, q, processingKey); len(ids) != 0 {
+		t.Errorf("processing = %v, want empty", ids)
+	}
+}
+
+func TestInputKeptApartUntilJobFinishes(t *testing.T) {
+	q, mr := newTestQueue(t, testCfg)
+	got := make(chan []byte, 1)
+	q.Register("import", func(ctx context.Context, job *Job) (interface{}, error) {
+		input, err := q.Input(ctx, job.ID)
+		got <- input
+		return nil, err
+	})
+
+	input := []byte(strings.Repeat("title,author\n", 100))
+	job, err := q.Enqueue(context.Background(), "import", map[string]bool{"dry_run": true}, WithInput(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := mr.Get(jobKey(job.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "title,author") || len(stored) > len(input) {
+		t.Errorf("job stored with its input: %d bytes", len(stored))
+	}
+	if !mr.Exists(inputKey(job.ID)) {
+		t.Fatal("input is not stored")
+	}
+
+	q.Start()
+	defer shutdown(t, q)
+	if data := <-got; string(data) != string(input) {
+		t.Errorf("Input() = %d bytes, want %d", len(data), len(input))
+	}
+	waitFor(t, "job to succeed", func() bool { return jobStatus(t, q, job.ID).Status == StatusSucceeded })
+	if mr.Exists(inputKey(job.ID)) {
+		t.Error("input kept once the job succeeded")
+	}
+}
+
+// stubRevocations revokes no token.
+type stubRevocations struct{ auth.Revocations }
+
+func (stubRevocations) Revoked(context.Context, *auth.Claims) (bool, error) { return false, nil }
+
+const testSecret = "test-secret"
+
+// newTestApp returns an app serving the job routes of q to callers
+// authenticated by tokens of testToken.
+func newTestApp(t *testing.T, q *queue) *fiber.App {
+	t.Helper()
+	t.Setenv("JWT_SECRET", testSecret)
+	app := fiber.New(fiber.Config{ErrorHandler: configs.ErrorHandler})
+	authenticate := auth.NewAuthenticator(auth.AuthenticatorImpl{Revocations: stubRevocations{}}).Authenticate
+	app.Get("/jobs/:id", authenticate, q.StatusHandler)
+	app.Get("/jobs/:id/download", authenticate, q.DownloadHandler)
+	return app
+}
+
+// testToken returns the Authorization header of a token of given subject
+// and roles.
+func testToken(t *testing.T, subject string, roles ...string) string {
+	t.Helper()
+	claims := auth.Claims{Roles: roles}
+	claims.Subject = subject
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.SchemeBearer + " " + signed
+}
+
+// get requests path of app with given Authorization header.
+func get(t *testing.T, app *fiber.App, path, authorization string) (int, http.Header, string) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodGet, path, nil)
+	if authorization != "" {
+		req.Header.Set(fiber.HeaderAuthorization, authorization)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header, string(body)
+}
+
+func TestStatusHandlerServesOwnerAndAdmin(t *testing.T) {
+	q, _ := newTestQueue(t, testCfg)
+	app := newTestApp(t, q)
+
+	ctx := reqctx.WithActor(context.Background(), "alice")
+	job, err := q.Enqueue(ctx, "import", map[string]string{"secret": "payload"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous, err := q.Enqueue(context.Background(), "import", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := func(subject string, roles ...string) string { return testToken(t, subject, roles...) }
+	for _, tt := range []struct {
+		name          string
+		job           *Job
+		authorization string
+		want          int
+	}{
+		{"owner", job, token("alice", auth.RoleEditor), fiber.StatusOK},
+		{"other editor", job, token("bob", auth.RoleEditor), fiber.StatusNotFound},
+		{"admin", job, token("carol", auth.RoleAdmin), fiber.StatusOK},
+		{"anonymous", job, "", fiber.StatusNotFound},
+		{"anonymous job, anonymous caller", anonymous, "", fiber.StatusNotFound},
+		{"anonymous job, admin", anonymous, token("carol", auth.RoleAdmin), fiber.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := get(t, app, "/jobs/"+tt.job.ID.String(), tt.authorization)
+			if status != tt.want {
+				t.Fatalf("status = %d, want %d: %s", status, tt.want, body)
+			}
+			if strings.Contains(body, "payload") {
+				t.Errorf("job served with its payload: %s", body)
+			}
+		})
+	}
+}
+
+func TestDownloadHandlerServesOutput(t *testing.T) {
+	q, mr := newTestQueue(t, testCfg)
+	app := newTestApp(t, q)
+	release := make(chan struct{})
+	q.Register("export", func(ctx context.Context, job *Job) (interface{}, error) {
+		<-release
+		return nil, q.SetOutput(ctx, job.ID, &File{Name: "books.csv", ContentType: "text/csv; charset=utf-8", Data: []byte("id,title\n")})
+	})
+	q.Start()
+	defer shutdown(t, q)
+
+	job, err := q.Enqueue(reqctx.WithActor(context.Background(), "alice"), "export", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/jobs/" + job.ID.String() + "/download"
+	alice := testToken(t, "alice", auth.RoleReader)
+	if status, _, body := get(t, app, path, alice); status != fiber.StatusConflict {
+		t.Errorf("status before the job succeeded = %d, want %d: %s", status, fiber.StatusConflict, body)
+	}
+
+	close(release)
+	waitFor(t, "job to succeed", func() bool { return jobStatus(t, q, job.ID).Status == StatusSucceeded })
+	status, header, body := get(t, app, path, alice)
+	if status != fiber.StatusOK || body != "id,title\n" {
+		t.Fatalf("download = %d %q, want %d %q", status, body, fiber.StatusOK, "id,title\n")
+	}
+	if ct := header.Get(fiber.HeaderContentType); ct != "text/csv; charset=utf-8" {
+		t.Errorf("content type = %q, want text/csv; charset=utf-8", ct)
+	}
+	if cd := header.Get(fiber.HeaderContentDisposition); cd != `attachment; filename="books.csv"` {
+		t.Errorf("content disposition = %q, want attachment; filename=\"books.csv\"", cd)
+	}
+	if status, _, _ := get(t, app, path, testToken(t, "bob", auth.RoleReader)); status != fiber.StatusNotFound {
+		t.Errorf("status for another reader = %d, want %d", status, fiber.StatusNotFound)
+	}
+	if ttl := mr.TTL(outputKey(job.ID)); ttl <= 0 || ttl > testCfg.Retention {
+		t.Errorf("output TTL = %s, want within the retention of %s", ttl, testCfg.Retention)
+	}
+}
@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.uber.org/dig"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+type (
+	// APIKey is a key of a machine client, granted Scopes until ExpiresAt
+	// if set. Hash is the hex SHA-256 of the key, found by its Prefix.
+	APIKey struct {
+		ID         uuid.UUID  `json:"id"`
+		Name       string     `json:"name" validate:"required,lte=255"`
+		Prefix     string     `json:"prefix"`
+		Hash       string     `json:"-"`
+		Scopes     []string   `json:"scopes"`
+		CreatedBy  string     `json:"created_by"`
+		CreatedAt  time.Time  `json:"created_at"`
+		ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+		LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+		RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	}
+	APIKeyRepo interface {
+		GetAPIKeys(context.Context) ([]APIKey, error)
+		GetAPIKey(context.Context, uuid.UUID) (APIKey, error)
+		GetAPIKeyByPrefix(context.Context, string) (APIKey, error)
+		CreateAPIKey(context.Context, *APIKey) error
+		RevokeAPIKey(context.Context, uuid.UUID, time.Time) (APIKey, error)
+		TouchAPIKey(context.Context, uuid.UUID, time.Time) error
+	}
+	APIKeyRepoImpl struct {
+		dig.In
+		Cfg *databases.DatabaseCfg `name:"pg"`
+		Tx  databases.TxManager
+	}
+)
+
+// apiKeyColumns lists the columns in the order they are scanned by
+// scanAPIKey.
+var apiKeyColumns = []string{
+	"id", "name", "prefix", "hash", "scopes", "created_by", "created_at", "expires_at", "last_used_at", "revoked_at",
+}
+
+func NewAPIKeyRepo(impl APIKeyRepoImpl) APIKeyRepo {
+	return &impl
+}
+
+// GetAPIKeys method for getting every API key, revoked ones included,
+// latest first.
+func (q *APIKeyRepoImpl) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	// Define keys variable.
+	keys := []APIKey{}
+
+	rows, err := psql.Select(apiKeyColumns...).From("api_keys").OrderBy("created_at DESC", "id").
+		RunWith(q.Tx.Querier(ctx)).QueryContext(ctx)
+	if err != nil {
+		return keys, dbErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return keys, dbErr(ctx, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, dbErr(ctx, rows.Err())
+}
+
+// GetAPIKey method for getting one API key by given ID.
+func (q *APIKeyRepoImpl) GetAPIKey(ctx context.Context, id uuid.UUID) (APIKey, error) {
+	return q.getAPIKey(ctx, sq.Eq{"id": id})
+}
+
+// GetAPIKeyByPrefix method for getting one API key by given prefix.
+func (q *APIKeyRepoImpl) GetAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error) {
+	return q.getAPIKey(ctx, sq.Eq{"prefix": prefix})
+}
+
+// CreateAPIKey method for creating API key by given APIKey object.
+func (q *APIKeyRepoImpl) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	_, err := psql.Insert("api_keys").Columns(apiKeyColumns...).
+		Values(k.ID, k.Name, k.Prefix, k.Hash, pq.Array(k.Scopes), k.CreatedBy, k.CreatedAt,
+			k.ExpiresAt, k.LastUsedAt, k.RevokedAt).
+		RunWith(q.Tx.Querier(ctx)).ExecContext(ctx)
+	return dbErr(ctx, err)
+}
+
+// RevokeAPIKey method for revoking API key by given ID at given time, and
+// returning it. Revoking a revoked key keeps the first revocation time.
+func (q *APIKeyRepoImpl) RevokeAPIKey(ctx context.Context, id uuid.UUID, at time.Time) (APIKey, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	k, err := scanAPIKey(psql.Update("api_keys").
+		Set("revoked_at", sq.Expr("COALESCE(revoked_at, ?)", at)).
+		Where(sq.Eq{"id": id}).
+		Suffix("RETURNING " + strings.Join(apiKeyColumns, ", ")).
+		RunWith(q.Tx.Querier(ctx)).QueryRowContext(ctx))
+	return k, dbErr(ctx, err)
+}
+
+// TouchAPIKey method for recording given time as the last use of API key
+// by given ID.
+func (q *APIKeyRepoImpl) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	res, err := psql.Update("api_keys").Set("last_used_at", at).Where(sq.Eq{"id": id}).
+		RunWith(q.Tx.Querier(ctx)).ExecContext(ctx)
+	if err != nil {
+		return dbErr(ctx, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return dbErr(ctx, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// getAPIKey reads the API key matching where.
+func (q *APIKeyRepoImpl) getAPIKey(ctx context.Context, where sq.Sqlizer) (APIKey, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	k, err := scanAPIKey(psql.Select(apiKeyColumns...).From("api_keys").Where(where).
+		RunWith(q.Tx.Querier(ctx)).QueryRowContext(ctx))
+	return k, dbErr(ctx, err)
+}
+
+// scanAPIKey reads an API key selected with apiKeyColumns.
+func scanAPIKey(row sq.RowScanner) (APIKey, error) {
+	k := APIKey{Scopes: []string{}}
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.Hash, pq.Array(&k.Scopes), &k.CreatedBy, &k.CreatedAt,
+		&k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
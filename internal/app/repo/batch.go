@@ -0,0 +1,176 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/google/uuid"
+)
+
+// MaxBatchSize caps the operations of a batch, and the books of a bulk
+// insert.
+const MaxBatchSize = 1000
+
+// ErrBatchAborted is returned when an atomic batch is rolled back because
+// one of its operations failed.
+var ErrBatchAborted = errs.New(errs.ErrUnprocessable, "batch was rolled back, no operation was applied")
+
+type (
+	// BookOp is one operation of a batch: ActionCreate of Book,
+	// ActionUpdate of book ID at Version with the fields of Book, or
+	// ActionDelete of book ID at Version.
+	BookOp struct {
+		Action  string
+		ID      uuid.UUID
+		Version int
+		Book    *Book
+	}
+	// BookOpResult is the outcome of a BookOp: the book it left, or why it
+	// failed.
+	BookOpResult struct {
+		Book *Book
+		Err  error
+	}
+)
+
+// BulkInsert method for creating given books with a single multi-row
+// insert. It returns how many books were created. The insert is bounded by
+// the query timeout, its transaction by the batch timeout.
+func (q *BookRepoImpl) BulkInsert(ctx context.Context, books ...*Book) (int64, error) {
+	if len(books) == 0 {
+		return 0, nil
+	}
+	if len(books) > MaxBatchSize {
+		return 0, errs.New(errs.ErrValidation, fmt.Sprintf("cannot insert more than %d books at once", MaxBatchSize))
+	}
+
+	ctx, cancel := batchTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	var n int64
+	err := q.inTx(ctx, func(tx databases.Querier) error {
+		return q.batchOp(ctx, func(ctx context.Context) (err error) {
+			n, err = insertBooks(ctx, tx, books)
+			return err
+		})
+	})
+	return n, err
+}
+
+// ExecBatch method for running given operations in a single transaction.
+// Consecutive creations are inserted together. When atomic, the first
+// failing operation rolls the whole batch back and ErrBatchAborted is
+// returned; otherwise each operation is applied or not on its own. Either
+// way there is one result per operation. Each operation is bounded by the
+// query timeout, the transaction by the batch timeout.
+func (q *BookRepoImpl) ExecBatch(ctx context.Context, ops []BookOp, atomic bool) ([]BookOpResult, error) {
+	if len(ops) > MaxBatchSize {
+		return nil, errs.New(errs.ErrValidation, fmt.Sprintf("a batch cannot hold more than %d operations", MaxBatchSize))
+	}
+
+	ctx, cancel := batchTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	results := make([]BookOpResult, len(ops))
+	err := q.inTx(ctx, func(tx databases.Querier) error {
+		// Start over when the transaction is retried.
+		for i := range results {
+			results[i] = BookOpResult{}
+		}
+		for i := 0; i < len(ops); {
+			// Insert a run of creations at once, falling back to one at a
+			// time to tell which failed.
+			j := i
+			for j < len(ops) && ops[j].Action == ActionCreate {
+				j++
+			}
+			if j-i > 1 {
+				books := make([]*Book, 0, j-i)
+				for _, op := range ops[i:j] {
+					books = append(books, op.Book)
+				}
+				if err := savepoint(ctx, tx, func() error {
+					return q.batchOp(ctx, func(ctx context.Context) error {
+						_, err := insertBooks(ctx, tx, books)
+						return err
+					})
+				}); err == nil {
+					for k := i; k < j; k++ {
+						results[k].Book = ops[k].Book
+					}
+					i = j
+					continue
+				}
+			}
+			if j == i {
+				j++
+			}
+
+			for ; i < j; i++ {
+				op := ops[i]
+				results[i].Err = savepoint(ctx, tx, func() error {
+					return q.batchOp(ctx, func(ctx context.Context) (err error) {
+						results[i].Book, err = execOp(ctx, tx, op)
+						return err
+					})
+				})
+				if results[i].Err != nil && atomic {
+					return ErrBatchAborted
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil && err != ErrBatchAborted {
+		return nil, err
+	}
+	if err == ErrBatchAborted {
+		// Nothing was applied, not even the operations that went through.
+		for i := range results {
+			results[i].Book = nil
+		}
+	}
+	return results, err
+}
+
+// batchOp runs fn, one operation of a batch, bounded by the query timeout
+// rather than by the batch one.
+func (q *BookRepoImpl) batchOp(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+	return fn(ctx)
+}
+
+// execOp applies one operation of a batch within tx.
+func execOp(ctx context.Context, tx databases.Querier, op BookOp) (*Book, error) {
+	switch op.Action {
+	case ActionCreate:
+		_, err := insertBooks(ctx, tx, []*Book{op.Book})
+		return op.Book, err
+	case ActionUpdate:
+		return revise(ctx, tx, ActionUpdate, op.ID, replaceBook(op.Version, op.Book))
+	case ActionDelete:
+		return revise(ctx, tx, ActionDelete, op.ID, deleteBook(op.Version))
+	}
+	return nil, errs.New(errs.ErrValidation, fmt.Sprintf("unknown batch operation %q", op.Action))
+}
+
+// savepoint runs fn within a savepoint of tx, rolled back to when fn fails
+// so that tx can carry on.
+func savepoint(ctx context.Context, tx databases.Querier, fn func() error) error {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_op"); err != nil {
+		return dbErr(ctx, err)
+	}
+	if err := fn(); err != nil {
+		if _, rerr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_op"); rerr != nil {
+			return dbErr(ctx, rerr)
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_op"); err != nil {
+		return dbErr(ctx, err)
+	}
+	return nil
+}
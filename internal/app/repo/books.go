@@ -1,9 +1,13 @@
 package repo
 
 import (
-	"database/sql"
+	"context"
+	"fmt"
 	"time"
 
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/rowmap"
 	"github.com/google/uuid"
 	"go.uber.org/dig"
 
@@ -18,70 +22,183 @@ type (
 		UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
 		Title     string    `db:"title" json:"title" validate:"required,lte=255"`
 		Author    string    `db:"author" json:"author" validate:"required,lte=255"`
+		Version   int       `db:"version" json:"version"`
+		// DeletedAt is set once the book is deleted, until it is restored or
+		// purged.
+		DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
 	}
 	BookRepo interface {
-		GetBooks() ([]Book, error)
-		GetBook(uuid.UUID) (Book, error)
-		CreateBook(*Book) error
-		UpdateBook(uuid.UUID, *Book) error
-		DeleteBook(uuid.UUID) error
+		GetBooks(context.Context, BookFilter) (BookPage, error)
+		GetBook(context.Context, uuid.UUID) (Book, error)
+		CreateBook(context.Context, *Book) error
+		UpdateBook(context.Context, uuid.UUID, int, *Book) error
+		PatchBook(context.Context, uuid.UUID, int, *Book, []string) error
+		DeleteBook(context.Context, uuid.UUID, int) error
+		BulkInsert(context.Context, ...*Book) (int64, error)
+		ExecBatch(context.Context, []BookOp, bool) ([]BookOpResult, error)
+		RestoreBook(context.Context, uuid.UUID, time.Time) (Book, error)
+		RevertBook(context.Context, uuid.UUID, int, int, time.Time) (Book, error)
+		GetRevisions(context.Context, uuid.UUID) ([]Revision, error)
+		PurgeBooks(context.Context, time.Time) (int64, error)
+		SearchBooks(context.Context, BookSearch) (SearchPage, error)
+		ExportBooks(context.Context, func(*Book) error) error
 	}
 	BookRepoImpl struct {
 		dig.In
-		*sql.DB `name:"pg"`
+		Cfg *databases.DatabaseCfg `name:"pg"`
+		Tx  databases.TxManager
 	}
 )
 
+// PatchableBookFields maps the JSON name of every book field a client may
+// patch to its struct field name.
+var PatchableBookFields = map[string]string{
+	"title":  "Title",
+	"author": "Author",
+}
+
+// psql builds queries with Postgres placeholders.
+var psql = sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+
+// bookMap maps books rows to Book through its db tags, and bookColumns
+// lists the columns a book is read from.
+var (
+	bookMap     = rowmap.New(Book{})
+	bookColumns = bookMap.Columns()
+)
+
 func NewBookRepo(impl BookRepoImpl) BookRepo {
 	return &impl
 }
 
-// GetBooks method for getting all books.
-func (q *BookRepoImpl) GetBooks() ([]Book, error) {
-	// Define books variable.
-	books := []Book{}
+// withTimeout bounds a single query by the configured query timeout.
+func (q *BookRepoImpl) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return queryTimeout(ctx, q.Cfg)
+}
 
-	rows, err := sq.Select("*").From("books").RunWith(q.DB).Query()
+// queryTimeout bounds a single query by the query timeout of cfg.
+func queryTimeout(ctx context.Context, cfg *databases.DatabaseCfg) (context.Context, context.CancelFunc) {
+	if cfg == nil || cfg.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, cfg.QueryTimeout)
+}
+
+// batchTimeout bounds the transaction of a batch by the batch timeout of
+// cfg.
+func batchTimeout(ctx context.Context, cfg *databases.DatabaseCfg) (context.Context, context.CancelFunc) {
+	if cfg == nil || cfg.BatchTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, cfg.BatchTimeout)
+}
+
+// GetBooks method for getting one page of books matching given filter.
+func (q *BookRepoImpl) GetBooks(ctx context.Context, f BookFilter) (BookPage, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	// Define page variable.
+	page := BookPage{Books: []Book{}}
+
+	sort, desc, offset := f.Sort, f.Desc, f.Offset
+	if sort == "" {
+		sort = "created_at"
+	}
+	var cur *cursor
+	var seek interface{}
+	if f.Cursor != "" {
+		c := cursor{}
+		if err := decodeCursor(f.Cursor, &c); err != nil {
+			return page, err
+		}
+		v, err := c.value()
+		if err != nil {
+			return page, err
+		}
+		sort, desc, offset, cur, seek = c.Sort, c.Desc, 0, &c, v
+	}
+	if !BookSortColumns[sort] {
+		return page, errs.New(errs.ErrValidation, fmt.Sprintf("cannot sort on %q", sort))
+	}
+
+	where := f.where()
+	if err := psql.Select("COUNT(*)").From("books").Where(where).
+		RunWith(q.db(ctx)).QueryRowContext(ctx).Scan(&page.Total); err != nil {
+		return page, dbErr(ctx, err)
+	}
+
+	// Walking backward reads the rows before the cursor in reverse order.
+	backward := cur != nil && cur.Backward
+	cmp, dir := ">", "ASC"
+	if desc != backward {
+		cmp, dir = "<", "DESC"
+	}
+	if cur != nil {
+		where = append(where, sq.Expr(fmt.Sprintf("(%s, id) %s (?, ?)", sort, cmp), seek, cur.ID))
+	}
+
+	limit := f.limit()
+	rows, err := psql.Select(bookColumns...).From("books").Where(where).
+		OrderBy(sort+" "+dir, "id "+dir).
+		Limit(limit + 1).Offset(offset).
+		RunWith(q.db(ctx)).QueryContext(ctx)
 	if err != nil {
 		// Return empty object and error.
-		return books, err
+		return page, dbErr(ctx, err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		ent := Book{}
-		if err = rows.Scan(
-			&ent.ID,
-			&ent.Title,
-			&ent.Author,
-			&ent.UpdatedAt,
-			&ent.CreatedAt,
-		); err != nil {
-			return books, err
+		if err = rows.Scan(bookMap.Pointers(&ent, bookColumns...)...); err != nil {
+			return page, dbErr(ctx, err)
+		}
+		page.Books = append(page.Books, ent)
+	}
+	if err = rows.Err(); err != nil {
+		return page, dbErr(ctx, err)
+	}
+
+	more := uint64(len(page.Books)) > limit
+	if more {
+		page.Books = page.Books[:limit]
+	}
+	if backward {
+		for i, j := 0, len(page.Books)-1; i < j; i, j = i+1, j-1 {
+			page.Books[i], page.Books[j] = page.Books[j], page.Books[i]
+		}
+	}
+	if n := len(page.Books); n > 0 {
+		if more || backward {
+			page.NextCursor = cursorFor(&page.Books[n-1], sort, desc, false)
+		}
+		if (backward && more) || (!backward && (cur != nil || offset > 0)) {
+			page.PrevCursor = cursorFor(&page.Books[0], sort, desc, true)
 		}
-		books = append(books, ent)
 	}
+
 	// Return query result.
-	return books, nil
+	return page, nil
 }
 
-// GetBook method for getting one book by given ID.
-func (q *BookRepoImpl) GetBook(id uuid.UUID) (Book, error) {
+// notDeleted matches the books that are not deleted.
+var notDeleted = sq.Eq{"deleted_at": nil}
+
+// GetBook method for getting one book by given ID, unless it is deleted.
+func (q *BookRepoImpl) GetBook(ctx context.Context, id uuid.UUID) (Book, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
 	// Define book variable.
 	book := Book{}
 
-	rows, err := sq.Select("*").From("books").Where(sq.Eq{"id": id}).RunWith(q.DB).Query()
-	if err != nil {
+	// Send query to database, sql.ErrNoRows being reported as not found.
+	if err := psql.Select(bookColumns...).From("books").Where(sq.Eq{"id": id}).Where(notDeleted).
+		RunWith(q.db(ctx)).QueryRowContext(ctx).
+		Scan(bookMap.Pointers(&book, bookColumns...)...); err != nil {
 		// Return empty object and error.
-		return book, err
-	}
-	if err = rows.Scan(
-		&book.ID,
-		&book.Title,
-		&book.Author,
-		&book.UpdatedAt,
-		&book.CreatedAt,
-	); err != nil {
-		return book, err
+		return book, dbErr(ctx, err)
 	}
 
 	// Return query result.
@@ -89,49 +206,178 @@ func (q *BookRepoImpl) GetBook(id uuid.UUID) (Book, error) {
 }
 
 // CreateBook method for creating book by given Book object.
-func (q *BookRepoImpl) CreateBook(b *Book) error {
-	// Define query string.
-	query := `INSERT INTO books VALUES ($1, $2, $3, $4, $5)`
+func (q *BookRepoImpl) CreateBook(ctx context.Context, b *Book) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
 
-	// Send query to database.
-	_, err := q.Exec(query, b.ID, b.Title, b.Author, b.CreatedAt, b.UpdatedAt)
-	if err != nil {
-		// Return only error.
+	return q.inTx(ctx, func(tx databases.Querier) error {
+		_, err := insertBooks(ctx, tx, []*Book{b})
 		return err
+	})
+}
+
+// UpdateBook method for updating book by given Book object, provided the
+// stored book is still at given version. It returns ErrVersionConflict
+// otherwise. b is left holding the stored book.
+func (q *BookRepoImpl) UpdateBook(ctx context.Context, id uuid.UUID, version int, b *Book) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	return q.inTx(ctx, func(tx databases.Querier) error {
+		after, err := revise(ctx, tx, ActionUpdate, id, replaceBook(version, b))
+		if err == nil {
+			*b = *after
+		}
+		return err
+	})
+}
+
+// PatchBook method for writing given fields of Book object only, provided
+// the stored book is still at given version. Fields are named by their JSON
+// name, see PatchableBookFields. It returns ErrVersionConflict when the
+// version moved on. b is left holding the stored book.
+func (q *BookRepoImpl) PatchBook(ctx context.Context, id uuid.UUID, version int, b *Book, fields []string) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	set := map[string]interface{}{"updated_at": b.UpdatedAt}
+	for _, f := range fields {
+		switch f {
+		case "title":
+			set["title"] = b.Title
+		case "author":
+			set["author"] = b.Author
+		default:
+			return errs.New(errs.ErrUnprocessable, fmt.Sprintf("field %s can't be patched", f))
+		}
 	}
 
-	// This query returns nothing.
-	return nil
+	return q.inTx(ctx, func(tx databases.Querier) error {
+		after, err := revise(ctx, tx, ActionUpdate, id, setBook(version, set))
+		if err == nil {
+			*b = *after
+		}
+		return err
+	})
 }
 
-// UpdateBook method for updating book by given Book object.
-func (q *BookRepoImpl) UpdateBook(id uuid.UUID, b *Book) error {
-	// Define query string.
-	query := `UPDATE books SET updated_at = $2, title = $3, author = $4 WHERE id = $1`
+// DeleteBook method for soft deleting book by given ID, provided the stored
+// book is still at given version. It returns ErrVersionConflict otherwise.
+// The book is kept until RestoreBook brings it back or PurgeBooks removes it.
+func (q *BookRepoImpl) DeleteBook(ctx context.Context, id uuid.UUID, version int) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	return q.inTx(ctx, func(tx databases.Querier) error {
+		_, err := revise(ctx, tx, ActionDelete, id, deleteBook(version))
+		return err
+	})
+}
+
+// RestoreBook method for bringing back the deleted book by given ID, updated
+// at given time. It returns ErrNotFound when no such book is deleted.
+func (q *BookRepoImpl) RestoreBook(ctx context.Context, id uuid.UUID, updatedAt time.Time) (Book, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	// Define book variable.
+	book := Book{}
+
+	err := q.inTx(ctx, func(tx databases.Querier) error {
+		after, err := revise(ctx, tx, ActionRestore, id, func(ctx context.Context, tx databases.Querier, before *Book) (*Book, error) {
+			if before.DeletedAt == nil {
+				return nil, errs.New(errs.ErrNotFound, "book is not deleted")
+			}
+			return updateBook(ctx, tx, id, map[string]interface{}{
+				"deleted_at": nil,
+				"updated_at": updatedAt,
+			})
+		})
+		if err == nil {
+			book = *after
+		}
+		return err
+	})
+
+	// Return query result.
+	return book, err
+}
+
+// PurgeBooks method for permanently removing the books deleted before given
+// time, along with their history. It returns how many books were removed.
+func (q *BookRepoImpl) PurgeBooks(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
 
 	// Send query to database.
-	_, err := q.Exec(query, id, b.UpdatedAt, b.Title, b.Author)
+	res, err := psql.Delete("books").Where(sq.Lt{"deleted_at": before}).
+		RunWith(q.db(ctx)).ExecContext(ctx)
 	if err != nil {
 		// Return only error.
-		return err
+		return 0, dbErr(ctx, err)
 	}
+	return res.RowsAffected()
+}
 
-	// This query returns nothing.
-	return nil
+// db returns what queries run on: the transaction carried by ctx, if any.
+func (q *BookRepoImpl) db(ctx context.Context) databases.Querier {
+	return q.Tx.Querier(ctx)
 }
 
-// DeleteBook method for delete book by given ID.
-func (q *BookRepoImpl) DeleteBook(id uuid.UUID) error {
-	// Define query string.
-	query := `DELETE FROM books WHERE id = $1`
+// inTx runs fn in a transaction, committed when fn succeeds and rolled back
+// otherwise. It joins the transaction carried by ctx, if any.
+func (q *BookRepoImpl) inTx(ctx context.Context, fn func(tx databases.Querier) error) error {
+	return dbErr(ctx, q.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		return fn(q.db(ctx))
+	}))
+}
 
-	// Send query to database.
-	_, err := q.Exec(query, id)
+// insertBooks inserts given books with a single statement and records their
+// creation. It returns how many books were inserted.
+func insertBooks(ctx context.Context, tx databases.Querier, books []*Book) (int64, error) {
+	// Send query to database, naming every column written.
+	insert := psql.Insert("books").Columns(bookColumns...)
+	for _, b := range books {
+		insert = insert.Values(bookMap.Values(b, bookColumns...)...)
+	}
+	res, err := insert.RunWith(tx).ExecContext(ctx)
 	if err != nil {
 		// Return only error.
-		return err
+		return 0, dbErr(ctx, err)
 	}
 
-	// This query returns nothing.
-	return nil
+	revisions := make([]bookChange, len(books))
+	for i, b := range books {
+		revisions[i] = bookChange{after: b}
+	}
+	if err := recordRevisions(ctx, tx, ActionCreate, revisions...); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// replaceBook returns the write replacing every writable field of a book
+// at given version by those of b.
+func replaceBook(version int, b *Book) writeFunc {
+	return setBook(version, map[string]interface{}{
+		"updated_at": b.UpdatedAt,
+		"title":      b.Title,
+		"author":     b.Author,
+	})
+}
+
+// deleteBook returns the write soft deleting a book at given version.
+func deleteBook(version int) writeFunc {
+	return setBook(version, map[string]interface{}{"deleted_at": time.Now()})
+}
+
+// setBook returns the write setting given columns of a book at given
+// version.
+func setBook(version int, set map[string]interface{}) writeFunc {
+	return func(ctx context.Context, tx databases.Querier, before *Book) (*Book, error) {
+		if err := checkVersion(before, version); err != nil {
+			return nil, err
+		}
+		return updateBook(ctx, tx, before.ID, set)
+	}
 }
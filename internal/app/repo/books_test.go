@@ -0,0 +1,255 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/google/uuid"
+)
+
+// countingDriver is a database/sql driver answering the book queries with
+// canned rows, and counting the rows handles opened and closed. Rows left
+// open are closed by database/sql once the context of their query ends:
+// those are counted as leaked. The last query and its arguments are kept.
+type countingDriver struct {
+	opened, closed, leaked int64
+
+	mu        sync.Mutex
+	lastQuery string
+	lastArgs  []driver.NamedValue
+}
+
+type (
+	countingConn struct{ d *countingDriver }
+	countingStmt struct {
+		d     *countingDriver
+		query string
+	}
+	countingRows struct {
+		d       *countingDriver
+		ctx     context.Context
+		columns []string
+		values  [][]driver.Value
+		closed  bool
+	}
+)
+
+// testBookRows is how many books the counting driver lists.
+const testBookRows = 3
+
+// countingDrivers numbers the counting drivers, registered under distinct
+// names.
+var countingDrivers int64
+
+// openCountingDB returns a database answered by a new counting driver.
+func openCountingDB(t *testing.T) (*sql.DB, *countingDriver) {
+	t.Helper()
+	d := &countingDriver{}
+	name := fmt.Sprintf("counting%d", atomic.AddInt64(&countingDrivers, 1))
+	sql.Register(name, d)
+
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, d
+}
+
+func (d *countingDriver) Open(string) (driver.Conn, error) { return &countingConn{d: d}, nil }
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	return &countingStmt{d: c.d, query: query}, nil
+}
+func (c *countingConn) Close() error              { return nil }
+func (c *countingConn) Begin() (driver.Tx, error) { return nil, errors.New("no transactions") }
+
+func (s *countingStmt) Close() error  { return nil }
+func (s *countingStmt) NumInput() int { return -1 }
+func (s *countingStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("read only")
+}
+
+func (s *countingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), nil)
+}
+
+func (s *countingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	atomic.AddInt64(&s.d.opened, 1)
+	s.d.mu.Lock()
+	s.d.lastQuery, s.d.lastArgs = s.query, args
+	s.d.mu.Unlock()
+	if strings.HasPrefix(s.query, "SELECT COUNT(*)") {
+		return &countingRows{d: s.d, ctx: ctx, columns: []string{"count"}, values: [][]driver.Value{{int64(testBookRows)}}}, nil
+	}
+	n := testBookRows
+	if strings.Contains(s.query, "id = ") {
+		n = 1
+	}
+	rows := &countingRows{d: s.d, ctx: ctx, columns: bookColumns}
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		row := make([]driver.Value, len(bookColumns))
+		for j, col := range bookColumns {
+			switch col {
+			case "id":
+				row[j] = uuid.NewString()
+			case "created_at", "updated_at":
+				row[j] = now
+			case "title", "author":
+				row[j] = col
+			case "version":
+				row[j] = int64(1)
+			}
+		}
+		rows.values = append(rows.values, row)
+	}
+	return rows, nil
+}
+
+// last returns the last query run and its arguments.
+func (d *countingDriver) last() (string, []driver.NamedValue) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastQuery, d.lastArgs
+}
+
+func (r *countingRows) Columns() []string { return r.columns }
+
+func (r *countingRows) Close() error {
+	if !r.closed {
+		r.closed = true
+		atomic.AddInt64(&r.d.closed, 1)
+		if r.ctx.Err() != nil {
+			atomic.AddInt64(&r.d.leaked, 1)
+		}
+	}
+	return nil
+}
+
+func (r *countingRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// TestBookReadsReleaseConnections guards against rows handles left open,
+// each holding a pooled connection: with a single connection, a leak makes
+// every later read wait for the pool until it times out.
+func TestBookReadsReleaseConnections(t *testing.T) {
+	db, d := openCountingDB(t)
+	db.SetMaxOpenConns(1)
+	cfg := &databases.DatabaseCfg{QueryTimeout: 2 * time.Second}
+	q := NewBookRepo(BookRepoImpl{
+		Cfg: cfg,
+		Tx:  databases.NewTxManager(databases.TxManagerImpl{DB: db, Cfg: cfg}),
+	})
+
+	const calls = 50
+	ctx := context.Background()
+	errc := make(chan error, 2*calls)
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := q.GetBook(ctx, uuid.New()); err != nil {
+				errc <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			page, err := q.GetBooks(ctx, BookFilter{})
+			if err == nil && len(page.Books) != testBookRows {
+				err = errors.New("books not listed")
+			}
+			if err != nil {
+				errc <- err
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("reads blocked on the connection pool")
+	}
+	close(errc)
+	for err := range errc {
+		t.Errorf("read failed: %v", err)
+	}
+
+	opened, closed := atomic.LoadInt64(&d.opened), atomic.LoadInt64(&d.closed)
+	// GetBook runs one query, GetBooks a count and a select.
+	if want := int64(3 * calls); opened != want {
+		t.Errorf("rows opened = %d, want %d", opened, want)
+	}
+	if closed != opened {
+		t.Errorf("rows closed = %d, want every one of the %d opened", closed, opened)
+	}
+	if leaked := atomic.LoadInt64(&d.leaked); leaked != 0 {
+		t.Errorf("rows left open until their query context ended = %d, want 0", leaked)
+	}
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Errorf("connections in use = %d, want 0", inUse)
+	}
+}
+
+// TestExportReleasesConnectionOnDeadline stands for a client that stops
+// reading an export: its connection must be released once the export
+// context is done, even while fn is still stuck writing.
+func TestExportReleasesConnectionOnDeadline(t *testing.T) {
+	db, d := openCountingDB(t)
+	cfg := &databases.DatabaseCfg{QueryTimeout: 2 * time.Second}
+	q := NewBookRepo(BookRepoImpl{
+		Cfg: cfg,
+		Tx:  databases.NewTxManager(databases.TxManagerImpl{DB: db, Cfg: cfg}),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	stuck, unblock := make(chan struct{}), make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- q.ExportBooks(ctx, func(*Book) error {
+			close(stuck)
+			<-unblock
+			return nil
+		})
+	}()
+
+	<-stuck
+	<-ctx.Done()
+	deadline := time.Now().Add(2 * time.Second)
+	for db.Stats().InUse != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("connection held past the export deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(unblock)
+	if err := <-errc; err == nil {
+		t.Error("ExportBooks() = nil past its deadline, want an error")
+	}
+	if closed := atomic.LoadInt64(&d.closed); closed != 1 {
+		t.Errorf("rows closed = %d, want 1", closed)
+	}
+}
@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrVersionConflict is returned when a book changed since the version
+	// a write was based on.
+	ErrVersionConflict = errs.New(errs.ErrPrecondition, "book was modified concurrently")
+)
+
+// dbErr maps an error returned by database/sql to a domain error: an
+// expired or canceled context, a missing row, a constraint violation, or a
+// database that can't be reached.
+func dbErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	// Already mapped, e.g. by a query run within a transaction.
+	var de *errs.Error
+	if errors.As(err, &de) {
+		return err
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return errs.Wrap(errs.ErrTimeout, err, "query timed out")
+	case errors.Is(ctx.Err(), context.Canceled), errors.Is(err, context.Canceled):
+		return errs.Wrap(errs.ErrCanceled, err, "query canceled")
+	case errors.Is(err, sql.ErrNoRows):
+		return errs.Wrap(errs.ErrNotFound, err, "")
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return errs.Wrap(errs.ErrUnavailable, err, "database is unavailable")
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Name() {
+		case "unique_violation":
+			return errs.Wrap(errs.ErrConflict, err, "already exists")
+		case "foreign_key_violation":
+			return errs.Wrap(errs.ErrConflict, err, "references a missing or still referenced row")
+		case "not_null_violation", "check_violation", "string_data_right_truncation", "invalid_text_representation":
+			return errs.Wrap(errs.ErrValidation, err, "")
+		case "serialization_failure", "deadlock_detected":
+			return errs.Wrap(errs.ErrConflict, err, "concurrent update, retry")
+		case "query_canceled":
+			return errs.Wrap(errs.ErrTimeout, err, "query timed out")
+		}
+		switch pqErr.Code.Class() {
+		// connection_exception, insufficient_resources, operator_intervention
+		case "08", "53", "57":
+			return errs.Wrap(errs.ErrUnavailable, err, "database is unavailable")
+		}
+		return err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return errs.Wrap(errs.ErrUnavailable, err, "database is unavailable")
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package repo
+
+import "context"
+
+// ExportBooks method for handing every book that is not deleted to fn, oldest
+// first, as rows are read. It stops at the first error fn returns. The
+// export is not bounded by the query timeout since it lasts as long as fn
+// takes to consume the catalogue; ctx must bound it instead, its rows and
+// connection being released once ctx is done.
+func (q *BookRepoImpl) ExportBooks(ctx context.Context, fn func(*Book) error) error {
+	rows, err := psql.Select(bookColumns...).From("books").Where(notDeleted).
+		OrderBy("created_at", "id").
+		RunWith(q.db(ctx)).QueryContext(ctx)
+	if err != nil {
+		return dbErr(ctx, err)
+	}
+	defer rows.Close()
+
+	book := Book{}
+	dest := bookMap.Pointers(&book, bookColumns...)
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return dbErr(ctx, err)
+		}
+		if err := fn(&book); err != nil {
+			return err
+		}
+	}
+	return dbErr(ctx, rows.Err())
+}
@@ -0,0 +1,215 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/caohoangphuctd97/go-test/pkg/reqctx"
+	"github.com/google/uuid"
+	"go.uber.org/dig"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Event types, one per kind of change downstream services learn of.
+const (
+	EventBookCreated = "BookCreated"
+	EventBookUpdated = "BookUpdated"
+	EventBookDeleted = "BookDeleted"
+)
+
+// outboxLock is the key of the advisory lock held while claiming events, so
+// that a single instance publishes at a time and events keep their order.
+const outboxLock = 0x626f6f6b
+
+type (
+	// Event is a change made to a book, written to the outbox in the same
+	// transaction as the change.
+	Event struct {
+		// Seq orders the events of the outbox.
+		Seq       int64           `json:"-"`
+		ID        uuid.UUID       `json:"id"`
+		Type      string          `json:"type"`
+		BookID    uuid.UUID       `json:"book_id"`
+		Version   int             `json:"version"`
+		Actor     string          `json:"actor"`
+		RequestID string          `json:"request_id,omitempty"`
+		Payload   json.RawMessage `json:"payload"`
+		CreatedAt time.Time       `json:"created_at"`
+	}
+	// EventPayload is what an event tells of the change: the action that
+	// raised it, the fields it changed and the book it left.
+	EventPayload struct {
+		Action  string            `json:"action"`
+		Changes map[string]Change `json:"changes"`
+		Book    *Book             `json:"book"`
+	}
+	OutboxRepo interface {
+		// RelayEvents hands the oldest unpublished events, up to limit, to
+		// publish in order and marks those it succeeds with as published.
+		// Once publish fails for a book, its next events are held back so
+		// that they are never published out of order. The events are kept
+		// from other instances for lease, which must outlast publishing
+		// them. It returns how many events were published, 0 when another
+		// instance is relaying.
+		RelayEvents(ctx context.Context, limit int, lease time.Duration, publish func(context.Context, Event) error) (int, error)
+		// PurgeEvents removes the events published before given time.
+		PurgeEvents(ctx context.Context, before time.Time) (int64, error)
+	}
+	OutboxRepoImpl struct {
+		dig.In
+		Cfg *databases.DatabaseCfg `name:"pg"`
+		Tx  databases.TxManager
+	}
+)
+
+// eventColumns lists the outbox columns in the order they are scanned by
+// claimEvents.
+var eventColumns = []string{
+	"id", "event_id", "event_type", "book_id", "version", "actor", "request_id", "payload", "created_at",
+}
+
+func NewOutboxRepo(impl OutboxRepoImpl) OutboxRepo {
+	return &impl
+}
+
+// RelayEvents method for publishing the oldest unpublished events. The
+// events are claimed in a short transaction, published with none open,
+// then marked published in another: a slow sink holds neither a
+// connection nor the relay lock. The claim lasts for lease, so that
+// another instance takes over the events of one that crashed meanwhile,
+// publishing them again.
+func (q *OutboxRepoImpl) RelayEvents(ctx context.Context, limit int, lease time.Duration, publish func(context.Context, Event) error) (int, error) {
+	events, err := q.claimEvents(ctx, limit, lease)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	claimed := make([]int64, 0, len(events))
+	published := make([]int64, 0, len(events))
+	held := map[uuid.UUID]bool{}
+	for _, e := range events {
+		claimed = append(claimed, e.Seq)
+		if held[e.BookID] {
+			continue
+		}
+		if err := publish(ctx, e); err != nil {
+			held[e.BookID] = true
+			continue
+		}
+		published = append(published, e.Seq)
+	}
+
+	// Marked even once ctx is done, so that what was published is not
+	// published again.
+	ctx, cancel := queryTimeout(reqctx.Detach(ctx), q.Cfg)
+	defer cancel()
+	err = dbErr(ctx, q.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		tx := q.Tx.Querier(ctx)
+		if len(published) > 0 {
+			if _, err := psql.Update("outbox").Set("published_at", time.Now()).
+				Where(sq.Eq{"id": published}).
+				RunWith(tx).ExecContext(ctx); err != nil {
+				return err
+			}
+		}
+		_, err := psql.Update("outbox").Set("claimed_until", nil).
+			Where(sq.Eq{"id": claimed}).
+			RunWith(tx).ExecContext(ctx)
+		return err
+	}))
+	if err != nil {
+		return 0, err
+	}
+	return len(published), nil
+}
+
+// claimEvents claims the oldest unpublished events, up to limit, for
+// lease. Events are claimed by a single instance at a time, so that they
+// keep their order: none are claimed while a claim holds.
+func (q *OutboxRepoImpl) claimEvents(ctx context.Context, limit int, lease time.Duration) ([]Event, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	events := []Event{}
+	err := dbErr(ctx, q.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		tx := q.Tx.Querier(ctx)
+		events = events[:0]
+
+		locked := false
+		if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", outboxLock).Scan(&locked); err != nil || !locked {
+			return err
+		}
+		relaying := false
+		if err := psql.Select("EXISTS (SELECT 1 FROM outbox WHERE published_at IS NULL AND claimed_until > now())").
+			RunWith(tx).QueryRowContext(ctx).Scan(&relaying); err != nil || relaying {
+			return err
+		}
+
+		rows, err := psql.Update("outbox").
+			Set("claimed_until", sq.Expr("now() + ?::bigint * interval '1 millisecond'", lease.Milliseconds())).
+			Where(sq.Expr("id IN (SELECT id FROM outbox WHERE published_at IS NULL ORDER BY id LIMIT ?)", limit)).
+			Suffix("RETURNING " + strings.Join(eventColumns, ", ")).
+			RunWith(tx).QueryContext(ctx)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			e := Event{}
+			if err := rows.Scan(&e.Seq, &e.ID, &e.Type, &e.BookID, &e.Version,
+				&e.Actor, &e.RequestID, &e.Payload, &e.CreatedAt); err != nil {
+				return err
+			}
+			events = append(events, e)
+		}
+		return rows.Err()
+	}))
+
+	// RETURNING keeps no order.
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	return events, err
+}
+
+// PurgeEvents method for deleting the events published before given time.
+// It returns how many events were deleted.
+func (q *OutboxRepoImpl) PurgeEvents(ctx context.Context, before time.Time) (int64, error) {
+	res, err := psql.Delete("outbox").Where(sq.Lt{"published_at": before}).
+		RunWith(q.Tx.Querier(ctx)).ExecContext(ctx)
+	if err != nil {
+		return 0, dbErr(ctx, err)
+	}
+	return res.RowsAffected()
+}
+
+// recordEvents writes the events raised by given changes to the outbox
+// with a single statement.
+func recordEvents(ctx context.Context, tx databases.Querier, action string, changes ...bookChange) error {
+	eventType := EventBookUpdated
+	switch action {
+	case ActionCreate:
+		eventType = EventBookCreated
+	case ActionDelete:
+		eventType = EventBookDeleted
+	}
+
+	insert := psql.Insert("outbox").
+		Columns("event_id", "event_type", "book_id", "version", "actor", "request_id", "payload")
+	for _, c := range changes {
+		payload, err := json.Marshal(EventPayload{Action: action, Changes: diffBooks(c.before, c.after), Book: c.after})
+		if err != nil {
+			return err
+		}
+		insert = insert.Values(uuid.New(), eventType, c.after.ID, c.after.Version,
+			reqctx.Actor(ctx), reqctx.RequestID(ctx), string(payload))
+	}
+	if _, err := insert.RunWith(tx).ExecContext(ctx); err != nil {
+		return dbErr(ctx, err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package repo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/google/uuid"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	// DefaultPageLimit is used when the caller does not ask for a page size.
+	DefaultPageLimit = 20
+	// MaxPageLimit caps the page size a caller can ask for.
+	MaxPageLimit = 100
+)
+
+// BookSortColumns lists the columns books can be sorted on.
+var BookSortColumns = map[string]bool{
+	"title":      true,
+	"author":     true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+type (
+	// BookFilter describes which page of books to list.
+	BookFilter struct {
+		Limit  uint64
+		Offset uint64
+		// Cursor is an opaque value from a previous BookPage. When set it
+		// takes precedence over Offset, Sort and Desc.
+		Cursor string
+		Sort   string
+		Desc   bool
+
+		Author        string
+		TitleContains string
+		CreatedAfter  *time.Time
+		CreatedBefore *time.Time
+		// IncludeDeleted lists deleted books too.
+		IncludeDeleted bool
+	}
+	// BookPage is one page of a books listing.
+	BookPage struct {
+		Books      []Book
+		Total      int64
+		NextCursor string
+		PrevCursor string
+	}
+	// cursor is the decoded form of an opaque pagination cursor. It points
+	// at the row just outside the page, identified by its sort value and ID.
+	cursor struct {
+		Sort     string    `json:"s"`
+		Desc     bool      `json:"d,omitempty"`
+		Value    string    `json:"v"`
+		ID       uuid.UUID `json:"i"`
+		Backward bool      `json:"b,omitempty"`
+	}
+)
+
+// where returns the filter conditions shared by the page and total queries.
+func (f *BookFilter) where() sq.And {
+	where := sq.And{}
+	if !f.IncludeDeleted {
+		where = append(where, notDeleted)
+	}
+	if f.Author != "" {
+		where = append(where, sq.Eq{"author": f.Author})
+	}
+	if f.TitleContains != "" {
+		where = append(where, sq.ILike{"title": "%" + escapeLike(f.TitleContains) + "%"})
+	}
+	if f.CreatedAfter != nil {
+		where = append(where, sq.Gt{"created_at": *f.CreatedAfter})
+	}
+	if f.CreatedBefore != nil {
+		where = append(where, sq.Lt{"created_at": *f.CreatedBefore})
+	}
+	return where
+}
+
+// limit returns the page size bounded by DefaultPageLimit and MaxPageLimit.
+func (f *BookFilter) limit() uint64 {
+	switch {
+	case f.Limit == 0:
+		return DefaultPageLimit
+	case f.Limit > MaxPageLimit:
+		return MaxPageLimit
+	}
+	return f.Limit
+}
+
+// encodeCursor turns given cursor value into an opaque string.
+func encodeCursor(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor reads an opaque string made by encodeCursor into v.
+func decodeCursor(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return errs.New(errs.ErrValidation, "malformed cursor")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errs.New(errs.ErrValidation, "malformed cursor")
+	}
+	return nil
+}
+
+// cursorFor builds the cursor pointing at given book for a listing sorted
+// by sort.
+func cursorFor(b *Book, sort string, desc, backward bool) string {
+	c := cursor{Sort: sort, Desc: desc, ID: b.ID, Backward: backward}
+	switch sort {
+	case "title":
+		c.Value = b.Title
+	case "author":
+		c.Value = b.Author
+	case "created_at":
+		c.Value = b.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		c.Value = b.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	return encodeCursor(c)
+}
+
+// value returns the cursor sort value typed for its column.
+func (c *cursor) value() (interface{}, error) {
+	if c.Sort != "created_at" && c.Sort != "updated_at" {
+		return c.Value, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, c.Value)
+	if err != nil {
+		return nil, errs.New(errs.ErrValidation, "malformed cursor")
+	}
+	return t, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		if r == '\\' || r == '%' || r == '_' {
+			out = append(out, '\\')
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+)
+
+// newCountingRepo returns a book repository over a counting driver.
+func newCountingRepo(t *testing.T) (BookRepo, *countingDriver) {
+	t.Helper()
+	db, d := openCountingDB(t)
+	cfg := &databases.DatabaseCfg{QueryTimeout: 2 * time.Second}
+	return NewBookRepo(BookRepoImpl{
+		Cfg: cfg,
+		Tx:  databases.NewTxManager(databases.TxManagerImpl{DB: db, Cfg: cfg}),
+	}), d
+}
+
+// decodeTestCursor decodes a cursor returned by GetBooks.
+func decodeTestCursor(t *testing.T, s string) cursor {
+	t.Helper()
+	if s == "" {
+		t.Fatal("no cursor")
+	}
+	c := cursor{}
+	if err := decodeCursor(s, &c); err != nil {
+		t.Fatalf("decode cursor %q: %v", s, err)
+	}
+	return c
+}
+
+// checkSeek checks that the last query seeks past c, in given direction.
+func checkSeek(t *testing.T, d *countingDriver, c cursor, cmp, dir string) {
+	t.Helper()
+	query, args := d.last()
+	for _, want := range []string{
+		"(" + c.Sort + ", id) " + cmp + " ($1, $2)",
+		"ORDER BY " + c.Sort + " " + dir + ", id " + dir,
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query %q does not contain %q", query, want)
+		}
+	}
+	if len(args) != 2 {
+		t.Fatalf("query arguments = %v, want the cursor value and ID", args)
+	}
+	want, err := c.value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tv, ok := want.(time.Time); ok {
+		if got, _ := args[0].Value.(time.Time); !got.Equal(tv) {
+			t.Errorf("cursor value argument = %v, want %v", args[0].Value, tv)
+		}
+	} else if args[0].Value != want {
+		t.Errorf("cursor value argument = %v, want %v", args[0].Value, want)
+	}
+	if args[1].Value != c.ID.String() {
+		t.Errorf("cursor ID argument = %v, want %s", args[1].Value, c.ID)
+	}
+}
+
+func TestGetBooksCursorRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		sort string
+		desc bool
+		// cmp and dir are how the next page is sought and ordered.
+		cmp, dir string
+	}{
+		{"title", "title", false, ">", "ASC"},
+		{"title desc", "title", true, "<", "DESC"},
+		{"created_at", "created_at", false, ">", "ASC"},
+		{"updated_at desc", "updated_at", true, "<", "DESC"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			q, d := newCountingRepo(t)
+			ctx := context.Background()
+
+			// The driver lists one book more than the page holds.
+			first, err := q.GetBooks(ctx, BookFilter{Limit: testBookRows - 1, Sort: tt.sort, Desc: tt.desc})
+			if err != nil {
+				t.Fatalf("GetBooks: %v", err)
+			}
+			if len(first.Books) != testBookRows-1 || first.PrevCursor != "" {
+				t.Fatalf("first page has %d books and prev cursor %q, want %d and none",
+					len(first.Books), first.PrevCursor, testBookRows-1)
+			}
+			next := decodeTestCursor(t, first.NextCursor)
+			last := first.Books[len(first.Books)-1]
+			if next.Sort != tt.sort || next.Desc != tt.desc || next.Backward || next.ID != last.ID {
+				t.Errorf("next cursor = %+v, want forward after book %s sorted on %s", next, last.ID, tt.sort)
+			}
+
+			// The cursor takes precedence over the sort of the filter.
+			second, err := q.GetBooks(ctx, BookFilter{Limit: testBookRows - 1, Cursor: first.NextCursor, Sort: "author"})
+			if err != nil {
+				t.Fatalf("GetBooks after next cursor: %v", err)
+			}
+			checkSeek(t, d, next, tt.cmp, tt.dir)
+			prev := decodeTestCursor(t, second.PrevCursor)
+			if prev.Sort != tt.sort || prev.Desc != tt.desc || !prev.Backward || prev.ID != second.Books[0].ID {
+				t.Errorf("prev cursor = %+v, want backward before book %s sorted on %s", prev, second.Books[0].ID, tt.sort)
+			}
+
+			// Walking backward seeks and reads the other way.
+			if _, err := q.GetBooks(ctx, BookFilter{Limit: testBookRows - 1, Cursor: second.PrevCursor}); err != nil {
+				t.Fatalf("GetBooks after prev cursor: %v", err)
+			}
+			back := map[string]string{">": "<", "<": ">", "ASC": "DESC", "DESC": "ASC"}
+			checkSeek(t, d, prev, back[tt.cmp], back[tt.dir])
+		})
+	}
+}
+
+func TestGetBooksRejectsBadCursors(t *testing.T) {
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, tt := range []struct {
+		name   string
+		filter BookFilter
+	}{
+		{"not base64", BookFilter{Cursor: "not a cursor!"}},
+		{"not JSON", BookFilter{Cursor: raw("title,dune")}},
+		{"truncated", BookFilter{Cursor: encodeCursor(cursor{Sort: "title", Value: "Dune"})[:10]}},
+		{"search cursor", BookFilter{Cursor: encodeCursor(searchCursor{Query: "dune", Offset: 20})}},
+		{"tampered sort", BookFilter{Cursor: raw(`{"s":"title; DROP TABLE books","v":"Dune","i":"7b0a4c0e-9a8f-4d2a-8f4e-1b2c3d4e5f60"}`)}},
+		{"tampered sort column", BookFilter{Cursor: raw(`{"s":"deleted_at","v":"2024-01-01T00:00:00Z","i":"7b0a4c0e-9a8f-4d2a-8f4e-1b2c3d4e5f60"}`)}},
+		{"tampered time", BookFilter{Cursor: raw(`{"s":"created_at","v":"yesterday","i":"7b0a4c0e-9a8f-4d2a-8f4e-1b2c3d4e5f60"}`)}},
+		{"tampered ID", BookFilter{Cursor: raw(`{"s":"title","v":"Dune","i":"1 OR 1=1"}`)}},
+		{"unknown sort", BookFilter{Sort: "price"}},
+		{"sort on a hidden column", BookFilter{Sort: "deleted_at"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			q, d := newCountingRepo(t)
+			page, err := q.GetBooks(context.Background(), tt.filter)
+			if !errors.Is(err, errs.ErrValidation) {
+				t.Fatalf("GetBooks error = %v, want %v", err, errs.ErrValidation)
+			}
+			if len(page.Books) != 0 || page.NextCursor != "" {
+				t.Errorf("GetBooks listed %d books on error", len(page.Books))
+			}
+			// Rejected before a single row is read.
+			if opened := atomic.LoadInt64(&d.opened); opened != 0 {
+				t.Errorf("rows opened = %d, want 0", opened)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/reqctx"
+	"github.com/google/uuid"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Revision actions, one per kind of change made to a book.
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionRevert  = "revert"
+)
+
+type (
+	// Revision records one change made to a book: who made it, when, under
+	// which request, which fields it changed and the book it left.
+	Revision struct {
+		ID        int64             `json:"id"`
+		BookID    uuid.UUID         `json:"book_id"`
+		Version   int               `json:"version"`
+		Action    string            `json:"action"`
+		Actor     string            `json:"actor"`
+		RequestID string            `json:"request_id,omitempty"`
+		Changes   map[string]Change `json:"changes"`
+		Snapshot  Book              `json:"snapshot"`
+		CreatedAt time.Time         `json:"created_at"`
+	}
+	// Change is the value of a book field before and after a revision.
+	Change struct {
+		From interface{} `json:"from"`
+		To   interface{} `json:"to"`
+	}
+	// bookChange is a book before and after a write, before being nil for
+	// a book being created.
+	bookChange struct {
+		before, after *Book
+	}
+	// writeFunc changes the locked book before within tx, and returns it as
+	// it left it.
+	writeFunc func(ctx context.Context, tx databases.Querier, before *Book) (*Book, error)
+)
+
+// revisionColumns lists the book_revisions columns in the order they are
+// scanned by scanRevision.
+var revisionColumns = []string{
+	"id", "book_id", "version", "action", "actor", "request_id", "changes", "snapshot", "created_at",
+}
+
+// untrackedColumns are the book columns every write changes, left out of
+// revision changes.
+var untrackedColumns = map[string]bool{"id": true, "version": true, "created_at": true, "updated_at": true}
+
+// GetRevisions method for getting every revision of book by given ID,
+// latest first. It returns ErrNotFound when the book has none.
+func (q *BookRepoImpl) GetRevisions(ctx context.Context, id uuid.UUID) ([]Revision, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	// Define revisions variable.
+	revisions := []Revision{}
+
+	rows, err := psql.Select(revisionColumns...).From("book_revisions").
+		Where(sq.Eq{"book_id": id}).OrderBy("version DESC").
+		RunWith(q.db(ctx)).QueryContext(ctx)
+	if err != nil {
+		// Return empty object and error.
+		return revisions, dbErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return revisions, dbErr(ctx, err)
+		}
+		revisions = append(revisions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return revisions, dbErr(ctx, err)
+	}
+	if len(revisions) == 0 {
+		return revisions, errs.New(errs.ErrNotFound, "book has no history")
+	}
+
+	// Return query result.
+	return revisions, nil
+}
+
+// RevertBook method for setting the fields of book by given ID back to
+// what they were at given revision, provided the stored book is still at
+// given version. It returns ErrVersionConflict otherwise, and ErrNotFound
+// when the revision does not exist.
+func (q *BookRepoImpl) RevertBook(ctx context.Context, id uuid.UUID, version, revision int, updatedAt time.Time) (Book, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	// Define book variable.
+	book := Book{}
+
+	err := q.inTx(ctx, func(tx databases.Querier) error {
+		after, err := revise(ctx, tx, ActionRevert, id, func(ctx context.Context, tx databases.Querier, before *Book) (*Book, error) {
+			if err := checkVersion(before, version); err != nil {
+				return nil, err
+			}
+			r, err := scanRevision(psql.Select(revisionColumns...).From("book_revisions").
+				Where(sq.Eq{"book_id": id, "version": revision}).
+				RunWith(tx).QueryRowContext(ctx))
+			if err != nil {
+				return nil, dbErr(ctx, err)
+			}
+
+			set := map[string]interface{}{"updated_at": updatedAt}
+			for name := range PatchableBookFields {
+				set[name] = bookMap.Values(&r.Snapshot, name)[0]
+			}
+			return updateBook(ctx, tx, id, set)
+		})
+		if err == nil {
+			book = *after
+		}
+		return err
+	})
+
+	// Return query result.
+	return book, err
+}
+
+// revise locks the book by given ID, applies given write to it and records
+// the revision it makes, all within tx. It returns the book as write left
+// it.
+func revise(ctx context.Context, tx databases.Querier, action string, id uuid.UUID, write writeFunc) (*Book, error) {
+	before := &Book{}
+	if err := psql.Select(bookColumns...).From("books").Where(sq.Eq{"id": id}).
+		Suffix("FOR UPDATE").
+		RunWith(tx).QueryRowContext(ctx).
+		Scan(bookMap.Pointers(before, bookColumns...)...); err != nil {
+		return nil, dbErr(ctx, err)
+	}
+
+	after, err := write(ctx, tx, before)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordRevisions(ctx, tx, action, bookChange{before: before, after: after}); err != nil {
+		return nil, err
+	}
+	return after, nil
+}
+
+// recordRevisions inserts the revisions made by given changes with a single
+// statement, and writes the events they raise to the outbox.
+func recordRevisions(ctx context.Context, tx databases.Querier, action string, changes ...bookChange) error {
+	insert := psql.Insert("book_revisions").
+		Columns("book_id", "version", "action", "actor", "request_id", "changes", "snapshot")
+	for _, c := range changes {
+		diff, err := json.Marshal(diffBooks(c.before, c.after))
+		if err != nil {
+			return err
+		}
+		snapshot, err := json.Marshal(c.after)
+		if err != nil {
+			return err
+		}
+		insert = insert.Values(c.after.ID, c.after.Version, action,
+			reqctx.Actor(ctx), reqctx.RequestID(ctx), string(diff), string(snapshot))
+	}
+	if _, err := insert.RunWith(tx).ExecContext(ctx); err != nil {
+		return dbErr(ctx, err)
+	}
+	return recordEvents(ctx, tx, action, changes...)
+}
+
+// updateBook sets given columns of book by given ID, moves its version on
+// and reads it back.
+func updateBook(ctx context.Context, tx databases.Querier, id uuid.UUID, set map[string]interface{}) (*Book, error) {
+	set["version"] = sq.Expr("version + 1")
+
+	after := Book{}
+	if err := psql.Update("books").SetMap(set).Where(sq.Eq{"id": id}).
+		Suffix("RETURNING " + strings.Join(bookColumns, ", ")).
+		RunWith(tx).QueryRowContext(ctx).
+		Scan(bookMap.Pointers(&after, bookColumns...)...); err != nil {
+		return nil, dbErr(ctx, err)
+	}
+	return &after, nil
+}
+
+// checkVersion returns ErrVersionConflict when given book moved on from
+// given version, or was deleted since.
+func checkVersion(b *Book, version int) error {
+	if b.Version != version || b.DeletedAt != nil {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// diffBooks returns the tracked fields that differ between before and
+// after, by column name. A nil before stands for a book being created.
+func diffBooks(before, after *Book) map[string]Change {
+	changes := map[string]Change{}
+	for _, column := range bookColumns {
+		if untrackedColumns[column] {
+			continue
+		}
+		to := bookMap.Values(after, column)[0]
+		if before == nil {
+			changes[column] = Change{To: to}
+			continue
+		}
+		if from := bookMap.Values(before, column)[0]; !reflect.DeepEqual(from, to) {
+			changes[column] = Change{From: from, To: to}
+		}
+	}
+	return changes
+}
+
+// scanRevision reads a revision selected with revisionColumns.
+func scanRevision(row sq.RowScanner) (Revision, error) {
+	r := Revision{}
+	var changes, snapshot []byte
+	if err := row.Scan(
+		&r.ID,
+		&r.BookID,
+		&r.Version,
+		&r.Action,
+		&r.Actor,
+		&r.RequestID,
+		&changes,
+		&snapshot,
+		&r.CreatedAt,
+	); err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(changes, &r.Changes); err != nil {
+		return r, err
+	}
+	return r, json.Unmarshal(snapshot, &r.Snapshot)
+}
@@ -0,0 +1,139 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// headlineOptions marks matched terms in search highlights.
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, HighlightAll=true"
+
+type (
+	// BookSearch describes a full-text search over title and author.
+	BookSearch struct {
+		Query  string
+		Limit  uint64
+		Offset uint64
+		// Cursor is an opaque value from a previous SearchPage. When set it
+		// takes precedence over Offset.
+		Cursor string
+	}
+	// BookHit is a book matching a search, with its rank and highlights.
+	BookHit struct {
+		Book
+		Rank      float32       `json:"rank"`
+		Highlight BookHighlight `json:"highlight"`
+	}
+	// BookHighlight holds the book fields with matched terms marked.
+	BookHighlight struct {
+		Title  string `json:"title"`
+		Author string `json:"author"`
+	}
+	// SearchPage is one page of search results, best match first.
+	SearchPage struct {
+		Books      []BookHit
+		Total      int64
+		NextCursor string
+		PrevCursor string
+	}
+	// searchCursor is the decoded form of a search pagination cursor.
+	searchCursor struct {
+		Query  string `json:"q"`
+		Offset uint64 `json:"o"`
+	}
+)
+
+// SearchBooks method for searching books by title and author, ranked by
+// relevance. Every term of the query matches as a prefix. Deleted books are
+// never found.
+func (q *BookRepoImpl) SearchBooks(ctx context.Context, s BookSearch) (SearchPage, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	// Define page variable.
+	page := SearchPage{Books: []BookHit{}}
+
+	offset := s.Offset
+	if s.Cursor != "" {
+		c := searchCursor{}
+		if err := decodeCursor(s.Cursor, &c); err != nil {
+			return page, err
+		}
+		if c.Query != s.Query {
+			return page, errs.New(errs.ErrValidation, "cursor belongs to another query")
+		}
+		offset = c.Offset
+	}
+
+	tsquery := prefixQuery(s.Query)
+	if tsquery == "" {
+		return page, errs.New(errs.ErrValidation, "search query has no terms")
+	}
+	match := sq.And{sq.Expr("search_vector @@ query"), notDeleted}
+	join := sq.Expr("CROSS JOIN to_tsquery('simple', ?) AS query", tsquery)
+
+	if err := psql.Select("COUNT(*)").From("books").JoinClause(join).Where(match).
+		RunWith(q.db(ctx)).QueryRowContext(ctx).Scan(&page.Total); err != nil {
+		return page, dbErr(ctx, err)
+	}
+
+	limit := (&BookFilter{Limit: s.Limit}).limit()
+	rows, err := psql.Select(bookColumns...).
+		Column("ts_rank(search_vector, query) AS rank").
+		Column("ts_headline('simple', title, query, '"+headlineOptions+"')").
+		Column("ts_headline('simple', author, query, '"+headlineOptions+"')").
+		From("books").JoinClause(join).Where(match).
+		OrderBy("rank DESC", "id").
+		Limit(limit).Offset(offset).
+		RunWith(q.db(ctx)).QueryContext(ctx)
+	if err != nil {
+		// Return empty object and error.
+		return page, dbErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hit := BookHit{}
+		dest := append(bookMap.Pointers(&hit.Book, bookColumns...),
+			&hit.Rank, &hit.Highlight.Title, &hit.Highlight.Author)
+		if err = rows.Scan(dest...); err != nil {
+			return page, dbErr(ctx, err)
+		}
+		page.Books = append(page.Books, hit)
+	}
+	if err = rows.Err(); err != nil {
+		return page, dbErr(ctx, err)
+	}
+
+	if next := offset + limit; next < uint64(page.Total) {
+		page.NextCursor = encodeCursor(searchCursor{Query: s.Query, Offset: next})
+	}
+	if offset > 0 {
+		prev := uint64(0)
+		if offset > limit {
+			prev = offset - limit
+		}
+		page.PrevCursor = encodeCursor(searchCursor{Query: s.Query, Offset: prev})
+	}
+
+	// Return query result.
+	return page, nil
+}
+
+// prefixQuery turns free text into a tsquery matching every word as a
+// prefix, e.g. "tolk lord" becomes "tolk:* & lord:*". Anything but letters
+// and digits is dropped so user input can't inject tsquery operators.
+func prefixQuery(text string) string {
+	terms := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, t := range terms {
+		terms[i] = t + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
@@ -0,0 +1,105 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/caohoangphuctd97/go-test/pkg/rowmap"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.uber.org/dig"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+type (
+	// User is an account signing in with its email and password, granted
+	// Roles. PasswordHash is the bcrypt hash of the password.
+	User struct {
+		ID           uuid.UUID      `db:"id" json:"id"`
+		Email        string         `db:"email" json:"email" validate:"required,email,lte=255"`
+		PasswordHash string         `db:"password_hash" json:"-"`
+		Roles        pq.StringArray `db:"roles" json:"roles"`
+		CreatedAt    time.Time      `db:"created_at" json:"created_at"`
+		UpdatedAt    time.Time      `db:"updated_at" json:"updated_at"`
+	}
+	UserRepo interface {
+		GetUser(context.Context, uuid.UUID) (User, error)
+		GetUserByEmail(context.Context, string) (User, error)
+		CreateUser(context.Context, *User) error
+		UpdatePassword(context.Context, uuid.UUID, string, time.Time) error
+	}
+	UserRepoImpl struct {
+		dig.In
+		Cfg *databases.DatabaseCfg `name:"pg"`
+		Tx  databases.TxManager
+	}
+)
+
+// userMap maps users rows to User through its db tags, and userColumns
+// lists the columns a user is read from.
+var (
+	userMap     = rowmap.New(User{})
+	userColumns = userMap.Columns()
+)
+
+func NewUserRepo(impl UserRepoImpl) UserRepo {
+	return &impl
+}
+
+// GetUser method for getting one user by given ID.
+func (q *UserRepoImpl) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	return q.getUser(ctx, sq.Eq{"id": id})
+}
+
+// GetUserByEmail method for getting one user by given email, whatever its
+// case.
+func (q *UserRepoImpl) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	return q.getUser(ctx, sq.Expr("lower(email) = ?", strings.ToLower(email)))
+}
+
+// CreateUser method for creating user by given User object. A user with
+// the same email is a conflict.
+func (q *UserRepoImpl) CreateUser(ctx context.Context, u *User) error {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	_, err := psql.Insert("users").Columns(userColumns...).
+		Values(userMap.Values(u, userColumns...)...).
+		RunWith(q.Tx.Querier(ctx)).ExecContext(ctx)
+	return dbErr(ctx, err)
+}
+
+// UpdatePassword method for setting the password hash of user by given ID.
+func (q *UserRepoImpl) UpdatePassword(ctx context.Context, id uuid.UUID, hash string, at time.Time) error {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	res, err := psql.Update("users").SetMap(map[string]interface{}{
+		"password_hash": hash,
+		"updated_at":    at,
+	}).Where(sq.Eq{"id": id}).
+		RunWith(q.Tx.Querier(ctx)).ExecContext(ctx)
+	if err != nil {
+		return dbErr(ctx, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return dbErr(ctx, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// getUser reads the user matching where.
+func (q *UserRepoImpl) getUser(ctx context.Context, where sq.Sqlizer) (User, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	u := User{}
+	err := psql.Select(userColumns...).From("users").Where(where).
+		RunWith(q.Tx.Querier(ctx)).QueryRowContext(ctx).
+		Scan(userMap.Pointers(&u, userColumns...)...)
+	return u, dbErr(ctx, err)
+}
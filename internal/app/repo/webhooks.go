@@ -0,0 +1,361 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.uber.org/dig"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryRetrying  = "retrying"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type (
+	// Webhook is a partner subscription to book events, delivered to URL
+	// and signed with Secret. No Events means every event.
+	Webhook struct {
+		ID        uuid.UUID `json:"id"`
+		URL       string    `json:"url" validate:"required,url,lte=2048"`
+		Events    []string  `json:"events"`
+		Secret    string    `json:"secret,omitempty" validate:"lte=255"`
+		Active    bool      `json:"active"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	// Delivery is one event sent to a webhook, and how its attempts went.
+	Delivery struct {
+		ID             uuid.UUID       `json:"id"`
+		WebhookID      uuid.UUID       `json:"webhook_id"`
+		EventID        uuid.UUID       `json:"event_id"`
+		EventType      string          `json:"event_type"`
+		Payload        json.RawMessage `json:"payload"`
+		Status         string          `json:"status"`
+		Attempts       int             `json:"attempts"`
+		ResponseStatus int             `json:"response_status,omitempty"`
+		Error          string          `json:"error,omitempty"`
+		CreatedAt      time.Time       `json:"created_at"`
+		UpdatedAt      time.Time       `json:"updated_at"`
+		DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+		// QueuedAt is when a job was queued for the delivery, nil until
+		// then.
+		QueuedAt *time.Time `json:"queued_at,omitempty"`
+	}
+	WebhookRepo interface {
+		GetWebhooks(context.Context) ([]Webhook, error)
+		GetWebhook(context.Context, uuid.UUID) (Webhook, error)
+		CreateWebhook(context.Context, *Webhook) error
+		UpdateWebhook(context.Context, *Webhook) error
+		DeleteWebhook(context.Context, uuid.UUID) error
+		MatchWebhooks(context.Context, string) ([]Webhook, error)
+		CreateDeliveries(context.Context, ...*Delivery) ([]*Delivery, error)
+		GetDelivery(context.Context, uuid.UUID) (Delivery, error)
+		GetDeliveries(context.Context, uuid.UUID, uint64, uint64) ([]Delivery, error)
+		UpdateDelivery(context.Context, *Delivery) error
+		UnqueuedDeliveries(context.Context, time.Time, uint64) ([]Delivery, error)
+		MarkQueued(context.Context, ...uuid.UUID) error
+	}
+	WebhookRepoImpl struct {
+		dig.In
+		Cfg *databases.DatabaseCfg `name:"pg"`
+		Tx  databases.TxManager
+	}
+)
+
+// webhookColumns and deliveryColumns list the columns in the order they
+// are scanned by scanWebhook and scanDelivery.
+var (
+	webhookColumns = []string{
+		"id", "url", "events", "secret", "active", "created_at", "updated_at",
+	}
+	deliveryColumns = []string{
+		"id", "webhook_id", "event_id", "event_type", "payload", "status", "attempts",
+		"response_status", "error", "created_at", "updated_at", "delivered_at", "queued_at",
+	}
+)
+
+func NewWebhookRepo(impl WebhookRepoImpl) WebhookRepo {
+	return &impl
+}
+
+// GetWebhooks method for getting every webhook, oldest first.
+func (q *WebhookRepoImpl) GetWebhooks(ctx context.Context) ([]Webhook, error) {
+	return q.selectWebhooks(ctx, nil)
+}
+
+// MatchWebhooks method for getting the active webhooks subscribed to given
+// event type.
+func (q *WebhookRepoImpl) MatchWebhooks(ctx context.Context, eventType string) ([]Webhook, error) {
+	return q.selectWebhooks(ctx, sq.And{
+		sq.Eq{"active": true},
+		sq.Expr("(cardinality(events) = 0 OR ? = ANY(events))", eventType),
+	})
+}
+
+// GetWebhook method for getting one webhook by given ID.
+func (q *WebhookRepoImpl) GetWebhook(ctx context.Context, id uuid.UUID) (Webhook, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	w, err := scanWebhook(psql.Select(webhookColumns...).From("webhooks").Where(sq.Eq{"id": id}).
+		RunWith(q.Tx.Querier(ctx)).QueryRowContext(ctx))
+	return w, dbErr(ctx, err)
+}
+
+// CreateWebhook method for creating webhook by given Webhook object.
+func (q *WebhookRepoImpl) CreateWebhook(ctx context.Context, w *Webhook) error {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	_, err := psql.Insert("webhooks").Columns(webhookColumns...).
+		Values(w.ID, w.URL, pq.Array(w.Events), w.Secret, w.Active, w.CreatedAt, w.UpdatedAt).
+		RunWith(q.Tx.Querier(ctx)).ExecContext(ctx)
+	return dbErr(ctx, err)
+}
+
+// UpdateWebhook method for updating webhook by given Webhook object. w is
+// left holding the stored webhook.
+func (q *WebhookRepoImpl) UpdateWebhook(ctx context.Context, w *Webhook) error {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	updated, err := scanWebhook(psql.Update("webhooks").SetMap(map[string]interface{}{
+		"url":        w.URL,
+		"events":     pq.Array(w.Events),
+		"secret":     w.Secret,
+		"active":     w.Active,
+		"updated_at": w.UpdatedAt,
+	}).Where(sq.Eq{"id": w.ID}).
+		Suffix("RETURNING " + strings.Join(webhookColumns, ", ")).
+		RunWith(q.Tx.Querier(ctx)).QueryRowContext(ctx))
+	if err != nil {
+		return dbErr(ctx, err)
+	}
+	*w = updated
+	return nil
+}
+
+// DeleteWebhook method for deleting webhook by given ID, with its
+// deliveries.
+func (q *WebhookRepoImpl) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	res, err := psql.Delete("webhooks").Where(sq.Eq{"id": id}).
+		RunWith(q.Tx.Querier(ctx)).ExecContext(ctx)
+	if err != nil {
+		return dbErr(ctx, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return dbErr(ctx, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// CreateDeliveries method for creating given deliveries, MaxBatchSize per
+// statement. Deliveries whose ID exists already are skipped, so that
+// recording the deliveries of an event again is harmless. It returns the
+// deliveries created.
+func (q *WebhookRepoImpl) CreateDeliveries(ctx context.Context, deliveries ...*Delivery) ([]*Delivery, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	created := make([]*Delivery, 0, len(deliveries))
+	for len(deliveries) > 0 {
+		n := len(deliveries)
+		if n > MaxBatchSize {
+			n = MaxBatchSize
+		}
+		insert := psql.Insert("webhook_deliveries").Columns(deliveryColumns...)
+		byID := make(map[uuid.UUID]*Delivery, n)
+		for _, d := range deliveries[:n] {
+			insert = insert.Values(d.ID, d.WebhookID, d.EventID, d.EventType, string(d.Payload), d.Status,
+				d.Attempts, d.ResponseStatus, d.Error, d.CreatedAt, d.UpdatedAt, d.DeliveredAt, d.QueuedAt)
+			byID[d.ID] = d
+		}
+		ids, err := q.insertIDs(ctx, insert.Suffix("ON CONFLICT (id) DO NOTHING RETURNING id"))
+		if err != nil {
+			return created, err
+		}
+		for _, id := range ids {
+			created = append(created, byID[id])
+		}
+		deliveries = deliveries[n:]
+	}
+	return created, nil
+}
+
+// insertIDs runs given insert, returning the IDs of the rows inserted.
+func (q *WebhookRepoImpl) insertIDs(ctx context.Context, insert sq.InsertBuilder) ([]uuid.UUID, error) {
+	rows, err := insert.RunWith(q.Tx.Querier(ctx)).QueryContext(ctx)
+	if err != nil {
+		return nil, dbErr(ctx, err)
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return ids, dbErr(ctx, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, dbErr(ctx, rows.Err())
+}
+
+// GetDelivery method for getting one delivery by given ID.
+func (q *WebhookRepoImpl) GetDelivery(ctx context.Context, id uuid.UUID) (Delivery, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	d, err := scanDelivery(psql.Select(deliveryColumns...).From("webhook_deliveries").Where(sq.Eq{"id": id}).
+		RunWith(q.Tx.Querier(ctx)).QueryRowContext(ctx))
+	return d, dbErr(ctx, err)
+}
+
+// GetDeliveries method for getting one page of the deliveries of webhook by
+// given ID, latest first.
+func (q *WebhookRepoImpl) GetDeliveries(ctx context.Context, webhookID uuid.UUID, limit, offset uint64) ([]Delivery, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	// Define deliveries variable.
+	deliveries := []Delivery{}
+
+	rows, err := psql.Select(deliveryColumns...).From("webhook_deliveries").
+		Where(sq.Eq{"webhook_id": webhookID}).OrderBy("created_at DESC", "id").
+		Limit(limit).Offset(offset).
+		RunWith(q.Tx.Querier(ctx)).QueryContext(ctx)
+	if err != nil {
+		return deliveries, dbErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return deliveries, dbErr(ctx, err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, dbErr(ctx, rows.Err())
+}
+
+// UpdateDelivery method for recording how the last attempt of given
+// delivery went.
+func (q *WebhookRepoImpl) UpdateDelivery(ctx context.Context, d *Delivery) error {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	_, err := psql.Update("webhook_deliveries").SetMap(map[string]interface{}{
+		"status":          d.Status,
+		"attempts":        d.Attempts,
+		"response_status": d.ResponseStatus,
+		"error":           d.Error,
+		"updated_at":      d.UpdatedAt,
+		"delivered_at":    d.DeliveredAt,
+	}).Where(sq.Eq{"id": d.ID}).
+		RunWith(q.Tx.Querier(ctx)).ExecContext(ctx)
+	return dbErr(ctx, err)
+}
+
+// UnqueuedDeliveries method for getting the pending deliveries created
+// before given time that were never queued, oldest first, up to limit.
+func (q *WebhookRepoImpl) UnqueuedDeliveries(ctx context.Context, before time.Time, limit uint64) ([]Delivery, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	// Define deliveries variable.
+	deliveries := []Delivery{}
+
+	rows, err := psql.Select(deliveryColumns...).From("webhook_deliveries").
+		Where(sq.And{
+			sq.Eq{"queued_at": nil, "status": DeliveryPending},
+			sq.Lt{"created_at": before},
+		}).OrderBy("created_at", "id").Limit(limit).
+		RunWith(q.Tx.Querier(ctx)).QueryContext(ctx)
+	if err != nil {
+		return deliveries, dbErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return deliveries, dbErr(ctx, err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, dbErr(ctx, rows.Err())
+}
+
+// MarkQueued method for recording that a job was queued for each delivery
+// by given ID.
+func (q *WebhookRepoImpl) MarkQueued(ctx context.Context, ids ...uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	_, err := psql.Update("webhook_deliveries").Set("queued_at", sq.Expr("now()")).
+		Where(sq.Eq{"id": ids}).
+		RunWith(q.Tx.Querier(ctx)).ExecContext(ctx)
+	return dbErr(ctx, err)
+}
+
+// selectWebhooks reads the webhooks matching where, oldest first.
+func (q *WebhookRepoImpl) selectWebhooks(ctx context.Context, where sq.Sqlizer) ([]Webhook, error) {
+	ctx, cancel := queryTimeout(ctx, q.Cfg)
+	defer cancel()
+
+	// Define webhooks variable.
+	webhooks := []Webhook{}
+
+	query := psql.Select(webhookColumns...).From("webhooks").OrderBy("created_at", "id")
+	if where != nil {
+		query = query.Where(where)
+	}
+	rows, err := query.RunWith(q.Tx.Querier(ctx)).QueryContext(ctx)
+	if err != nil {
+		return webhooks, dbErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return webhooks, dbErr(ctx, err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, dbErr(ctx, rows.Err())
+}
+
+// scanWebhook reads a webhook selected with webhookColumns.
+func scanWebhook(row sq.RowScanner) (Webhook, error) {
+	w := Webhook{Events: []string{}}
+	err := row.Scan(&w.ID, &w.URL, pq.Array(&w.Events), &w.Secret, &w.Active, &w.CreatedAt, &w.UpdatedAt)
+	return w, err
+}
+
+// scanDelivery reads a delivery selected with deliveryColumns.
+func scanDelivery(row sq.RowScanner) (Delivery, error) {
+	d := Delivery{}
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+		&d.ResponseStatus, &d.Error, &d.CreatedAt, &d.UpdatedAt, &d.DeliveredAt, &d.QueuedAt)
+	return d, err
+}
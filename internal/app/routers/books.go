@@ -1,14 +1,30 @@
 package routes
 
 import (
+	"github.com/caohoangphuctd97/go-test/internal/app/auth"
+	"github.com/caohoangphuctd97/go-test/internal/app/cache"
 	"github.com/caohoangphuctd97/go-test/internal/app/controllers"
+	"github.com/caohoangphuctd97/go-test/internal/app/events"
+	"github.com/caohoangphuctd97/go-test/internal/app/jobs"
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/dig"
 )
 
 type BookCntrlImpl struct {
 	dig.In
-	Svc controllers.BookSvc
+	Svc   controllers.BookSvc
+	Cache cache.BookCache
+	Jobs  jobs.Queue
+	// Webhooks manages the webhook subscriptions.
+	Webhooks controllers.WebhookSvc
+	// APIKeys manages the keys of machine clients.
+	APIKeys controllers.APIKeySvc
+	// Users signs users up and in.
+	Users controllers.UserSvc
+	// Stream pushes book changes to clients.
+	Stream events.Stream
+	// Auth identifies callers and authorizes them per route.
+	Auth auth.Authenticator
 }
 
 type BookRoutes interface {
@@ -21,19 +37,57 @@ func NewBookCntrl(impl BookCntrlImpl) BookRoutes {
 
 // BookRoutes func for describe group of public routes.
 func (c *BookCntrlImpl) SetRoute(a *fiber.App) {
-	// Create routes group.
-	route := a.Group("/api/v1")
+	// Create routes group, each route requiring its permission in bookPolicy.
+	route := c.Auth.Guard(a.Group("/api/v1", c.Auth.Authenticate), bookPolicy)
 
 	// Routes for GET method:
-	route.Get("/books", c.Svc.GetBooks)   // get list of all books
-	route.Get("/book/:id", c.Svc.GetBook) // get one book by ID
+	route.Get("/books", c.Cache.Collection(), c.Svc.GetBooks)           // get list of all books
+	route.Get("/books/search", c.Cache.Collection(), c.Svc.SearchBooks) // search books by title and author
+	route.Get("/books/export", c.Svc.ExportBooks)                       // export all books as CSV or JSON Lines
+	route.Get("/books/stream", c.Stream.SSEHandler)                     // stream book changes as Server-Sent Events
+	route.Get("/books/ws", c.Stream.WebSocketHandler)                   // stream book changes over a WebSocket
+	route.Get("/book/:id", c.Cache.Item("id"), c.Svc.GetBook)           // get one book by ID
+	route.Get("/book/:id/history", c.Svc.GetHistory)                    // get change history of one book by ID
+	route.Get("/cache/stats", c.Cache.StatsHandler)                     // get response cache counters
+	route.Get("/jobs/:id", c.Jobs.StatusHandler)                        // get status of one background job by ID
+	route.Get("/jobs/:id/download", c.Jobs.DownloadHandler)             // download the output of one background job by ID
 
 	// Routes for POST method:
-	route.Post("/book", c.Svc.CreateBook) // create a new book
+	route.Post("/book", c.Svc.CreateBook)                             // create a new book
+	route.Post("/books\\:batch", c.Svc.BatchBooks)                    // create, update and delete books in one go
+	route.Post("/books/import", c.Svc.ImportBooks)                    // import books from CSV or JSON Lines
+	route.Post("/books/export", c.Svc.QueueExport)                    // export all books in the background
+	route.Post("/book/:id/restore", c.Svc.RestoreBook)                // restore one deleted book by ID
+	route.Post("/book/:id/history/:version/revert", c.Svc.RevertBook) // revert one book by ID to a revision
+
+	// Routes for PUT method:
+	route.Put("/book/:id", c.Svc.UpdateBook) // replace one book by ID
 
 	// Routes for PATCH method:
-	route.Patch("/book/:id", c.Svc.UpdateBook) // update one book by ID
+	route.Patch("/book/:id", c.Svc.PatchBook) // update some fields of one book by ID
 
 	// Routes for DELETE method:
 	route.Delete("/book/:id", c.Svc.DeleteBook) // delete one book by ID
+
+	// Routes for webhook subscriptions:
+	route.Get("/webhooks", c.Webhooks.GetWebhooks)                                   // get list of all webhooks
+	route.Get("/webhooks/:id", c.Webhooks.GetWebhook)                                // get one webhook by ID
+	route.Get("/webhooks/:id/deliveries", c.Webhooks.GetDeliveries)                  // get delivery log of one webhook by ID
+	route.Post("/webhooks", c.Webhooks.CreateWebhook)                                // create a new webhook
+	route.Post("/webhooks/:id/deliveries/:delivery/redeliver", c.Webhooks.Redeliver) // send a delivery again
+	route.Put("/webhooks/:id", c.Webhooks.UpdateWebhook)                             // replace one webhook by ID
+	route.Delete("/webhooks/:id", c.Webhooks.DeleteWebhook)                          // delete one webhook by ID
+
+	// Routes for API keys of machine clients:
+	route.Get("/admin/api-keys", c.APIKeys.GetAPIKeys)          // get list of all API keys
+	route.Post("/admin/api-keys", c.APIKeys.CreateAPIKey)       // issue a new API key
+	route.Delete("/admin/api-keys/:id", c.APIKeys.DeleteAPIKey) // revoke one API key by ID
+
+	// Routes for user accounts:
+	route.Post("/auth/signup", c.Users.Signup)                  // create a new account
+	route.Post("/auth/login", c.Users.Login)                    // sign in and get tokens
+	route.Post("/auth/refresh", c.Users.Refresh)                // exchange a refresh token for new tokens
+	route.Post("/auth/logout", c.Users.Logout)                  // revoke a session
+	route.Post("/auth/password/forgot", c.Users.ForgotPassword) // mail a password reset token
+	route.Post("/auth/password/reset", c.Users.ResetPassword)   // set a new password with a reset token
 }
@@ -0,0 +1,53 @@
+package routes
+
+import "github.com/caohoangphuctd97/go-test/internal/app/auth"
+
+// bookPolicy lists the permission each route of the API requires, relative
+// to /api/v1. SetRoute panics on a route missing from it.
+var bookPolicy = auth.Policy{
+	// Books.
+	"GET /books":                             auth.ScopeBooksRead,
+	"GET /books/search":                      auth.ScopeBooksRead,
+	"GET /books/export":                      auth.ScopeBooksRead,
+	"GET /books/stream":                      auth.ScopeBooksRead,
+	"GET /books/ws":                          auth.ScopeBooksRead,
+	"GET /book/:id":                          auth.ScopeBooksRead,
+	"GET /book/:id/history":                  auth.ScopeBooksRead,
+	"POST /book":                             auth.ScopeBooksWrite,
+	"POST /books\\:batch":                    auth.ScopeBooksWrite,
+	"POST /books/import":                     auth.ScopeBooksWrite,
+	"POST /books/export":                     auth.ScopeBooksRead,
+	"POST /book/:id/restore":                 auth.ScopeBooksWrite,
+	"POST /book/:id/history/:version/revert": auth.ScopeBooksWrite,
+	"PUT /book/:id":                          auth.ScopeBooksWrite,
+	"PATCH /book/:id":                        auth.ScopeBooksWrite,
+	"DELETE /book/:id":                       auth.ScopeBooksWrite,
+
+	// Service.
+	"GET /cache/stats": auth.ScopeAdmin,
+	// Jobs are only served to who queued them, or to an admin.
+	"GET /jobs/:id":          auth.ScopeBooksRead,
+	"GET /jobs/:id/download": auth.ScopeBooksRead,
+
+	// Webhook subscriptions.
+	"GET /webhooks":                auth.ScopeAdmin,
+	"GET /webhooks/:id":            auth.ScopeAdmin,
+	"GET /webhooks/:id/deliveries": auth.ScopeAdmin,
+	"POST /webhooks":               auth.ScopeAdmin,
+	"POST /webhooks/:id/deliveries/:delivery/redeliver": auth.ScopeAdmin,
+	"PUT /webhooks/:id":    auth.ScopeAdmin,
+	"DELETE /webhooks/:id": auth.ScopeAdmin,
+
+	// API keys of machine clients.
+	"GET /admin/api-keys":        auth.ScopeAdmin,
+	"POST /admin/api-keys":       auth.ScopeAdmin,
+	"DELETE /admin/api-keys/:id": auth.ScopeAdmin,
+
+	// User accounts.
+	"POST /auth/signup":          auth.Public,
+	"POST /auth/login":           auth.Public,
+	"POST /auth/refresh":         auth.Public,
+	"POST /auth/logout":          auth.Public,
+	"POST /auth/password/forgot": auth.Public,
+	"POST /auth/password/reset":  auth.Public,
+}
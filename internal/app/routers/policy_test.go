@@ -0,0 +1,295 @@
+package routes
+
+import (
+	"context"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/internal/app/auth"
+	"github.com/caohoangphuctd97/go-test/internal/app/cache"
+	"github.com/caohoangphuctd97/go-test/internal/app/controllers"
+	"github.com/caohoangphuctd97/go-test/internal/app/events"
+	"github.com/caohoangphuctd97/go-test/internal/app/jobs"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+const (
+	testSecret = "test-secret"
+	// testAPIKey is the key of a machine client granted books:write only.
+	testAPIKey = "bk_test"
+)
+
+// ok answers every route the caller is let through.
+func ok(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+
+// Stubs of the controllers, answering ok on every route.
+type (
+	stubBooks    struct{ controllers.BookSvc }
+	stubCache    struct{ cache.BookCache }
+	stubJobs     struct{ jobs.Queue }
+	stubWebhooks struct{ controllers.WebhookSvc }
+	stubAPIKeys  struct{ controllers.APIKeySvc }
+	stubUsers    struct{ controllers.UserSvc }
+	stubStream   struct{ events.Stream }
+
+	// stubKeys knows testAPIKey only.
+	stubKeys struct{ auth.APIKeys }
+	// stubRevocations revokes no token.
+	stubRevocations struct{ auth.Revocations }
+)
+
+func (stubBooks) GetBooks(c *fiber.Ctx) error    { return ok(c) }
+func (stubBooks) GetBook(c *fiber.Ctx) error     { return ok(c) }
+func (stubBooks) UpdateBook(c *fiber.Ctx) error  { return ok(c) }
+func (stubBooks) PatchBook(c *fiber.Ctx) error   { return ok(c) }
+func (stubBooks) CreateBook(c *fiber.Ctx) error  { return ok(c) }
+func (stubBooks) DeleteBook(c *fiber.Ctx) error  { return ok(c) }
+func (stubBooks) RestoreBook(c *fiber.Ctx) error { return ok(c) }
+func (stubBooks) GetHistory(c *fiber.Ctx) error  { return ok(c) }
+func (stubBooks) RevertBook(c *fiber.Ctx) error  { return ok(c) }
+func (stubBooks) BatchBooks(c *fiber.Ctx) error  { return ok(c) }
+func (stubBooks) ExportBooks(c *fiber.Ctx) error { return ok(c) }
+func (stubBooks) QueueExport(c *fiber.Ctx) error { return ok(c) }
+func (stubBooks) ImportBooks(c *fiber.Ctx) error { return ok(c) }
+func (stubBooks) SearchBooks(c *fiber.Ctx) error { return ok(c) }
+
+func (stubCache) Collection() fiber.Handler            { return func(c *fiber.Ctx) error { return c.Next() } }
+func (stubCache) Item(string) fiber.Handler            { return func(c *fiber.Ctx) error { return c.Next() } }
+func (stubCache) StatsHandler(c *fiber.Ctx) error      { return ok(c) }
+func (stubJobs) StatusHandler(c *fiber.Ctx) error      { return ok(c) }
+func (stubJobs) DownloadHandler(c *fiber.Ctx) error    { return ok(c) }
+func (stubStream) SSEHandler(c *fiber.Ctx) error       { return ok(c) }
+func (stubStream) WebSocketHandler(c *fiber.Ctx) error { return ok(c) }
+
+func (stubWebhooks) GetWebhooks(c *fiber.Ctx) error   { return ok(c) }
+func (stubWebhooks) GetWebhook(c *fiber.Ctx) error    { return ok(c) }
+func (stubWebhooks) CreateWebhook(c *fiber.Ctx) error { return ok(c) }
+func (stubWebhooks) UpdateWebhook(c *fiber.Ctx) error { return ok(c) }
+func (stubWebhooks) DeleteWebhook(c *fiber.Ctx) error { return ok(c) }
+func (stubWebhooks) GetDeliveries(c *fiber.Ctx) error { return ok(c) }
+func (stubWebhooks) Redeliver(c *fiber.Ctx) error     { return ok(c) }
+
+func (stubAPIKeys) GetAPIKeys(c *fiber.Ctx) error   { return ok(c) }
+func (stubAPIKeys) CreateAPIKey(c *fiber.Ctx) error { return ok(c) }
+func (stubAPIKeys) DeleteAPIKey(c *fiber.Ctx) error { return ok(c) }
+
+func (stubUsers) Signup(c *fiber.Ctx) error         { return ok(c) }
+func (stubUsers) Login(c *fiber.Ctx) error          { return ok(c) }
+func (stubUsers) Refresh(c *fiber.Ctx) error        { return ok(c) }
+func (stubUsers) Logout(c *fiber.Ctx) error         { return ok(c) }
+func (stubUsers) ForgotPassword(c *fiber.Ctx) error { return ok(c) }
+func (stubUsers) ResetPassword(c *fiber.Ctx) error  { return ok(c) }
+
+func (stubKeys) Verify(_ context.Context, key string) (repo.APIKey, error) {
+	if key != testAPIKey {
+		return repo.APIKey{}, errs.New(errs.ErrUnauthorized, "invalid API key")
+	}
+	return repo.APIKey{ID: uuid.New(), Prefix: "test", Scopes: []string{auth.ScopeBooksWrite}}, nil
+}
+
+func (stubRevocations) Revoked(context.Context, *auth.Claims) (bool, error) { return false, nil }
+
+// newTestApp returns the app of the API routes, the controllers stubbed.
+func newTestApp(t *testing.T) *fiber.App {
+	t.Helper()
+	t.Setenv("JWT_SECRET", testSecret)
+	t.Setenv("AUTH_ANONYMOUS_SCOPES", auth.ScopeBooksRead)
+
+	app := fiber.New(fiber.Config{ErrorHandler: configs.ErrorHandler})
+	NewBookCntrl(BookCntrlImpl{
+		Svc:      stubBooks{},
+		Cache:    stubCache{},
+		Jobs:     stubJobs{},
+		Webhooks: stubWebhooks{},
+		APIKeys:  stubAPIKeys{},
+		Users:    stubUsers{},
+		Stream:   stubStream{},
+		Auth:     auth.NewAuthenticator(auth.AuthenticatorImpl{APIKeys: stubKeys{}, Revocations: stubRevocations{}}),
+	}).SetRoute(app)
+	return app
+}
+
+// bearer returns the Authorization header of a token of given roles.
+func bearer(t *testing.T, roles ...string) string {
+	t.Helper()
+	claims := auth.Claims{Roles: roles}
+	claims.Subject = "user-" + strings.Join(roles, "-")
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.SchemeBearer + " " + token
+}
+
+// routeParam matches the params of a route path.
+var routeParam = regexp.MustCompile(`:[a-z]+`)
+
+// requestPath returns a path matching given route of bookPolicy.
+func requestPath(route string) string {
+	path := strings.ReplaceAll(route, `\:`, ":")
+	if route != path {
+		return "/api/v1" + path
+	}
+	return "/api/v1" + routeParam.ReplaceAllString(path, uuid.NewString())
+}
+
+// wantPolicy is the permission each route is expected to require, kept
+// apart from bookPolicy so that a route opened by mistake fails the tests.
+var wantPolicy = map[string]string{
+	"GET /books":                             auth.ScopeBooksRead,
+	"GET /books/search":                      auth.ScopeBooksRead,
+	"GET /books/export":                      auth.ScopeBooksRead,
+	"GET /books/stream":                      auth.ScopeBooksRead,
+	"GET /books/ws":                          auth.ScopeBooksRead,
+	"GET /book/:id":                          auth.ScopeBooksRead,
+	"GET /book/:id/history":                  auth.ScopeBooksRead,
+	"POST /book":                             auth.ScopeBooksWrite,
+	"POST /books\\:batch":                    auth.ScopeBooksWrite,
+	"POST /books/import":                     auth.ScopeBooksWrite,
+	"POST /books/export":                     auth.ScopeBooksRead,
+	"POST /book/:id/restore":                 auth.ScopeBooksWrite,
+	"POST /book/:id/history/:version/revert": auth.ScopeBooksWrite,
+	"PUT /book/:id":                          auth.ScopeBooksWrite,
+	"PATCH /book/:id":                        auth.ScopeBooksWrite,
+	"DELETE /book/:id":                       auth.ScopeBooksWrite,
+
+	"GET /cache/stats":       auth.ScopeAdmin,
+	"GET /jobs/:id":          auth.ScopeBooksRead,
+	"GET /jobs/:id/download": auth.ScopeBooksRead,
+
+	"GET /webhooks":                auth.ScopeAdmin,
+	"GET /webhooks/:id":            auth.ScopeAdmin,
+	"GET /webhooks/:id/deliveries": auth.ScopeAdmin,
+	"POST /webhooks":               auth.ScopeAdmin,
+	"POST /webhooks/:id/deliveries/:delivery/redeliver": auth.ScopeAdmin,
+	"PUT /webhooks/:id":    auth.ScopeAdmin,
+	"DELETE /webhooks/:id": auth.ScopeAdmin,
+
+	"GET /admin/api-keys":        auth.ScopeAdmin,
+	"POST /admin/api-keys":       auth.ScopeAdmin,
+	"DELETE /admin/api-keys/:id": auth.ScopeAdmin,
+
+	"POST /auth/signup":          auth.Public,
+	"POST /auth/login":           auth.Public,
+	"POST /auth/refresh":         auth.Public,
+	"POST /auth/logout":          auth.Public,
+	"POST /auth/password/forgot": auth.Public,
+	"POST /auth/password/reset":  auth.Public,
+}
+
+func TestBookPolicyRoutes(t *testing.T) {
+	for route := range bookPolicy {
+		if _, ok := wantPolicy[route]; !ok {
+			t.Errorf("route %s of bookPolicy is not tested", route)
+		}
+	}
+	for route := range wantPolicy {
+		if _, ok := bookPolicy[route]; !ok {
+			t.Errorf("route %s is missing from bookPolicy", route)
+		}
+	}
+}
+
+// TestBookPolicy requests every route as each kind of caller, checking that
+// the route is registered and lets through exactly the callers granted the
+// permission it is expected to require.
+func TestBookPolicy(t *testing.T) {
+	app := newTestApp(t)
+	const (
+		allowed      = fiber.StatusOK
+		unauthorized = fiber.StatusUnauthorized
+		forbidden    = fiber.StatusForbidden
+	)
+	for _, caller := range []struct {
+		name          string
+		authorization string
+		// want is the status of the routes of each permission.
+		want map[string]int
+	}{
+		{"anonymous", "", map[string]int{
+			auth.Public:          allowed,
+			auth.ScopeBooksRead:  allowed,
+			auth.ScopeBooksWrite: unauthorized,
+			auth.ScopeAdmin:      unauthorized,
+		}},
+		{"reader", bearer(t, auth.RoleReader), map[string]int{
+			auth.Public:          allowed,
+			auth.ScopeBooksRead:  allowed,
+			auth.ScopeBooksWrite: forbidden,
+			auth.ScopeAdmin:      forbidden,
+		}},
+		{"editor", bearer(t, auth.RoleEditor), map[string]int{
+			auth.Public:          allowed,
+			auth.ScopeBooksRead:  allowed,
+			auth.ScopeBooksWrite: allowed,
+			auth.ScopeAdmin:      forbidden,
+		}},
+		{"admin", bearer(t, auth.RoleAdmin), map[string]int{
+			auth.Public:          allowed,
+			auth.ScopeBooksRead:  allowed,
+			auth.ScopeBooksWrite: allowed,
+			auth.ScopeAdmin:      allowed,
+		}},
+		{"API key", auth.SchemeAPIKey + " " + testAPIKey, map[string]int{
+			auth.Public:          allowed,
+			auth.ScopeBooksRead:  allowed,
+			auth.ScopeBooksWrite: allowed,
+			auth.ScopeAdmin:      forbidden,
+		}},
+	} {
+		t.Run(caller.name, func(t *testing.T) {
+			for route, perm := range wantPolicy {
+				method, path, _ := strings.Cut(route, " ")
+				methods := []string{method}
+				if method == fiber.MethodGet {
+					methods = append(methods, fiber.MethodHead)
+				}
+				for _, method := range methods {
+					req := httptest.NewRequest(method, requestPath(path), nil)
+					if caller.authorization != "" {
+						req.Header.Set(fiber.HeaderAuthorization, caller.authorization)
+					}
+					resp, err := app.Test(req, -1)
+					if err != nil {
+						t.Fatalf("%s %s: %v", method, path, err)
+					}
+					resp.Body.Close()
+					if want := caller.want[perm]; resp.StatusCode != want {
+						t.Errorf("%s %s = %d, want %d", method, path, resp.StatusCode, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestBookPolicyRefusesInvalidCredentials checks that bad credentials are
+// refused even on the routes open to anonymous callers.
+func TestBookPolicyRefusesInvalidCredentials(t *testing.T) {
+	app := newTestApp(t)
+	for _, authorization := range []string{
+		auth.SchemeBearer + " not-a-token",
+		auth.SchemeAPIKey + " bk_unknown",
+		"Basic dXNlcjpwYXNz",
+	} {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/v1/books", nil)
+		req.Header.Set(fiber.HeaderAuthorization, authorization)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("GET /books with %q = %d, want %d", authorization, resp.StatusCode, fiber.StatusUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/rs/zerolog/log"
+)
+
+// Mail senders, as set in MAIL_SENDER.
+const (
+	MailSenderStdout = "stdout"
+	MailSenderFile   = "file"
+)
+
+type (
+	// Message is a plain text email.
+	Message struct {
+		To      string
+		Subject string
+		Body    string
+	}
+	// Mailer sends emails. The stand-ins provided write them to stdout or
+	// to a file; providing another Mailer sends them for real.
+	Mailer interface {
+		Send(ctx context.Context, m Message) error
+	}
+	// MailCfg configures the mail stand-ins.
+	MailCfg struct {
+		Sender string `env:"MAIL_SENDER" envDefault:"stdout"`
+		From   string `env:"MAIL_FROM" envDefault:"book_app@localhost"`
+		// File is where the file stand-in appends emails.
+		File string `env:"MAIL_FILE" envDefault:"mail.log"`
+	}
+
+	// WriterMailer writes emails to a writer.
+	WriterMailer struct {
+		mu   sync.Mutex
+		w    io.Writer
+		from string
+	}
+	// FileMailer appends emails to a file.
+	FileMailer struct {
+		mu   sync.Mutex
+		path string
+		from string
+	}
+)
+
+// NewMailer returns the mail stand-in set in MAIL_SENDER.
+func NewMailer() Mailer {
+	cfg := MailCfg{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Error().Err(err).Msg("mail: config")
+	}
+	switch cfg.Sender {
+	case MailSenderFile:
+		return NewFileMailer(cfg.File, cfg.From)
+	case MailSenderStdout:
+	default:
+		log.Error().Str("sender", cfg.Sender).Msg("mail: unknown sender, writing to stdout")
+	}
+	return NewWriterMailer(os.Stdout, cfg.From)
+}
+
+// NewWriterMailer returns a Mailer writing emails from given address to w.
+func NewWriterMailer(w io.Writer, from string) *WriterMailer {
+	return &WriterMailer{w: w, from: from}
+}
+
+func (m *WriterMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := io.WriteString(m.w, format(m.from, msg))
+	return err
+}
+
+// NewFileMailer returns a Mailer appending emails from given address to
+// the file at path.
+func NewFileMailer(path, from string) *FileMailer {
+	return &FileMailer{path: path, from: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, format(m.from, msg)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// format returns msg as the headers and body of an email, followed by a
+// blank line.
+func format(from string, msg Message) string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(msg.Body)
+	b.WriteString("\r\n\r\n")
+	return b.String()
+}
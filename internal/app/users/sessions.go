@@ -0,0 +1,163 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// KeyPrefix namespaces the sessions and password reset tokens in Redis.
+const KeyPrefix = "book_app:users:"
+
+var (
+	// errUnknownToken reports a refresh or reset token that is not, or no
+	// longer, stored.
+	errUnknownToken = errors.New("unknown token")
+	// errTokenReused reports a refresh token sent again after being
+	// exchanged: the session it belongs to is revoked.
+	errTokenReused = errors.New("refresh token reused")
+)
+
+type (
+	// sessions stores the refresh tokens of the users in Redis.
+	//
+	// Each login opens a session, a family of refresh tokens where each
+	// token is exchanged once for the next. Tokens are kept, marked used,
+	// until they expire, so that a token exchanged twice, stolen and used
+	// by two parties, revokes the whole session.
+	sessions struct {
+		client redis.UniversalClient
+		ttl    time.Duration
+	}
+	// session is a refresh token as stored.
+	session struct {
+		UserID uuid.UUID `json:"user_id"`
+		Family string    `json:"family"`
+	}
+)
+
+// open opens a session of user by given ID, and returns its first refresh
+// token.
+func (s *sessions) open(ctx context.Context, userID uuid.UUID) (string, error) {
+	family := randomToken(16)
+	if err := s.client.Set(ctx, familyKey(family), userID.String(), s.ttl).Err(); err != nil {
+		return "", err
+	}
+	userKey := userFamiliesKey(userID)
+	if err := s.client.SAdd(ctx, userKey, family).Err(); err != nil {
+		return "", err
+	}
+	s.client.Expire(ctx, userKey, s.ttl)
+	return s.issue(ctx, session{UserID: userID, Family: family})
+}
+
+// rotate exchanges refresh token for the next of its session, and returns
+// the session and the new token.
+func (s *sessions) rotate(ctx context.Context, token string) (session, string, error) {
+	sess, err := s.get(ctx, token)
+	if err != nil {
+		return sess, "", err
+	}
+	first, err := s.client.SetNX(ctx, usedKey(token), 1, s.ttl).Result()
+	if err != nil {
+		return sess, "", err
+	}
+	if !first {
+		return sess, "", s.revokeFamily(ctx, sess.Family, errTokenReused)
+	}
+	alive, err := s.client.Expire(ctx, familyKey(sess.Family), s.ttl).Result()
+	if err != nil {
+		return sess, "", err
+	}
+	if !alive {
+		return sess, "", errUnknownToken
+	}
+	next, err := s.issue(ctx, sess)
+	return sess, next, err
+}
+
+// close revokes the session of given refresh token.
+func (s *sessions) close(ctx context.Context, token string) error {
+	sess, err := s.get(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.revokeFamily(ctx, sess.Family, nil)
+}
+
+// closeAll revokes every session of user by given ID.
+func (s *sessions) closeAll(ctx context.Context, userID uuid.UUID) error {
+	userKey := userFamiliesKey(userID)
+	families, err := s.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		if err := s.client.Del(ctx, familyKey(family)).Err(); err != nil {
+			return err
+		}
+	}
+	return s.client.Del(ctx, userKey).Err()
+}
+
+// issue stores a new refresh token of given session, and returns it.
+func (s *sessions) issue(ctx context.Context, sess session) (string, error) {
+	token := randomToken(32)
+	b, err := json.Marshal(sess)
+	if err != nil {
+		return "", err
+	}
+	return token, s.client.Set(ctx, tokenKey(token), b, s.ttl).Err()
+}
+
+// get returns the session of given refresh token.
+func (s *sessions) get(ctx context.Context, token string) (session, error) {
+	sess := session{}
+	b, err := s.client.Get(ctx, tokenKey(token)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return sess, errUnknownToken
+	}
+	if err != nil {
+		return sess, err
+	}
+	return sess, json.Unmarshal(b, &sess)
+}
+
+// revokeFamily revokes the session family, and returns cause.
+func (s *sessions) revokeFamily(ctx context.Context, family string, cause error) error {
+	if err := s.client.Del(ctx, familyKey(family)).Err(); err != nil {
+		return err
+	}
+	return cause
+}
+
+// Keys of sessions, tokens stored by hash.
+func tokenKey(token string) string { return KeyPrefix + "refresh:" + hashToken(token) }
+
+func usedKey(token string) string { return KeyPrefix + "refresh:used:" + hashToken(token) }
+
+func familyKey(family string) string { return KeyPrefix + "session:" + family }
+
+func userFamiliesKey(userID uuid.UUID) string {
+	return KeyPrefix + "user:" + userID.String() + ":sessions"
+}
+
+// randomToken returns n random bytes, hex encoded.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// hashToken returns the hex SHA-256 of token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
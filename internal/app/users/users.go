@@ -0,0 +1,325 @@
+// Package users manages the accounts of the people using the service: who
+// they are, how they sign in, and the tokens they get.
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/caohoangphuctd97/go-test/internal/app/auth"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/caohoangphuctd97/go-test/pkg/utils"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/dig"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bounds of passwords. bcrypt ignores bytes past the 72nd.
+const (
+	MinPasswordLength = 8
+	MaxPasswordBytes  = 72
+)
+
+type (
+	// Service signs users up and in, and issues their tokens: short lived
+	// access JWTs, and refresh tokens exchanged once each for new tokens.
+	Service interface {
+		// Signup creates the account of given email and password.
+		Signup(ctx context.Context, email, password string) (repo.User, error)
+		// Login opens a session of the user of given email and password.
+		Login(ctx context.Context, email, password string) (Tokens, error)
+		// Refresh exchanges a refresh token for new tokens. A token
+		// exchanged twice revokes its session.
+		Refresh(ctx context.Context, refreshToken string) (Tokens, error)
+		// Logout revokes the session of given refresh token, and the
+		// access token of given claims if any.
+		Logout(ctx context.Context, refreshToken string, access *auth.Claims) error
+		// RequestPasswordReset mails a password reset token to the user of
+		// given email, if any.
+		RequestPasswordReset(ctx context.Context, email string) error
+		// ResetPassword sets the password of the user of given reset token,
+		// and revokes every session of the user.
+		ResetPassword(ctx context.Context, token, password string) error
+	}
+	ServiceImpl struct {
+		dig.In
+		Repo        repo.UserRepo
+		Redis       *configs.RedisStorage
+		Mailer      Mailer
+		Revocations auth.Revocations
+	}
+	// Cfg configures accounts and tokens.
+	Cfg struct {
+		AccessTTL  time.Duration `env:"ACCESS_TOKEN_TTL" envDefault:"15m"`
+		RefreshTTL time.Duration `env:"REFRESH_TOKEN_TTL" envDefault:"720h"`
+		ResetTTL   time.Duration `env:"PASSWORD_RESET_TTL" envDefault:"1h"`
+		// ResetURL is the page resetting passwords, the token appended.
+		ResetURL   string `env:"PASSWORD_RESET_URL" envDefault:"http://localhost:5000/reset-password?token="`
+		BcryptCost int    `env:"BCRYPT_COST" envDefault:"12"`
+		// DefaultRoles lists the roles of new users.
+		DefaultRoles []string `env:"USER_DEFAULT_ROLES" envDefault:"reader" envSeparator:","`
+	}
+	// Tokens are the tokens issued on login and refresh.
+	Tokens struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	service struct {
+		ServiceImpl
+		cfg      Cfg
+		authCfg  auth.Cfg
+		sessions *sessions
+		// dummyHash is compared with the passwords of unknown emails, so
+		// that they take as long to refuse as wrong passwords.
+		dummyHash []byte
+	}
+)
+
+func NewService(impl ServiceImpl) Service {
+	cfg := Cfg{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Error().Err(err).Msg("users: config")
+	}
+	authCfg := auth.Cfg{}
+	if err := env.Parse(&authCfg); err != nil {
+		log.Error().Err(err).Msg("users: auth config")
+	}
+	if _, _, err := auth.SigningKey(&authCfg); err != nil {
+		log.Error().Err(err).Msg("users: no key to sign tokens, logins fail")
+	}
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte(randomToken(16)), cfg.BcryptCost)
+	if err != nil {
+		log.Error().Err(err).Msg("users: BCRYPT_COST")
+	}
+	return &service{
+		ServiceImpl: impl,
+		cfg:         cfg,
+		authCfg:     authCfg,
+		sessions:    &sessions{client: impl.Redis.Client(), ttl: cfg.RefreshTTL},
+		dummyHash:   dummyHash,
+	}
+}
+
+func (s *service) Signup(ctx context.Context, email, password string) (repo.User, error) {
+	now := time.Now()
+	u := repo.User{
+		ID:        uuid.New(),
+		Email:     strings.TrimSpace(email),
+		Roles:     s.cfg.DefaultRoles,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if u.Roles == nil {
+		u.Roles = []string{}
+	}
+
+	// Validate user fields.
+	if err := utils.NewValidator().Struct(&u); err != nil {
+		return u, utils.ValidationError(err)
+	}
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return u, err
+	}
+	u.PasswordHash = hash
+
+	// Create user by given model.
+	err = s.Repo.CreateUser(ctx, &u)
+	if errors.Is(err, errs.ErrConflict) {
+		return u, errs.Wrap(errs.ErrConflict, err, "an account with this email already exists")
+	}
+	return u, err
+}
+
+func (s *service) Login(ctx context.Context, email, password string) (Tokens, error) {
+	u, err := s.Repo.GetUserByEmail(ctx, strings.TrimSpace(email))
+	if errors.Is(err, errs.ErrNotFound) {
+		bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return Tokens{}, errs.New(errs.ErrUnauthorized, "invalid email or password")
+	}
+	if err != nil {
+		return Tokens{}, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
+		return Tokens{}, errs.New(errs.ErrUnauthorized, "invalid email or password")
+	}
+
+	refresh, err := s.sessions.open(ctx, u.ID)
+	if err != nil {
+		return Tokens{}, redisErr(err)
+	}
+	return s.tokens(&u, refresh)
+}
+
+func (s *service) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	sess, refresh, err := s.sessions.rotate(ctx, refreshToken)
+	switch {
+	case errors.Is(err, errTokenReused):
+		log.Warn().Str("user", sess.UserID.String()).Str("session", sess.Family).
+			Msg("users: refresh token reused, session revoked")
+		return Tokens{}, errs.New(errs.ErrUnauthorized, "invalid refresh token")
+	case errors.Is(err, errUnknownToken):
+		return Tokens{}, errs.New(errs.ErrUnauthorized, "invalid refresh token")
+	case err != nil:
+		return Tokens{}, redisErr(err)
+	}
+
+	// Get user, whose roles may have changed since login.
+	u, err := s.Repo.GetUser(ctx, sess.UserID)
+	if errors.Is(err, errs.ErrNotFound) {
+		return Tokens{}, errs.New(errs.ErrUnauthorized, "invalid refresh token")
+	}
+	if err != nil {
+		return Tokens{}, err
+	}
+	return s.tokens(&u, refresh)
+}
+
+func (s *service) Logout(ctx context.Context, refreshToken string, access *auth.Claims) error {
+	if access != nil {
+		if err := s.Revocations.Revoke(ctx, access); err != nil {
+			return redisErr(err)
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+	err := s.sessions.close(ctx, refreshToken)
+	if errors.Is(err, errUnknownToken) {
+		return errs.New(errs.ErrUnauthorized, "invalid refresh token")
+	}
+	if err != nil {
+		return redisErr(err)
+	}
+	return nil
+}
+
+func (s *service) RequestPasswordReset(ctx context.Context, email string) error {
+	u, err := s.Repo.GetUserByEmail(ctx, strings.TrimSpace(email))
+	if errors.Is(err, errs.ErrNotFound) {
+		// Not told, so that accounts can't be probed.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token := randomToken(32)
+	if err := s.sessions.client.Set(ctx, resetKey(token), u.ID.String(), s.cfg.ResetTTL).Err(); err != nil {
+		return redisErr(err)
+	}
+	return s.Mailer.Send(ctx, Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. To choose a new one, open\r\n\r\n"+
+			"%s%s\r\n\r\nwithin %s. If it wasn't you, ignore this email.", s.cfg.ResetURL, token, s.cfg.ResetTTL),
+	})
+}
+
+func (s *service) ResetPassword(ctx context.Context, token, password string) error {
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	// Consume the token, usable once.
+	id, err := s.sessions.client.GetDel(ctx, resetKey(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return errs.Invalid(errs.FieldError{
+			Name:    "Token",
+			Pointer: "/token",
+			Detail:  "token is invalid or expired",
+		})
+	}
+	if err != nil {
+		return redisErr(err)
+	}
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Repo.UpdatePassword(ctx, userID, hash, time.Now()); err != nil {
+		return err
+	}
+	if err := s.sessions.closeAll(ctx, userID); err != nil {
+		return redisErr(err)
+	}
+	return nil
+}
+
+// tokens returns the tokens of user u: a new access token, and given
+// refresh token.
+func (s *service) tokens(u *repo.User, refresh string) (Tokens, error) {
+	method, key, err := auth.SigningKey(&s.authCfg)
+	if err != nil {
+		return Tokens{}, err
+	}
+	now := time.Now()
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   u.ID.String(),
+			Issuer:    s.authCfg.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.AccessTTL)),
+		},
+		Roles: u.Roles,
+	}
+	if s.authCfg.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{s.authCfg.Audience}
+	}
+	access, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return Tokens{
+		AccessToken:  access,
+		TokenType:    auth.SchemeBearer,
+		ExpiresIn:    int(s.cfg.AccessTTL / time.Second),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// hashPassword checks password and returns its bcrypt hash.
+func (s *service) hashPassword(password string) (string, error) {
+	switch {
+	case utf8.RuneCountInString(password) < MinPasswordLength:
+		return "", errs.Invalid(errs.FieldError{
+			Name:    "Password",
+			Pointer: "/password",
+			Detail:  fmt.Sprintf("password must be at least %d characters long", MinPasswordLength),
+		})
+	case len(password) > MaxPasswordBytes:
+		return "", errs.Invalid(errs.FieldError{
+			Name:    "Password",
+			Pointer: "/password",
+			Detail:  fmt.Sprintf("password must not exceed %d bytes", MaxPasswordBytes),
+		})
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cfg.BcryptCost)
+	return string(hash), err
+}
+
+// resetKey returns the key of given password reset token, stored by hash.
+func resetKey(token string) string {
+	return KeyPrefix + "reset:" + hashToken(token)
+}
+
+// redisErr reports a Redis failure as the service being unavailable.
+func redisErr(err error) error {
+	return errs.Wrap(errs.ErrUnavailable, err, "sessions are unavailable")
+}
@@ -0,0 +1,312 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/caohoangphuctd97/go-test/internal/app/jobs"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/dig"
+)
+
+// Headers of a delivery.
+const (
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+)
+
+// jobDeliver is the type of the jobs delivering an event to a webhook.
+const jobDeliver = "webhooks.deliver"
+
+// sweepLimit is how many unqueued deliveries a sweep queues at most.
+const sweepLimit = 500
+
+type (
+	// Notifier delivers book events to the webhooks subscribed to them. It
+	// is a sink of the outbox relay, so that no committed change goes
+	// unnotified.
+	Notifier interface {
+		// Publish records a delivery of event e to every webhook subscribed
+		// to it, then queues them. Publishing an event again records no
+		// new delivery. Deliveries failing to be queued are left to Sweep.
+		Publish(ctx context.Context, e repo.Event) error
+		// Redeliver queues a new delivery of the event of given delivery, to
+		// the same webhook.
+		Redeliver(ctx context.Context, d *repo.Delivery) (*repo.Delivery, error)
+		// Sweep queues the pending deliveries recorded more than
+		// SweepInterval ago and never queued. It returns how many were
+		// queued.
+		Sweep(ctx context.Context) (int, error)
+		// Run sweeps once per SweepInterval until ctx is done.
+		Run(ctx context.Context)
+	}
+	NotifierImpl struct {
+		dig.In
+		Repo repo.WebhookRepo
+		Jobs jobs.Queue
+	}
+	// Cfg configures the deliveries.
+	Cfg struct {
+		// Timeout bounds a delivery attempt.
+		Timeout time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+		// MaxAttempts is how many times a delivery is tried, with the
+		// backoff of the job queue between attempts.
+		MaxAttempts int `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"8"`
+		// SweepInterval is how often deliveries never queued are, once
+		// recorded that long ago. Sweeping is disabled when not positive.
+		SweepInterval time.Duration `env:"WEBHOOK_SWEEP_INTERVAL" envDefault:"1m"`
+	}
+	// Payload is the body of a delivery.
+	Payload struct {
+		ID        uuid.UUID   `json:"id"`
+		Type      string      `json:"type"`
+		CreatedAt time.Time   `json:"created_at"`
+		Data      PayloadData `json:"data"`
+	}
+	PayloadData struct {
+		Book *repo.Book `json:"book"`
+	}
+	// deliverJob is the payload of a delivery job.
+	deliverJob struct {
+		DeliveryID uuid.UUID `json:"delivery_id"`
+	}
+	notifier struct {
+		NotifierImpl
+		cfg    Cfg
+		client *http.Client
+	}
+)
+
+func NewNotifier(impl NotifierImpl) Notifier {
+	cfg := Cfg{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Error().Err(err).Msg("webhooks: config")
+	}
+	n := &notifier{NotifierImpl: impl, cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+	n.Jobs.Register(jobDeliver, n.deliver)
+	return n
+}
+
+// Sign returns the signature of a delivery of body sent at given time:
+// the hex HMAC-SHA256, keyed with secret, of the Unix time, a dot and
+// body. It is sent as "t=<time>,v1=<signature>" in HeaderSignature.
+func Sign(secret string, t time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(t.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *notifier) Publish(ctx context.Context, e repo.Event) error {
+	webhooks, err := n.Repo.MatchWebhooks(ctx, e.Type)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	p := repo.EventPayload{}
+	if err := json.Unmarshal(e.Payload, &p); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(Payload{
+		ID:        e.ID,
+		Type:      e.Type,
+		CreatedAt: e.CreatedAt,
+		Data:      PayloadData{Book: p.Book},
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]*repo.Delivery, 0, len(webhooks))
+	for _, w := range webhooks {
+		d := newDelivery(w.ID, e.ID, e.Type, payload, now)
+		// Derived from the event, so that an event published again is
+		// not delivered again.
+		d.ID = uuid.NewSHA1(e.ID, w.ID[:])
+		deliveries = append(deliveries, d)
+	}
+	created, err := n.Repo.CreateDeliveries(ctx, deliveries...)
+	if err != nil {
+		return err
+	}
+	if err := n.queue(ctx, created...); err != nil {
+		// Recorded already: Sweep queues them.
+		log.Error().Err(err).Str("event", e.ID.String()).Msg("webhooks: queue")
+	}
+	return nil
+}
+
+func (n *notifier) Redeliver(ctx context.Context, d *repo.Delivery) (*repo.Delivery, error) {
+	redelivery := newDelivery(d.WebhookID, d.EventID, d.EventType, d.Payload, time.Now())
+	if _, err := n.Repo.CreateDeliveries(ctx, redelivery); err != nil {
+		return nil, err
+	}
+	return redelivery, n.queue(ctx, redelivery)
+}
+
+func (n *notifier) Sweep(ctx context.Context) (int, error) {
+	deliveries, err := n.Repo.UnqueuedDeliveries(ctx, time.Now().Add(-n.cfg.SweepInterval), sweepLimit)
+	if err != nil {
+		return 0, err
+	}
+	queued := make([]*repo.Delivery, len(deliveries))
+	for i := range deliveries {
+		queued[i] = &deliveries[i]
+	}
+	err = n.queue(ctx, queued...)
+	return len(deliveries), err
+}
+
+func (n *notifier) Run(ctx context.Context) {
+	if n.cfg.SweepInterval <= 0 {
+		log.Info().Msg("webhooks: sweeping disabled")
+		return
+	}
+	ticker := time.NewTicker(n.cfg.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.sweep(ctx)
+		}
+	}
+}
+
+// sweep runs a sweep, logging the outcome.
+func (n *notifier) sweep(ctx context.Context) {
+	count, err := n.Sweep(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("webhooks: sweep")
+		return
+	}
+	if count > 0 {
+		log.Info().Int("count", count).Msg("webhooks: queued unqueued deliveries")
+	}
+}
+
+// queue queues a job for each of given recorded deliveries, and records
+// that those are queued. Deliveries left unqueued on error are swept.
+func (n *notifier) queue(ctx context.Context, deliveries ...*repo.Delivery) error {
+	queued := make([]uuid.UUID, 0, len(deliveries))
+	var err error
+	for _, d := range deliveries {
+		if _, err = n.Jobs.Enqueue(ctx, jobDeliver, deliverJob{DeliveryID: d.ID}, jobs.MaxAttempts(n.cfg.MaxAttempts)); err != nil {
+			break
+		}
+		queued = append(queued, d.ID)
+	}
+	if merr := n.Repo.MarkQueued(ctx, queued...); merr != nil && err == nil {
+		err = merr
+	}
+	return err
+}
+
+// deliver runs a delivery job: it posts the event to the webhook and
+// records how it went, failing so that the job is retried.
+func (n *notifier) deliver(ctx context.Context, job *jobs.Job) (interface{}, error) {
+	req := deliverJob{}
+	if err := json.Unmarshal(job.Payload, &req); err != nil {
+		return nil, err
+	}
+	d, err := n.Repo.GetDelivery(ctx, req.DeliveryID)
+	if errors.Is(err, errs.ErrNotFound) {
+		// Deleted with its webhook.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	w, err := n.Repo.GetWebhook(ctx, d.WebhookID)
+	if errors.Is(err, errs.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d.UpdatedAt = time.Now()
+	if !w.Active {
+		// Not retried until the webhook is active again and redelivered.
+		d.Status = repo.DeliveryFailed
+		d.Error = "webhook is inactive"
+		return deliverJob{DeliveryID: d.ID}, n.Repo.UpdateDelivery(ctx, &d)
+	}
+
+	d.Attempts++
+	d.ResponseStatus, err = n.post(ctx, &w, &d)
+	switch {
+	case err == nil:
+		d.Status = repo.DeliverySucceeded
+		d.Error = ""
+		d.DeliveredAt = &d.UpdatedAt
+	case job.Attempts < job.MaxAttempts:
+		d.Status = repo.DeliveryRetrying
+		d.Error = err.Error()
+	default:
+		d.Status = repo.DeliveryFailed
+		d.Error = err.Error()
+	}
+	if uerr := n.Repo.UpdateDelivery(ctx, &d); uerr != nil {
+		log.Error().Err(uerr).Str("delivery", d.ID.String()).Msg("webhooks: record attempt")
+	}
+	return deliverJob{DeliveryID: d.ID}, err
+}
+
+// post sends delivery d to webhook w, and returns the status of the
+// response.
+func (n *notifier) post(ctx context.Context, w *repo.Webhook, d *repo.Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "book_app-webhooks")
+	req.Header.Set(HeaderDelivery, d.ID.String())
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderSignature, fmt.Sprintf("t=%d,v1=%s", now.Unix(), Sign(w.Secret, now, d.Payload)))
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// newDelivery returns a pending delivery of given event to webhook by given
+// ID.
+func newDelivery(webhookID, eventID uuid.UUID, eventType string, payload []byte, now time.Time) *repo.Delivery {
+	return &repo.Delivery{
+		ID:        uuid.New(),
+		WebhookID: webhookID,
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   payload,
+		Status:    repo.DeliveryPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
@@ -0,0 +1,164 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/internal/app/jobs"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/google/uuid"
+)
+
+// stubRepo keeps deliveries in memory, skipping those recorded already as
+// the database does.
+type stubRepo struct {
+	repo.WebhookRepo
+	mu         sync.Mutex
+	webhooks   []repo.Webhook
+	deliveries map[uuid.UUID]*repo.Delivery
+}
+
+func (r *stubRepo) MatchWebhooks(context.Context, string) ([]repo.Webhook, error) {
+	return r.webhooks, nil
+}
+
+func (r *stubRepo) CreateDeliveries(_ context.Context, deliveries ...*repo.Delivery) ([]*repo.Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	created := []*repo.Delivery{}
+	for _, d := range deliveries {
+		if _, ok := r.deliveries[d.ID]; ok {
+			continue
+		}
+		stored := *d
+		r.deliveries[d.ID] = &stored
+		created = append(created, d)
+	}
+	return created, nil
+}
+
+func (r *stubRepo) UnqueuedDeliveries(_ context.Context, before time.Time, limit uint64) ([]repo.Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deliveries := []repo.Delivery{}
+	for _, d := range r.deliveries {
+		if d.QueuedAt == nil && d.CreatedAt.Before(before) && uint64(len(deliveries)) < limit {
+			deliveries = append(deliveries, *d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (r *stubRepo) MarkQueued(_ context.Context, ids ...uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, id := range ids {
+		r.deliveries[id].QueuedAt = &now
+	}
+	return nil
+}
+
+// unqueued returns how many deliveries were never queued.
+func (r *stubRepo) unqueued() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, d := range r.deliveries {
+		if d.QueuedAt == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// stubQueue records the deliveries queued, failing once limit is reached.
+type stubQueue struct {
+	jobs.Queue
+	limit  int
+	queued []uuid.UUID
+}
+
+func (q *stubQueue) Enqueue(_ context.Context, _ string, payload interface{}, _ ...jobs.Option) (*jobs.Job, error) {
+	if len(q.queued) >= q.limit {
+		return nil, errors.New("queue is down")
+	}
+	q.queued = append(q.queued, payload.(deliverJob).DeliveryID)
+	return &jobs.Job{}, nil
+}
+
+func newTestNotifier(webhooks int, limit int) (*notifier, *stubRepo, *stubQueue) {
+	r := &stubRepo{deliveries: map[uuid.UUID]*repo.Delivery{}}
+	for i := 0; i < webhooks; i++ {
+		r.webhooks = append(r.webhooks, repo.Webhook{ID: uuid.New(), Active: true})
+	}
+	q := &stubQueue{limit: limit}
+	n := &notifier{NotifierImpl: NotifierImpl{Repo: r, Jobs: q}, cfg: Cfg{SweepInterval: time.Millisecond}}
+	return n, r, q
+}
+
+func testEvent(t *testing.T) repo.Event {
+	t.Helper()
+	payload, err := json.Marshal(repo.EventPayload{Action: repo.ActionCreate, Book: &repo.Book{ID: uuid.New()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo.Event{ID: uuid.New(), Type: repo.EventBookCreated, Payload: payload, CreatedAt: time.Now()}
+}
+
+func TestPublishRecordsDeliveriesOnce(t *testing.T) {
+	n, r, q := newTestNotifier(3, 100)
+	e := testEvent(t)
+
+	for i := 0; i < 2; i++ {
+		if err := n.Publish(context.Background(), e); err != nil {
+			t.Fatalf("Publish #%d: %v", i+1, err)
+		}
+	}
+	if len(r.deliveries) != 3 || len(q.queued) != 3 {
+		t.Fatalf("recorded %d deliveries and queued %d, want 3 each", len(r.deliveries), len(q.queued))
+	}
+	for _, d := range r.deliveries {
+		p := Payload{}
+		if err := json.Unmarshal(d.Payload, &p); err != nil {
+			t.Fatal(err)
+		}
+		if d.EventID != e.ID || p.ID != e.ID {
+			t.Errorf("delivery of event %s with payload %s, want %s", d.EventID, p.ID, e.ID)
+		}
+	}
+}
+
+func TestSweepQueuesDeliveriesLeftUnqueued(t *testing.T) {
+	n, r, q := newTestNotifier(3, 1)
+
+	// The queue fails past the first delivery: the event is still
+	// published, the others waiting for a sweep.
+	if err := n.Publish(context.Background(), testEvent(t)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if got := r.unqueued(); got != 2 {
+		t.Fatalf("%d deliveries unqueued, want 2", got)
+	}
+
+	q.limit = 100
+	time.Sleep(2 * n.cfg.SweepInterval)
+	count, err := n.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if count != 2 || r.unqueued() != 0 || len(q.queued) != 3 {
+		t.Errorf("swept %d, %d left unqueued and %d queued; want 2, 0 and 3", count, r.unqueued(), len(q.queued))
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, id := range q.queued {
+		if seen[id] {
+			t.Errorf("delivery %s queued twice", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,77 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/caohoangphuctd97/go-test/internal/app/cache"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/dig"
+)
+
+type (
+	// BookPurger permanently removes the books deleted for longer than the
+	// retention.
+	BookPurger interface {
+		// Run purges once per interval until ctx is done.
+		Run(ctx context.Context)
+		// Purge removes the books deleted before the retention, once.
+		Purge(ctx context.Context) (int64, error)
+	}
+	BookPurgerImpl struct {
+		dig.In
+		Repo  repo.BookRepo
+		Cache cache.BookCache
+	}
+	// PurgeCfg configures the purge of deleted books.
+	PurgeCfg struct {
+		// Retention is how long a deleted book can still be restored.
+		Retention time.Duration `env:"BOOK_RETENTION" envDefault:"720h"`
+		// Interval is how often deleted books are purged.
+		Interval time.Duration `env:"BOOK_PURGE_INTERVAL" envDefault:"1h"`
+	}
+	bookPurger struct {
+		BookPurgerImpl
+		cfg PurgeCfg
+	}
+)
+
+func NewBookPurger(impl BookPurgerImpl) BookPurger {
+	cfg := PurgeCfg{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Error().Err(err).Msg("purge: config")
+	}
+	return &bookPurger{BookPurgerImpl: impl, cfg: cfg}
+}
+
+func (p *bookPurger) Run(ctx context.Context) {
+	if p.cfg.Interval <= 0 {
+		log.Info().Msg("purge: disabled")
+		return
+	}
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if n, err := p.Purge(ctx); err != nil {
+			log.Error().Err(err).Msg("purge: deleted books")
+		} else if n > 0 {
+			log.Info().Int64("books", n).Msg("purge: deleted books")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *bookPurger) Purge(ctx context.Context) (int64, error) {
+	n, err := p.Repo.PurgeBooks(ctx, time.Now().Add(-p.cfg.Retention))
+	if n > 0 {
+		// Purged books no longer show in listings of deleted books.
+		p.Cache.EvictBooks()
+	}
+	return n, err
+}
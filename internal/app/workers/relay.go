@@ -0,0 +1,107 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/caohoangphuctd97/go-test/internal/app/events"
+	"github.com/caohoangphuctd97/go-test/internal/app/repo"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/dig"
+)
+
+type (
+	// OutboxRelay publishes the events of the outbox to the sinks.
+	OutboxRelay interface {
+		// Run relays once per interval until ctx is done.
+		Run(ctx context.Context)
+		// Relay publishes one batch of unpublished events, once.
+		Relay(ctx context.Context) (int, error)
+	}
+	OutboxRelayImpl struct {
+		dig.In
+		Repo      repo.OutboxRepo
+		Publisher events.Publisher
+	}
+	// RelayCfg configures the outbox relay.
+	RelayCfg struct {
+		// Interval is how often the outbox is checked once it is drained.
+		Interval time.Duration `env:"OUTBOX_RELAY_INTERVAL" envDefault:"1s"`
+		// BatchSize is how many events are published per relay.
+		BatchSize int `env:"OUTBOX_BATCH_SIZE" envDefault:"100"`
+		// Timeout bounds a relay, publishing included. Its events are kept
+		// from other instances as long.
+		Timeout time.Duration `env:"OUTBOX_RELAY_TIMEOUT" envDefault:"1m"`
+		// Retention is how long published events are kept.
+		Retention time.Duration `env:"OUTBOX_RETENTION" envDefault:"168h"`
+	}
+	outboxRelay struct {
+		OutboxRelayImpl
+		cfg RelayCfg
+	}
+)
+
+// outboxPurgeInterval is how often published events are purged.
+const outboxPurgeInterval = time.Hour
+
+func NewOutboxRelay(impl OutboxRelayImpl) OutboxRelay {
+	cfg := RelayCfg{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Error().Err(err).Msg("outbox: config")
+	}
+	if cfg.Timeout <= 0 {
+		log.Error().Dur("timeout", cfg.Timeout).Msg("outbox: OUTBOX_RELAY_TIMEOUT must be positive, using 1m")
+		cfg.Timeout = time.Minute
+	}
+	return &outboxRelay{OutboxRelayImpl: impl, cfg: cfg}
+}
+
+func (r *outboxRelay) Run(ctx context.Context) {
+	if r.cfg.Interval <= 0 {
+		log.Info().Msg("outbox: relay disabled")
+		return
+	}
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	var purged time.Time
+	for {
+		// Drain the outbox, a full batch meaning more events may wait.
+		for {
+			n, err := r.Relay(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("outbox: relay")
+			}
+			if err != nil || n < r.cfg.BatchSize {
+				break
+			}
+		}
+
+		if time.Since(purged) >= outboxPurgeInterval {
+			if n, err := r.Repo.PurgeEvents(ctx, time.Now().Add(-r.cfg.Retention)); err != nil {
+				log.Error().Err(err).Msg("outbox: purge")
+			} else if n > 0 {
+				log.Info().Int64("events", n).Msg("outbox: purge")
+			}
+			purged = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *outboxRelay) Relay(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	return r.Repo.RelayEvents(ctx, r.cfg.BatchSize, r.cfg.Timeout, func(ctx context.Context, e repo.Event) error {
+		err := r.Publisher.Publish(ctx, e)
+		if err != nil {
+			log.Warn().Err(err).Str("event", e.ID.String()).Str("book", e.BookID.String()).Msg("outbox: publish")
+		}
+		return err
+	})
+}
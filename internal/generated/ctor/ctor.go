@@ -3,16 +3,45 @@ package ctor
 /* DO NOT EDIT. This file generated due to '@ctor' annotation*/
 
 import (
+	"github.com/caohoangphuctd97/go-test/internal/app/auth"
+	"github.com/caohoangphuctd97/go-test/internal/app/cache"
 	"github.com/caohoangphuctd97/go-test/internal/app/controllers"
 	databases "github.com/caohoangphuctd97/go-test/internal/app/database"
+	"github.com/caohoangphuctd97/go-test/internal/app/events"
+	"github.com/caohoangphuctd97/go-test/internal/app/jobs"
 	"github.com/caohoangphuctd97/go-test/internal/app/repo"
 	routes "github.com/caohoangphuctd97/go-test/internal/app/routers"
+	"github.com/caohoangphuctd97/go-test/internal/app/users"
+	"github.com/caohoangphuctd97/go-test/internal/app/webhooks"
+	"github.com/caohoangphuctd97/go-test/internal/app/workers"
+	"github.com/caohoangphuctd97/go-test/pkg/configs"
 	"github.com/caohoangphuctd97/go-test/pkg/typapp"
 )
 
 func init() {
 	typapp.Provide("", databases.NewDatabases)
+	typapp.Provide("", databases.NewTxManager)
+	typapp.Provide("", configs.NewRedisStorage)
 	typapp.Provide("", repo.NewBookRepo)
+	typapp.Provide("", repo.NewOutboxRepo)
+	typapp.Provide("", repo.NewWebhookRepo)
+	typapp.Provide("", repo.NewAPIKeyRepo)
+	typapp.Provide("", repo.NewUserRepo)
+	typapp.Provide("", events.NewStream)
+	typapp.Provide("", events.NewPublisher)
+	typapp.Provide("", cache.NewBookCache)
+	typapp.Provide("", jobs.NewQueue)
+	typapp.Provide("", webhooks.NewNotifier)
+	typapp.Provide("", controllers.NewWebhookSvc)
+	typapp.Provide("", controllers.NewAPIKeySvc)
+	typapp.Provide("", users.NewMailer)
+	typapp.Provide("", users.NewService)
+	typapp.Provide("", controllers.NewUserSvc)
 	typapp.Provide("", controllers.NewBookSvc)
+	typapp.Provide("", auth.NewAPIKeys)
+	typapp.Provide("", auth.NewRevocations)
+	typapp.Provide("", auth.NewAuthenticator)
 	typapp.Provide("", routes.NewBookCntrl)
+	typapp.Provide("", workers.NewBookPurger)
+	typapp.Provide("", workers.NewOutboxRelay)
 }
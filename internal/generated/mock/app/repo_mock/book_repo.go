@@ -1,41 +1,43 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/typical-go/typical-rest-server/internal/app/repo (interfaces: BookRepo)
+// Source: github.com/caohoangphuctd97/go-test/internal/app/repo (interfaces: BookRepo)
 
 // Package repo_mock is a generated GoMock package.
 package repo_mock
 
 import (
 	context "context"
-	gomock "github.com/golang/mock/gomock"
-	repo "github.com/typical-go/typical-rest-server/internal/app/repo"
-	sqkit "github.com/typical-go/typical-rest-server/pkg/sqkit"
 	reflect "reflect"
+	time "time"
+
+	repo "github.com/caohoangphuctd97/go-test/internal/app/repo"
+	gomock "github.com/golang/mock/gomock"
+	uuid "github.com/google/uuid"
 )
 
-// MockBookRepo is a mock of BookRepo interface
+// MockBookRepo is a mock of BookRepo interface.
 type MockBookRepo struct {
 	ctrl     *gomock.Controller
 	recorder *MockBookRepoMockRecorder
 }
 
-// MockBookRepoMockRecorder is the mock recorder for MockBookRepo
+// MockBookRepoMockRecorder is the mock recorder for MockBookRepo.
 type MockBookRepoMockRecorder struct {
 	mock *MockBookRepo
 }
 
-// NewMockBookRepo creates a new mock instance
+// NewMockBookRepo creates a new mock instance.
 func NewMockBookRepo(ctrl *gomock.Controller) *MockBookRepo {
 	mock := &MockBookRepo{ctrl: ctrl}
 	mock.recorder = &MockBookRepoMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockBookRepo) EXPECT() *MockBookRepoMockRecorder {
 	return m.recorder
 }
 
-// BulkInsert mocks base method
+// BulkInsert mocks base method.
 func (m *MockBookRepo) BulkInsert(arg0 context.Context, arg1 ...*repo.Book) (int64, error) {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{arg0}
@@ -48,124 +50,199 @@ func (m *MockBookRepo) BulkInsert(arg0 context.Context, arg1 ...*repo.Book) (int
 	return ret0, ret1
 }
 
-// BulkInsert indicates an expected call of BulkInsert
+// BulkInsert indicates an expected call of BulkInsert.
 func (mr *MockBookRepoMockRecorder) BulkInsert(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	varargs := append([]interface{}{arg0}, arg1...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BulkInsert", reflect.TypeOf((*MockBookRepo)(nil).BulkInsert), varargs...)
 }
 
-// Count mocks base method
-func (m *MockBookRepo) Count(arg0 context.Context, arg1 ...sqkit.SelectOption) (int64, error) {
+// CreateBook mocks base method.
+func (m *MockBookRepo) CreateBook(arg0 context.Context, arg1 *repo.Book) error {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{arg0}
-	for _, a := range arg1 {
-		varargs = append(varargs, a)
-	}
-	ret := m.ctrl.Call(m, "Count", varargs...)
-	ret0, _ := ret[0].(int64)
+	ret := m.ctrl.Call(m, "CreateBook", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateBook indicates an expected call of CreateBook.
+func (mr *MockBookRepoMockRecorder) CreateBook(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBook", reflect.TypeOf((*MockBookRepo)(nil).CreateBook), arg0, arg1)
+}
+
+// DeleteBook mocks base method.
+func (m *MockBookRepo) DeleteBook(arg0 context.Context, arg1 uuid.UUID, arg2 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteBook", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteBook indicates an expected call of DeleteBook.
+func (mr *MockBookRepoMockRecorder) DeleteBook(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBook", reflect.TypeOf((*MockBookRepo)(nil).DeleteBook), arg0, arg1, arg2)
+}
+
+// ExecBatch mocks base method.
+func (m *MockBookRepo) ExecBatch(arg0 context.Context, arg1 []repo.BookOp, arg2 bool) ([]repo.BookOpResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExecBatch", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]repo.BookOpResult)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Count indicates an expected call of Count
-func (mr *MockBookRepoMockRecorder) Count(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+// ExecBatch indicates an expected call of ExecBatch.
+func (mr *MockBookRepoMockRecorder) ExecBatch(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{arg0}, arg1...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Count", reflect.TypeOf((*MockBookRepo)(nil).Count), varargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecBatch", reflect.TypeOf((*MockBookRepo)(nil).ExecBatch), arg0, arg1, arg2)
 }
 
-// Delete mocks base method
-func (m *MockBookRepo) Delete(arg0 context.Context, arg1 ...sqkit.DeleteOption) (int64, error) {
+// ExportBooks mocks base method.
+func (m *MockBookRepo) ExportBooks(arg0 context.Context, arg1 func(*repo.Book) error) error {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{arg0}
-	for _, a := range arg1 {
-		varargs = append(varargs, a)
-	}
-	ret := m.ctrl.Call(m, "Delete", varargs...)
-	ret0, _ := ret[0].(int64)
+	ret := m.ctrl.Call(m, "ExportBooks", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportBooks indicates an expected call of ExportBooks.
+func (mr *MockBookRepoMockRecorder) ExportBooks(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportBooks", reflect.TypeOf((*MockBookRepo)(nil).ExportBooks), arg0, arg1)
+}
+
+// GetBook mocks base method.
+func (m *MockBookRepo) GetBook(arg0 context.Context, arg1 uuid.UUID) (repo.Book, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBook", arg0, arg1)
+	ret0, _ := ret[0].(repo.Book)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Delete indicates an expected call of Delete
-func (mr *MockBookRepoMockRecorder) Delete(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+// GetBook indicates an expected call of GetBook.
+func (mr *MockBookRepoMockRecorder) GetBook(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{arg0}, arg1...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockBookRepo)(nil).Delete), varargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBook", reflect.TypeOf((*MockBookRepo)(nil).GetBook), arg0, arg1)
 }
 
-// Find mocks base method
-func (m *MockBookRepo) Find(arg0 context.Context, arg1 ...sqkit.SelectOption) ([]*repo.Book, error) {
+// GetBooks mocks base method.
+func (m *MockBookRepo) GetBooks(arg0 context.Context, arg1 repo.BookFilter) (repo.BookPage, error) {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{arg0}
-	for _, a := range arg1 {
-		varargs = append(varargs, a)
-	}
-	ret := m.ctrl.Call(m, "Find", varargs...)
-	ret0, _ := ret[0].([]*repo.Book)
+	ret := m.ctrl.Call(m, "GetBooks", arg0, arg1)
+	ret0, _ := ret[0].(repo.BookPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Find indicates an expected call of Find
-func (mr *MockBookRepoMockRecorder) Find(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+// GetBooks indicates an expected call of GetBooks.
+func (mr *MockBookRepoMockRecorder) GetBooks(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{arg0}, arg1...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockBookRepo)(nil).Find), varargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBooks", reflect.TypeOf((*MockBookRepo)(nil).GetBooks), arg0, arg1)
 }
 
-// Insert mocks base method
-func (m *MockBookRepo) Insert(arg0 context.Context, arg1 *repo.Book) (int64, error) {
+// GetRevisions mocks base method.
+func (m *MockBookRepo) GetRevisions(arg0 context.Context, arg1 uuid.UUID) ([]repo.Revision, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Insert", arg0, arg1)
-	ret0, _ := ret[0].(int64)
+	ret := m.ctrl.Call(m, "GetRevisions", arg0, arg1)
+	ret0, _ := ret[0].([]repo.Revision)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Insert indicates an expected call of Insert
-func (mr *MockBookRepoMockRecorder) Insert(arg0, arg1 interface{}) *gomock.Call {
+// GetRevisions indicates an expected call of GetRevisions.
+func (mr *MockBookRepoMockRecorder) GetRevisions(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockBookRepo)(nil).Insert), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRevisions", reflect.TypeOf((*MockBookRepo)(nil).GetRevisions), arg0, arg1)
 }
 
-// Patch mocks base method
-func (m *MockBookRepo) Patch(arg0 context.Context, arg1 *repo.Book, arg2 ...sqkit.UpdateOption) (int64, error) {
+// PatchBook mocks base method.
+func (m *MockBookRepo) PatchBook(arg0 context.Context, arg1 uuid.UUID, arg2 int, arg3 *repo.Book, arg4 []string) error {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{arg0, arg1}
-	for _, a := range arg2 {
-		varargs = append(varargs, a)
-	}
-	ret := m.ctrl.Call(m, "Patch", varargs...)
+	ret := m.ctrl.Call(m, "PatchBook", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PatchBook indicates an expected call of PatchBook.
+func (mr *MockBookRepoMockRecorder) PatchBook(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchBook", reflect.TypeOf((*MockBookRepo)(nil).PatchBook), arg0, arg1, arg2, arg3, arg4)
+}
+
+// PurgeBooks mocks base method.
+func (m *MockBookRepo) PurgeBooks(arg0 context.Context, arg1 time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeBooks", arg0, arg1)
 	ret0, _ := ret[0].(int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Patch indicates an expected call of Patch
-func (mr *MockBookRepoMockRecorder) Patch(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+// PurgeBooks indicates an expected call of PurgeBooks.
+func (mr *MockBookRepoMockRecorder) PurgeBooks(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{arg0, arg1}, arg2...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Patch", reflect.TypeOf((*MockBookRepo)(nil).Patch), varargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeBooks", reflect.TypeOf((*MockBookRepo)(nil).PurgeBooks), arg0, arg1)
 }
 
-// Update mocks base method
-func (m *MockBookRepo) Update(arg0 context.Context, arg1 *repo.Book, arg2 ...sqkit.UpdateOption) (int64, error) {
+// RestoreBook mocks base method.
+func (m *MockBookRepo) RestoreBook(arg0 context.Context, arg1 uuid.UUID, arg2 time.Time) (repo.Book, error) {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{arg0, arg1}
-	for _, a := range arg2 {
-		varargs = append(varargs, a)
-	}
-	ret := m.ctrl.Call(m, "Update", varargs...)
-	ret0, _ := ret[0].(int64)
+	ret := m.ctrl.Call(m, "RestoreBook", arg0, arg1, arg2)
+	ret0, _ := ret[0].(repo.Book)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreBook indicates an expected call of RestoreBook.
+func (mr *MockBookRepoMockRecorder) RestoreBook(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreBook", reflect.TypeOf((*MockBookRepo)(nil).RestoreBook), arg0, arg1, arg2)
+}
+
+// RevertBook mocks base method.
+func (m *MockBookRepo) RevertBook(arg0 context.Context, arg1 uuid.UUID, arg2, arg3 int, arg4 time.Time) (repo.Book, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevertBook", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(repo.Book)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevertBook indicates an expected call of RevertBook.
+func (mr *MockBookRepoMockRecorder) RevertBook(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevertBook", reflect.TypeOf((*MockBookRepo)(nil).RevertBook), arg0, arg1, arg2, arg3, arg4)
+}
+
+// SearchBooks mocks base method.
+func (m *MockBookRepo) SearchBooks(arg0 context.Context, arg1 repo.BookSearch) (repo.SearchPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchBooks", arg0, arg1)
+	ret0, _ := ret[0].(repo.SearchPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Update indicates an expected call of Update
-func (mr *MockBookRepoMockRecorder) Update(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+// SearchBooks indicates an expected call of SearchBooks.
+func (mr *MockBookRepoMockRecorder) SearchBooks(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchBooks", reflect.TypeOf((*MockBookRepo)(nil).SearchBooks), arg0, arg1)
+}
+
+// UpdateBook mocks base method.
+func (m *MockBookRepo) UpdateBook(arg0 context.Context, arg1 uuid.UUID, arg2 int, arg3 *repo.Book) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateBook", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateBook indicates an expected call of UpdateBook.
+func (mr *MockBookRepoMockRecorder) UpdateBook(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{arg0, arg1}, arg2...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockBookRepo)(nil).Update), varargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBook", reflect.TypeOf((*MockBookRepo)(nil).UpdateBook), arg0, arg1, arg2, arg3)
 }
@@ -1,125 +1,231 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/typical-go/typical-rest-server/internal/app/service (interfaces: BookSvc)
+// Source: github.com/caohoangphuctd97/go-test/internal/app/controllers (interfaces: BookSvc)
 
 // Package service_mock is a generated GoMock package.
 package service_mock
 
 import (
-	context "context"
-	gomock "github.com/golang/mock/gomock"
-	repo "github.com/typical-go/typical-rest-server/internal/app/repo"
-	service "github.com/typical-go/typical-rest-server/internal/app/service"
 	reflect "reflect"
+
+	fiber "github.com/gofiber/fiber/v2"
+	gomock "github.com/golang/mock/gomock"
 )
 
-// MockBookSvc is a mock of BookSvc interface
+// MockBookSvc is a mock of BookSvc interface.
 type MockBookSvc struct {
 	ctrl     *gomock.Controller
 	recorder *MockBookSvcMockRecorder
 }
 
-// MockBookSvcMockRecorder is the mock recorder for MockBookSvc
+// MockBookSvcMockRecorder is the mock recorder for MockBookSvc.
 type MockBookSvcMockRecorder struct {
 	mock *MockBookSvc
 }
 
-// NewMockBookSvc creates a new mock instance
+// NewMockBookSvc creates a new mock instance.
 func NewMockBookSvc(ctrl *gomock.Controller) *MockBookSvc {
 	mock := &MockBookSvc{ctrl: ctrl}
 	mock.recorder = &MockBookSvcMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockBookSvc) EXPECT() *MockBookSvcMockRecorder {
 	return m.recorder
 }
 
-// Create mocks base method
-func (m *MockBookSvc) Create(arg0 context.Context, arg1 *repo.Book) (*repo.Book, error) {
+// BatchBooks mocks base method.
+func (m *MockBookSvc) BatchBooks(arg0 *fiber.Ctx) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BatchBooks", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BatchBooks indicates an expected call of BatchBooks.
+func (mr *MockBookSvcMockRecorder) BatchBooks(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BatchBooks", reflect.TypeOf((*MockBookSvc)(nil).BatchBooks), arg0)
+}
+
+// CreateBook mocks base method.
+func (m *MockBookSvc) CreateBook(arg0 *fiber.Ctx) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Create", arg0, arg1)
-	ret0, _ := ret[0].(*repo.Book)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret := m.ctrl.Call(m, "CreateBook", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-// Create indicates an expected call of Create
-func (mr *MockBookSvcMockRecorder) Create(arg0, arg1 interface{}) *gomock.Call {
+// CreateBook indicates an expected call of CreateBook.
+func (mr *MockBookSvcMockRecorder) CreateBook(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockBookSvc)(nil).Create), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBook", reflect.TypeOf((*MockBookSvc)(nil).CreateBook), arg0)
 }
 
-// Delete mocks base method
-func (m *MockBookSvc) Delete(arg0 context.Context, arg1 string) error {
+// DeleteBook mocks base method.
+func (m *MockBookSvc) DeleteBook(arg0 *fiber.Ctx) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Delete", arg0, arg1)
+	ret := m.ctrl.Call(m, "DeleteBook", arg0)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// Delete indicates an expected call of Delete
-func (mr *MockBookSvcMockRecorder) Delete(arg0, arg1 interface{}) *gomock.Call {
+// DeleteBook indicates an expected call of DeleteBook.
+func (mr *MockBookSvcMockRecorder) DeleteBook(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockBookSvc)(nil).Delete), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBook", reflect.TypeOf((*MockBookSvc)(nil).DeleteBook), arg0)
 }
 
-// Find mocks base method
-func (m *MockBookSvc) Find(arg0 context.Context, arg1 *service.FindBookReq) (*service.FindBookResp, error) {
+// ExportBooks mocks base method.
+func (m *MockBookSvc) ExportBooks(arg0 *fiber.Ctx) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Find", arg0, arg1)
-	ret0, _ := ret[0].(*service.FindBookResp)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret := m.ctrl.Call(m, "ExportBooks", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-// Find indicates an expected call of Find
-func (mr *MockBookSvcMockRecorder) Find(arg0, arg1 interface{}) *gomock.Call {
+// ExportBooks indicates an expected call of ExportBooks.
+func (mr *MockBookSvcMockRecorder) ExportBooks(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockBookSvc)(nil).Find), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportBooks", reflect.TypeOf((*MockBookSvc)(nil).ExportBooks), arg0)
 }
 
-// FindOne mocks base method
-func (m *MockBookSvc) FindOne(arg0 context.Context, arg1 string) (*repo.Book, error) {
+// GetBook mocks base method.
+func (m *MockBookSvc) GetBook(arg0 *fiber.Ctx) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "FindOne", arg0, arg1)
-	ret0, _ := ret[0].(*repo.Book)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret := m.ctrl.Call(m, "GetBook", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-// FindOne indicates an expected call of FindOne
-func (mr *MockBookSvcMockRecorder) FindOne(arg0, arg1 interface{}) *gomock.Call {
+// GetBook indicates an expected call of GetBook.
+func (mr *MockBookSvcMockRecorder) GetBook(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindOne", reflect.TypeOf((*MockBookSvc)(nil).FindOne), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBook", reflect.TypeOf((*MockBookSvc)(nil).GetBook), arg0)
 }
 
-// Patch mocks base method
-func (m *MockBookSvc) Patch(arg0 context.Context, arg1 string, arg2 *repo.Book) (*repo.Book, error) {
+// GetBooks mocks base method.
+func (m *MockBookSvc) GetBooks(arg0 *fiber.Ctx) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Patch", arg0, arg1, arg2)
-	ret0, _ := ret[0].(*repo.Book)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret := m.ctrl.Call(m, "GetBooks", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-// Patch indicates an expected call of Patch
-func (mr *MockBookSvcMockRecorder) Patch(arg0, arg1, arg2 interface{}) *gomock.Call {
+// GetBooks indicates an expected call of GetBooks.
+func (mr *MockBookSvcMockRecorder) GetBooks(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Patch", reflect.TypeOf((*MockBookSvc)(nil).Patch), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBooks", reflect.TypeOf((*MockBookSvc)(nil).GetBooks), arg0)
 }
 
-// Update mocks base method
-func (m *MockBookSvc) Update(arg0 context.Context, arg1 string, arg2 *repo.Book) (*repo.Book, error) {
+// GetHistory mocks base method.
+func (m *MockBookSvc) GetHistory(arg0 *fiber.Ctx) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Update", arg0, arg1, arg2)
-	ret0, _ := ret[0].(*repo.Book)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret := m.ctrl.Call(m, "GetHistory", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetHistory indicates an expected call of GetHistory.
+func (mr *MockBookSvcMockRecorder) GetHistory(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHistory", reflect.TypeOf((*MockBookSvc)(nil).GetHistory), arg0)
+}
+
+// ImportBooks mocks base method.
+func (m *MockBookSvc) ImportBooks(arg0 *fiber.Ctx) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportBooks", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ImportBooks indicates an expected call of ImportBooks.
+func (mr *MockBookSvcMockRecorder) ImportBooks(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportBooks", reflect.TypeOf((*MockBookSvc)(nil).ImportBooks), arg0)
+}
+
+// PatchBook mocks base method.
+func (m *MockBookSvc) PatchBook(arg0 *fiber.Ctx) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PatchBook", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PatchBook indicates an expected call of PatchBook.
+func (mr *MockBookSvcMockRecorder) PatchBook(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchBook", reflect.TypeOf((*MockBookSvc)(nil).PatchBook), arg0)
+}
+
+// QueueExport mocks base method.
+func (m *MockBookSvc) QueueExport(arg0 *fiber.Ctx) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueueExport", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// QueueExport indicates an expected call of QueueExport.
+func (mr *MockBookSvcMockRecorder) QueueExport(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueueExport", reflect.TypeOf((*MockBookSvc)(nil).QueueExport), arg0)
+}
+
+// RestoreBook mocks base method.
+func (m *MockBookSvc) RestoreBook(arg0 *fiber.Ctx) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreBook", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreBook indicates an expected call of RestoreBook.
+func (mr *MockBookSvcMockRecorder) RestoreBook(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreBook", reflect.TypeOf((*MockBookSvc)(nil).RestoreBook), arg0)
+}
+
+// RevertBook mocks base method.
+func (m *MockBookSvc) RevertBook(arg0 *fiber.Ctx) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevertBook", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevertBook indicates an expected call of RevertBook.
+func (mr *MockBookSvcMockRecorder) RevertBook(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevertBook", reflect.TypeOf((*MockBookSvc)(nil).RevertBook), arg0)
+}
+
+// SearchBooks mocks base method.
+func (m *MockBookSvc) SearchBooks(arg0 *fiber.Ctx) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchBooks", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SearchBooks indicates an expected call of SearchBooks.
+func (mr *MockBookSvcMockRecorder) SearchBooks(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchBooks", reflect.TypeOf((*MockBookSvc)(nil).SearchBooks), arg0)
+}
+
+// UpdateBook mocks base method.
+func (m *MockBookSvc) UpdateBook(arg0 *fiber.Ctx) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateBook", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-// Update indicates an expected call of Update
-func (mr *MockBookSvcMockRecorder) Update(arg0, arg1, arg2 interface{}) *gomock.Call {
+// UpdateBook indicates an expected call of UpdateBook.
+func (mr *MockBookSvcMockRecorder) UpdateBook(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockBookSvc)(nil).Update), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBook", reflect.TypeOf((*MockBookSvc)(nil).UpdateBook), arg0)
 }
@@ -0,0 +1,155 @@
+package configs
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// StatusClientClosedRequest is sent when the client gave up on the
+	// request before it could be served.
+	StatusClientClosedRequest = 499
+
+	// MIMEApplicationProblemJSON is the media type of RFC 7807 problems.
+	MIMEApplicationProblemJSON = "application/problem+json"
+
+	// ErrorFormatLegacy selects LegacyErrorHandler through the ERROR_FORMAT
+	// variable.
+	ErrorFormatLegacy = "legacy"
+)
+
+type (
+	// Problem is an RFC 7807 problem details object.
+	Problem struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail,omitempty"`
+		Instance string `json:"instance,omitempty"`
+		// Errors lists the invalid fields of a validation problem.
+		Errors []errs.FieldError `json:"errors,omitempty"`
+		// Extensions are the extension members of the problem, written
+		// beside the members above.
+		Extensions map[string]interface{} `json:"-"`
+	}
+	// problemKind describes how a kind of domain error is reported.
+	problemKind struct {
+		status int
+		slug   string
+	}
+)
+
+// problemKinds maps the kinds of domain errors to their HTTP status and
+// problem type.
+var problemKinds = map[error]problemKind{
+	errs.ErrNotFound:             {fiber.StatusNotFound, "not-found"},
+	errs.ErrConflict:             {fiber.StatusConflict, "conflict"},
+	errs.ErrValidation:           {fiber.StatusBadRequest, "validation"},
+	errs.ErrUnprocessable:        {fiber.StatusUnprocessableEntity, "unprocessable"},
+	errs.ErrPrecondition:         {fiber.StatusPreconditionFailed, "precondition-failed"},
+	errs.ErrPreconditionRequired: {fiber.StatusPreconditionRequired, "precondition-required"},
+	errs.ErrUnavailable:          {fiber.StatusServiceUnavailable, "unavailable"},
+	errs.ErrTimeout:              {fiber.StatusGatewayTimeout, "timeout"},
+	errs.ErrCanceled:             {StatusClientClosedRequest, "canceled"},
+	errs.ErrUnauthorized:         {fiber.StatusUnauthorized, "unauthorized"},
+	errs.ErrForbidden:            {fiber.StatusForbidden, "forbidden"},
+}
+
+// problemTypeBase prefixes the slug of every problem type.
+const problemTypeBase = "/problems/"
+
+// NewProblem func builds the problem details reported for given error.
+// Errors of unknown kind are logged and hidden behind a 500.
+func NewProblem(c *fiber.Ctx, err error) Problem {
+	p := Problem{
+		Type:     "about:blank",
+		Status:   fiber.StatusInternalServerError,
+		Instance: c.OriginalURL(),
+	}
+
+	var de *errs.Error
+	var fe *fiber.Error
+	switch {
+	case errors.As(err, &de):
+		if k, ok := problemKinds[de.Kind]; ok {
+			p.Type = problemTypeBase + k.slug
+			p.Status = k.status
+			p.Detail = de.Public()
+			p.Errors = de.Fields
+			p.Extensions = de.Extensions
+		}
+	case errors.As(err, &fe):
+		p.Status = fe.Code
+		p.Detail = fe.Message
+	}
+
+	p.Title = utils.StatusMessage(p.Status)
+	if p.Title == "" {
+		p.Title = "Client Closed Request"
+	}
+	if p.Status >= fiber.StatusInternalServerError {
+		log.Error().Err(err).Str("method", c.Method()).Str("path", c.Path()).Msg("request failed")
+		if de == nil || problemKinds[de.Kind].status == 0 {
+			p.Detail = ""
+		}
+	}
+	return p
+}
+
+// MarshalJSON writes the extension members of p beside its standard ones,
+// which they cannot override.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	data, err := json.Marshal(problem(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return data, err
+	}
+	members := map[string]interface{}{}
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+// ErrorHandler func replies to every error returned by a handler or a
+// middleware with an RFC 7807 problem.
+// See: https://docs.gofiber.io/guide/error-handling
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	p := NewProblem(c, err)
+	return c.Status(p.Status).JSON(p, MIMEApplicationProblemJSON)
+}
+
+// LegacyErrorHandler func replies to every error returned by a handler or
+// a middleware with the {"error": true, "msg": ...} envelope, msg being a
+// message per invalid field for validation errors. Extension members of
+// the problem are kept in the envelope.
+func LegacyErrorHandler(c *fiber.Ctx, err error) error {
+	p := NewProblem(c, err)
+	var msg interface{} = p.Detail
+	if p.Detail == "" {
+		msg = strings.ToLower(p.Title)
+	}
+	if len(p.Errors) > 0 {
+		fields := map[string]string{}
+		for _, f := range p.Errors {
+			fields[f.Name] = f.Detail
+		}
+		msg = fields
+	}
+	envelope := fiber.Map{}
+	for k, v := range p.Extensions {
+		envelope[k] = v
+	}
+	envelope["error"] = true
+	envelope["msg"] = msg
+	return c.Status(p.Status).JSON(envelope)
+}
@@ -0,0 +1,65 @@
+package configs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestErrorHandlersKeepExtensions(t *testing.T) {
+	errAborted := errs.New(errs.ErrUnprocessable, "batch was rolled back")
+	for _, tt := range []struct {
+		name    string
+		handler fiber.ErrorHandler
+		want    map[string]interface{}
+	}{
+		{"problem", ErrorHandler, map[string]interface{}{
+			"type":     "/problems/unprocessable",
+			"title":    "Unprocessable Entity",
+			"status":   float64(fiber.StatusUnprocessableEntity),
+			"detail":   "batch was rolled back",
+			"instance": "/batch",
+			"error":    false,
+			"results":  []interface{}{"not applied"},
+		}},
+		{"legacy", LegacyErrorHandler, map[string]interface{}{
+			"error":   true,
+			"msg":     "batch was rolled back",
+			"status":  float64(fiber.StatusOK),
+			"results": []interface{}{"not applied"},
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: tt.handler})
+			app.Get("/batch", func(c *fiber.Ctx) error {
+				// Extensions never override the members of the format.
+				return errAborted.With("results", []string{"not applied"}).With("status", fiber.StatusOK).With("error", false)
+			})
+
+			req, _ := http.NewRequest(fiber.MethodGet, "/batch", nil)
+			res, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != fiber.StatusUnprocessableEntity {
+				t.Errorf("status = %d, want %d", res.StatusCode, fiber.StatusUnprocessableEntity)
+			}
+			body, _ := io.ReadAll(res.Body)
+			got := map[string]interface{}{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("body = %s, want %v", body, tt.want)
+			}
+		})
+	}
+	if errAborted.Extensions != nil {
+		t.Errorf("With changed the error it was called on: %v", errAborted.Extensions)
+	}
+}
@@ -3,6 +3,7 @@ package configs
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -14,8 +15,15 @@ func FiberConfig() fiber.Config {
 	// Define server settings.
 	readTimeoutSecondsCount, _ := strconv.Atoi(os.Getenv("SERVER_READ_TIMEOUT"))
 
+	// Report errors as RFC 7807 problems, unless the legacy envelope is asked.
+	errorHandler := ErrorHandler
+	if strings.EqualFold(os.Getenv("ERROR_FORMAT"), ErrorFormatLegacy) {
+		errorHandler = LegacyErrorHandler
+	}
+
 	// Return Fiber configuration.
 	return fiber.Config{
-		ReadTimeout: time.Second * time.Duration(readTimeoutSecondsCount),
+		ReadTimeout:  time.Second * time.Duration(readTimeoutSecondsCount),
+		ErrorHandler: errorHandler,
 	}
 }
@@ -2,20 +2,41 @@ package configs
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"strings"
 	"time"
 
+	"github.com/caarlos0/env/v10"
 	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
 )
 
 // RedisStorage holds client info and implements fiber.Storage interface
 type RedisStorage struct {
-	addr        string
-	db          int
-	password    string
-	redisClient *redis.Client
+	addrs            []string
+	masterName       string
+	cluster          bool
+	db               int
+	username         string
+	password         string
+	sentinelPassword string
+	tlsConfig        *tls.Config
+	dialTimeout      time.Duration
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+	poolSize         int
+	prefix           string
+	redisClient      redis.UniversalClient
 }
 
+// resetBatchSize is the number of keys Reset asks SCAN for at a time.
+const resetBatchSize = 500
+
+// ErrResetUnprefixed is returned by Reset on a storage without prefix,
+// whose keys can't be told apart from the others of the DB.
+var ErrResetUnprefixed = errors.New("redis storage: refusing to reset without a key prefix, set REDIS_PREFIX")
+
 type RedisClientOption func(*RedisStorage)
 
 // New returns fiber.Storage implementation only
@@ -24,6 +45,10 @@ func RedisNew(opts ...RedisClientOption) fiber.Storage {
 }
 
 // Newclient returns fiber.Storage plus extra methods
+//
+// The client talks to a single node by default, to the master monitored by
+// Sentinel when WithSentinel is used, and to a Cluster when WithCluster is
+// used.
 func NewClient(opts ...RedisClientOption) *RedisStorage {
 	storage := &RedisStorage{}
 
@@ -31,30 +56,59 @@ func NewClient(opts ...RedisClientOption) *RedisStorage {
 		option(storage)
 	}
 
-	if storage.addr == "" {
-		storage.addr = "localhost:6379"
+	if len(storage.addrs) == 0 {
+		storage.addrs = []string{"localhost:6379"}
 	}
 
 	if storage.redisClient == nil {
-		storage.redisClient = redis.NewClient(&redis.Options{
-			Addr:     storage.addr,
-			Password: storage.password,
-			DB:       storage.db,
-		})
+		uopts := &redis.UniversalOptions{
+			Addrs:            storage.addrs,
+			MasterName:       storage.masterName,
+			DB:               storage.db,
+			Username:         storage.username,
+			Password:         storage.password,
+			SentinelPassword: storage.sentinelPassword,
+			TLSConfig:        storage.tlsConfig,
+			DialTimeout:      storage.dialTimeout,
+			ReadTimeout:      storage.readTimeout,
+			WriteTimeout:     storage.writeTimeout,
+			PoolSize:         storage.poolSize,
+		}
+		if storage.cluster {
+			storage.redisClient = redis.NewClusterClient(uopts.Cluster())
+		} else {
+			storage.redisClient = redis.NewUniversalClient(uopts)
+		}
 	}
 
 	return storage
 }
 
-// Client returns redis client pointer directly for extra setup?
-func (rs *RedisStorage) Client() *redis.Client {
+// NewRedisStorage returns a RedisStorage configured from environment, see
+// RedisCfg.
+func NewRedisStorage() *RedisStorage {
+	cfg := RedisCfg{}
+	env.Parse(&cfg)
+	return NewClient(cfg.Options()...)
+}
+
+// Client returns redis client directly for extra setup
+func (rs *RedisStorage) Client() redis.UniversalClient {
 	return rs.redisClient
 }
 
+// Namespace returns a storage sharing the same client with every key under
+// given prefix, appended to the current one.
+func (rs *RedisStorage) Namespace(prefix string) *RedisStorage {
+	ns := *rs
+	ns.prefix = rs.prefix + prefix
+	return &ns
+}
+
 //ClientOptions
 
 // Withclient sets entirely dev owned Client, all other options will be discarded if this is in use
-func WithClient(client *redis.Client) RedisClientOption {
+func WithClient(client redis.UniversalClient) RedisClientOption {
 	return func(rs *RedisStorage) {
 		rs.redisClient = client
 	}
@@ -63,17 +117,56 @@ func WithClient(client *redis.Client) RedisClientOption {
 // WithAddr sets address of redis
 func WithAddr(addr string) RedisClientOption {
 	return func(rs *RedisStorage) {
-		rs.addr = addr
+		rs.addrs = []string{addr}
+	}
+}
+
+// WithAddrs sets addresses of redis, more than one address means a Cluster
+// unless WithSentinel is used
+func WithAddrs(addrs ...string) RedisClientOption {
+	return func(rs *RedisStorage) {
+		rs.addrs = addrs
+	}
+}
+
+// WithSentinel connects to the master of given name through given sentinels
+func WithSentinel(masterName string, sentinelAddrs ...string) RedisClientOption {
+	return func(rs *RedisStorage) {
+		rs.masterName = masterName
+		rs.addrs = sentinelAddrs
 	}
 }
 
-// WithDB sets DB number
+// WithSentinelPassword sets password for the sentinels, when it differs from
+// the master one
+func WithSentinelPassword(password string) RedisClientOption {
+	return func(rs *RedisStorage) {
+		rs.sentinelPassword = password
+	}
+}
+
+// WithCluster connects to a Cluster through given seed nodes, even a single one
+func WithCluster(addrs ...string) RedisClientOption {
+	return func(rs *RedisStorage) {
+		rs.cluster = true
+		rs.addrs = addrs
+	}
+}
+
+// WithDB sets DB number, ignored by Cluster
 func WithDB(DB int) RedisClientOption {
 	return func(rs *RedisStorage) {
 		rs.db = DB
 	}
 }
 
+// WithUsername sets ACL username for default redis client
+func WithUsername(username string) RedisClientOption {
+	return func(rs *RedisStorage) {
+		rs.username = username
+	}
+}
+
 // WithPassword sets password for default redis client
 func WithPassword(password string) RedisClientOption {
 	return func(rs *RedisStorage) {
@@ -81,10 +174,45 @@ func WithPassword(password string) RedisClientOption {
 	}
 }
 
+// WithTLS enables TLS with given config
+func WithTLS(cfg *tls.Config) RedisClientOption {
+	return func(rs *RedisStorage) {
+		rs.tlsConfig = cfg
+	}
+}
+
+// WithTimeouts sets dial, read and write timeouts, zero keeps the default
+func WithTimeouts(dial, read, write time.Duration) RedisClientOption {
+	return func(rs *RedisStorage) {
+		rs.dialTimeout = dial
+		rs.readTimeout = read
+		rs.writeTimeout = write
+	}
+}
+
+// WithPoolSize sets the maximum number of connections per node
+func WithPoolSize(size int) RedisClientOption {
+	return func(rs *RedisStorage) {
+		rs.poolSize = size
+	}
+}
+
+// WithPrefix namespaces every key under given prefix, e.g. "book_app:cache:"
+func WithPrefix(prefix string) RedisClientOption {
+	return func(rs *RedisStorage) {
+		rs.prefix = prefix
+	}
+}
+
+// key returns given key namespaced under the storage prefix.
+func (rs *RedisStorage) key(key string) string {
+	return rs.prefix + key
+}
+
 // Get gets the value for the given key.
 // It returns ErrNotFound if the storage does not contain the key.
 func (rs *RedisStorage) Get(key string) ([]byte, error) {
-	result := rs.redisClient.Get(context.Background(), key)
+	result := rs.redisClient.Get(context.Background(), rs.key(key))
 	val, err := result.Bytes()
 	if redis.Nil == err {
 		return val, fiber.ErrNotFound
@@ -96,23 +224,77 @@ func (rs *RedisStorage) Get(key string) ([]byte, error) {
 // time-to-live expiration value, 0 means live for ever
 // Empty key or value will be ignored without an error.
 func (rs *RedisStorage) Set(key string, val []byte, ttl time.Duration) error {
-	result := rs.redisClient.Set(context.Background(), key, val, ttl)
+	result := rs.redisClient.Set(context.Background(), rs.key(key), val, ttl)
 	return result.Err()
 }
 
 // Delete deletes the value for the given key.
 // It returns no error if the storage does not contain the key,
 func (rs *RedisStorage) Delete(key string) error {
-	result := rs.redisClient.Del(context.Background(), key)
+	result := rs.redisClient.Del(context.Background(), rs.key(key))
 	return result.Err()
 }
 
-// Reset resets the storage and delete all keys.
+// Reset resets the storage and delete all keys under its prefix.
+// Keys are walked with SCAN and unlinked batch by batch so Redis is never
+// blocked, and keys outside the prefix are left alone. On a Cluster every
+// master is walked. A storage without prefix refuses with
+// ErrResetUnprefixed rather than delete every key of the DB.
 func (rs *RedisStorage) Reset() error {
-	result := rs.redisClient.FlushAll(context.Background())
-	return result.Err()
+	if rs.prefix == "" {
+		return ErrResetUnprefixed
+	}
+	ctx := context.Background()
+	match := escapeGlob(rs.prefix) + "*"
+
+	if cc, ok := rs.redisClient.(*redis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return unlinkMatching(ctx, c, match)
+		})
+	}
+	return unlinkMatching(ctx, rs.redisClient, match)
 }
 
+// unlinkMatching unlinks the keys matching given pattern on one node.
+// Keys are unlinked one by one in a pipeline since a Cluster node refuses
+// multi-key commands over several slots.
+func unlinkMatching(ctx context.Context, c redis.Cmdable, match string) error {
+	var cursor uint64
+	for {
+		keys, next, err := c.Scan(ctx, cursor, match, resetBatchSize).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if _, err := c.Pipelined(ctx, func(p redis.Pipeliner) error {
+				for _, key := range keys {
+					p.Unlink(ctx, key)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// escapeGlob escapes the characters SCAN MATCH treats as a pattern.
+func escapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
+
+var globEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`*`, `\*`,
+	`?`, `\?`,
+	`[`, `\[`,
+	`]`, `\]`,
+)
+
 // Close closes the storage and will stop any running garbage
 // collectors and open connections.
 func (rs *RedisStorage) Close() error {
@@ -0,0 +1,66 @@
+package configs
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// RedisCfg configures RedisStorage from environment.
+//
+// A single node is used by default. Setting REDIS_MASTER_NAME goes through
+// the sentinels in REDIS_ADDRS, and REDIS_CLUSTER treats REDIS_ADDRS as
+// Cluster seed nodes. REDIS_PREFIX namespaces the keys of the storage, and
+// of its namespaces, so that several apps can share a DB: Reset refuses to
+// run without it.
+type RedisCfg struct {
+	Addrs            []string `env:"REDIS_ADDRS" envSeparator:"," envDefault:"localhost:6379"`
+	MasterName       string   `env:"REDIS_MASTER_NAME"`
+	Cluster          bool     `env:"REDIS_CLUSTER" envDefault:"false"`
+	DB               int      `env:"REDIS_DB" envDefault:"0"`
+	Username         string   `env:"REDIS_USERNAME"`
+	Password         string   `env:"REDIS_PASSWORD"`
+	SentinelPassword string   `env:"REDIS_SENTINEL_PASSWORD"`
+	Prefix           string   `env:"REDIS_PREFIX"`
+
+	TLS                   bool   `env:"REDIS_TLS" envDefault:"false"`
+	TLSServerName         string `env:"REDIS_TLS_SERVER_NAME"`
+	TLSInsecureSkipVerify bool   `env:"REDIS_TLS_INSECURE_SKIP_VERIFY" envDefault:"false"`
+
+	DialTimeout  time.Duration `env:"REDIS_DIAL_TIMEOUT" envDefault:"5s"`
+	ReadTimeout  time.Duration `env:"REDIS_READ_TIMEOUT" envDefault:"3s"`
+	WriteTimeout time.Duration `env:"REDIS_WRITE_TIMEOUT" envDefault:"3s"`
+	PoolSize     int           `env:"REDIS_POOL_SIZE" envDefault:"0"`
+}
+
+// Options returns the client options matching the config.
+func (c *RedisCfg) Options() []RedisClientOption {
+	opts := []RedisClientOption{
+		WithDB(c.DB),
+		WithUsername(c.Username),
+		WithPassword(c.Password),
+		WithTimeouts(c.DialTimeout, c.ReadTimeout, c.WriteTimeout),
+		WithPoolSize(c.PoolSize),
+		WithPrefix(c.Prefix),
+	}
+
+	switch {
+	case c.MasterName != "":
+		opts = append(opts,
+			WithSentinel(c.MasterName, c.Addrs...),
+			WithSentinelPassword(c.SentinelPassword),
+		)
+	case c.Cluster:
+		opts = append(opts, WithCluster(c.Addrs...))
+	default:
+		opts = append(opts, WithAddrs(c.Addrs...))
+	}
+
+	if c.TLS {
+		opts = append(opts, WithTLS(&tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         c.TLSServerName,
+			InsecureSkipVerify: c.TLSInsecureSkipVerify,
+		}))
+	}
+	return opts
+}
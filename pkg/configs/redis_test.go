@@ -0,0 +1,58 @@
+package configs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestStorage(t *testing.T, opts ...RedisClientOption) (*RedisStorage, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewClient(append(opts, WithClient(client))...), mr
+}
+
+func TestResetDeletesOnlyPrefixedKeys(t *testing.T) {
+	rs, mr := newTestStorage(t, WithPrefix("app:"))
+	cache := rs.Namespace("cache:")
+	for _, key := range []string{"a", "b*", "[c]"} {
+		if err := cache.Set(key, []byte("v"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mr.Set("other:key", "v")
+	mr.Set("app:cachex", "v")
+
+	if err := cache.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if got := mr.Keys(); len(got) != 2 || got[0] != "app:cachex" || got[1] != "other:key" {
+		t.Fatalf("keys left = %v, want [app:cachex other:key]", got)
+	}
+}
+
+func TestResetRefusedWithoutPrefix(t *testing.T) {
+	rs, mr := newTestStorage(t)
+	mr.Set("other:key", "v")
+
+	if err := rs.Reset(); !errors.Is(err, ErrResetUnprefixed) {
+		t.Fatalf("Reset() = %v, want %v", err, ErrResetUnprefixed)
+	}
+	if !mr.Exists("other:key") {
+		t.Fatal("key deleted by a refused Reset")
+	}
+}
+
+func TestRedisCfgPrefix(t *testing.T) {
+	t.Setenv("REDIS_PREFIX", "app:")
+	rs := NewRedisStorage()
+	defer rs.Close()
+	if got := rs.Namespace("cache:").prefix; got != "app:cache:" {
+		t.Fatalf("prefix = %q, want app:cache:", got)
+	}
+}
@@ -0,0 +1,105 @@
+// Package errs holds the domain errors shared by every layer of the app.
+//
+// Each error has a kind, one of the sentinel errors below, so callers can
+// test it with errors.Is whatever the layer that produced it, and a message
+// safe to show to clients. The HTTP layer maps kinds to status codes.
+package errs
+
+import (
+	"errors"
+)
+
+// Kinds of domain errors.
+var (
+	ErrNotFound             = errors.New("not found")
+	ErrConflict             = errors.New("conflict")
+	ErrValidation           = errors.New("validation failed")
+	ErrUnprocessable        = errors.New("unprocessable")
+	ErrPrecondition         = errors.New("precondition failed")
+	ErrPreconditionRequired = errors.New("precondition required")
+	ErrUnavailable          = errors.New("service unavailable")
+	ErrTimeout              = errors.New("timed out")
+	ErrCanceled             = errors.New("canceled")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrForbidden            = errors.New("forbidden")
+)
+
+// Error is a domain error of a given kind.
+type Error struct {
+	// Kind is one of the sentinel errors of this package.
+	Kind error
+	// Message describes the error to clients.
+	Message string
+	// Fields lists the invalid fields, for ErrValidation.
+	Fields []FieldError
+	// Extensions are members added to what clients are told of the
+	// error, by name.
+	Extensions map[string]interface{}
+	// Err is the underlying cause, never shown to clients.
+	Err error
+}
+
+// FieldError describes one invalid field of a request.
+type FieldError struct {
+	// Name is the struct field name.
+	Name string `json:"-"`
+	// Pointer is the JSON Pointer (RFC 6901) to the field in the request.
+	Pointer string `json:"pointer"`
+	// Detail explains what is wrong with the field.
+	Detail string `json:"detail"`
+}
+
+// New returns an error of given kind.
+func New(kind error, msg string) *Error {
+	return &Error{Kind: kind, Message: msg}
+}
+
+// Wrap returns an error of given kind caused by err. An empty msg falls
+// back to the kind text.
+func Wrap(kind, err error, msg string) *Error {
+	return &Error{Kind: kind, Message: msg, Err: err}
+}
+
+// Invalid returns a validation error listing given invalid fields.
+func Invalid(fields ...FieldError) *Error {
+	return &Error{Kind: ErrValidation, Message: ErrValidation.Error(), Fields: fields}
+}
+
+// With returns a copy of e with given extension member.
+func (e *Error) With(name string, value interface{}) *Error {
+	c := *e
+	c.Extensions = make(map[string]interface{}, len(e.Extensions)+1)
+	for k, v := range e.Extensions {
+		c.Extensions[k] = v
+	}
+	c.Extensions[name] = value
+	return &c
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Kind.Error()
+	}
+	if e.Err != nil {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is reports whether target is the kind of e.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Public returns the message of e to show to clients.
+func (e *Error) Public() string {
+	if e.Message == "" {
+		return e.Kind.Error()
+	}
+	return e.Message
+}
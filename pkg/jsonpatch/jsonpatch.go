@@ -0,0 +1,398 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MergePatchType is the media type of a JSON Merge Patch document.
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is the media type of a JSON Patch document.
+	JSONPatchType = "application/json-patch+json"
+)
+
+var (
+	// ErrMalformed is returned when a document or patch is not valid JSON,
+	// or an operation is not a valid RFC 6902 operation.
+	ErrMalformed = errors.New("malformed patch")
+	// ErrPath is returned when an operation points at a location that does
+	// not exist or can't hold a value.
+	ErrPath = errors.New("invalid patch path")
+	// ErrTestFailed is returned when a "test" operation does not hold.
+	ErrTestFailed = errors.New("patch test failed")
+)
+
+// Operation is a single RFC 6902 operation.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MergePatch applies given JSON Merge Patch to doc.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decode(patch)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(merge(target, p))
+}
+
+// merge is the MergePatch algorithm of RFC 7396 section 2.
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = merge(t[k], v)
+		}
+	}
+	return t
+}
+
+// Apply applies given JSON Patch to doc. Operations are applied in order
+// and the whole patch fails if any of them does.
+func Apply(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	ops := []Operation{}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	for i, op := range ops {
+		if target, err = op.apply(target); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+func (op *Operation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, fmt.Errorf("%w: %q needs a value", ErrMalformed, op.Op)
+		}
+		value, err := decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			return replace(doc, path, value)
+		}
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, value) {
+			return nil, fmt.Errorf("%w: %s", ErrTestFailed, op.Path)
+		}
+		return doc, nil
+
+	case "remove":
+		return remove(doc, path)
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			return add(doc, path, clone(value))
+		}
+		if len(path) > len(from) && path.hasPrefix(from) {
+			return nil, fmt.Errorf("%w: cannot move %s into itself", ErrPath, op.From)
+		}
+		if doc, err = remove(doc, from); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", ErrMalformed, op.Op)
+}
+
+// pointer is a parsed RFC 6901 JSON Pointer.
+type pointer []string
+
+func parsePointer(s string) (pointer, error) {
+	if s == "" {
+		return pointer{}, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("%w: %q is not a JSON pointer", ErrMalformed, s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func (p pointer) hasPrefix(q pointer) bool {
+	for i := range q {
+		if p[i] != q[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p pointer) String() string {
+	var b strings.Builder
+	for _, t := range p {
+		b.WriteByte('/')
+		b.WriteString(strings.ReplaceAll(strings.ReplaceAll(t, "~", "~0"), "/", "~1"))
+	}
+	return b.String()
+}
+
+func get(doc interface{}, path pointer) (interface{}, error) {
+	for _, t := range path {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			v, ok := c[t]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s not found", ErrPath, path)
+			}
+			doc = v
+		case []interface{}:
+			i, err := index(t, len(c)-1, path)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, fmt.Errorf("%w: %s not found", ErrPath, path)
+		}
+	}
+	return doc, nil
+}
+
+func add(doc interface{}, path pointer, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			c[key] = value
+			return c, nil
+		case []interface{}:
+			if key == "-" {
+				return append(c, value), nil
+			}
+			i, err := index(key, len(c), path)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		return nil, fmt.Errorf("%w: %s has no container", ErrPath, path)
+	})
+}
+
+func remove(doc interface{}, path pointer) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole document", ErrPath)
+	}
+	return update(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := c[key]; !ok {
+				return nil, fmt.Errorf("%w: %s not found", ErrPath, path)
+			}
+			delete(c, key)
+			return c, nil
+		case []interface{}:
+			i, err := index(key, len(c)-1, path)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("%w: %s not found", ErrPath, path)
+	})
+}
+
+func replace(doc interface{}, path pointer, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := c[key]; !ok {
+				return nil, fmt.Errorf("%w: %s not found", ErrPath, path)
+			}
+			c[key] = value
+			return c, nil
+		case []interface{}:
+			i, err := index(key, len(c)-1, path)
+			if err != nil {
+				return nil, err
+			}
+			c[i] = value
+			return c, nil
+		}
+		return nil, fmt.Errorf("%w: %s not found", ErrPath, path)
+	})
+}
+
+// update walks doc down to the parent of the last token of path, lets fn
+// change it, and stores the result back into its own parent since fn may
+// return a new slice.
+func update(doc interface{}, path pointer, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	var walk func(node interface{}, tokens []string) (interface{}, error)
+	walk = func(node interface{}, tokens []string) (interface{}, error) {
+		if len(tokens) == 1 {
+			return fn(node, tokens[0])
+		}
+		switch c := node.(type) {
+		case map[string]interface{}:
+			child, ok := c[tokens[0]]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s not found", ErrPath, path)
+			}
+			v, err := walk(child, tokens[1:])
+			if err != nil {
+				return nil, err
+			}
+			c[tokens[0]] = v
+			return c, nil
+		case []interface{}:
+			i, err := index(tokens[0], len(c)-1, path)
+			if err != nil {
+				return nil, err
+			}
+			v, err := walk(c[i], tokens[1:])
+			if err != nil {
+				return nil, err
+			}
+			c[i] = v
+			return c, nil
+		}
+		return nil, fmt.Errorf("%w: %s not found", ErrPath, path)
+	}
+	return walk(doc, path)
+}
+
+// index parses an array index token, at most max.
+func index(token string, max int, path pointer) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: %s has a bad array index", ErrPath, path)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max {
+		return 0, fmt.Errorf("%w: %s has a bad array index", ErrPath, path)
+	}
+	return i, nil
+}
+
+// equal compares two decoded JSON values, numbers by value.
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errx := x.Float64()
+		fy, erry := y.Float64()
+		return errx == nil && erry == nil && fx == fy
+	}
+	return a == b
+}
+
+// clone deep copies a decoded JSON value so a copied value can be changed
+// independently of its source.
+func clone(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			c[k] = clone(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(x))
+		for i, e := range x {
+			c[i] = clone(e)
+		}
+		return c
+	}
+	return v
+}
+
+// decode reads a JSON value keeping numbers as written.
+func decode(data []byte) (interface{}, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if d.More() {
+		return nil, fmt.Errorf("%w: trailing data", ErrMalformed)
+	}
+	return v, nil
+}
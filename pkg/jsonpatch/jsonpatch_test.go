@@ -0,0 +1,236 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// canonical rewrites a JSON document with sorted keys, as Apply does.
+func canonical(t *testing.T, doc string) string {
+	t.Helper()
+	v, err := decode([]byte(doc))
+	if err != nil {
+		t.Fatalf("decode %s: %v", doc, err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestApply(t *testing.T) {
+	const doc = `{"title":"Dune","author":"Herbert","tags":["sf","classic"],"meta":{"pages":412}}`
+	for _, tt := range []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "add member",
+			patch: `[{"op":"add","path":"/year","value":1965}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["sf","classic"],"meta":{"pages":412},"year":1965}`,
+		},
+		{
+			name:  "add replaces member",
+			patch: `[{"op":"add","path":"/title","value":"Dune Messiah"}]`,
+			want:  `{"title":"Dune Messiah","author":"Herbert","tags":["sf","classic"],"meta":{"pages":412}}`,
+		},
+		{
+			name:  "add inserts into array",
+			patch: `[{"op":"add","path":"/tags/1","value":"epic"}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["sf","epic","classic"],"meta":{"pages":412}}`,
+		},
+		{
+			name:  "add appends to array",
+			patch: `[{"op":"add","path":"/tags/-","value":"epic"}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["sf","classic","epic"],"meta":{"pages":412}}`,
+		},
+		{
+			name:    "add past array end",
+			patch:   `[{"op":"add","path":"/tags/3","value":"epic"}]`,
+			wantErr: ErrPath,
+		},
+		{
+			name:    "add under missing parent",
+			patch:   `[{"op":"add","path":"/missing/year","value":1965}]`,
+			wantErr: ErrPath,
+		},
+		{
+			name:  "add whole document",
+			patch: `[{"op":"add","path":"","value":{"title":"Emma"}}]`,
+			want:  `{"title":"Emma"}`,
+		},
+		{
+			name:  "remove member",
+			patch: `[{"op":"remove","path":"/meta"}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["sf","classic"]}`,
+		},
+		{
+			name:  "remove from array",
+			patch: `[{"op":"remove","path":"/tags/0"}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["classic"],"meta":{"pages":412}}`,
+		},
+		{
+			name:    "remove missing member",
+			patch:   `[{"op":"remove","path":"/year"}]`,
+			wantErr: ErrPath,
+		},
+		{
+			name:    "remove whole document",
+			patch:   `[{"op":"remove","path":""}]`,
+			wantErr: ErrPath,
+		},
+		{
+			name:  "replace member",
+			patch: `[{"op":"replace","path":"/meta/pages","value":896}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["sf","classic"],"meta":{"pages":896}}`,
+		},
+		{
+			name:  "replace array item",
+			patch: `[{"op":"replace","path":"/tags/1","value":"epic"}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["sf","epic"],"meta":{"pages":412}}`,
+		},
+		{
+			name:    "replace missing member",
+			patch:   `[{"op":"replace","path":"/year","value":1965}]`,
+			wantErr: ErrPath,
+		},
+		{
+			name:  "move member",
+			patch: `[{"op":"move","from":"/meta/pages","path":"/pages"}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["sf","classic"],"meta":{},"pages":412}`,
+		},
+		{
+			name:  "move array item",
+			patch: `[{"op":"move","from":"/tags/0","path":"/tags/-"}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["classic","sf"],"meta":{"pages":412}}`,
+		},
+		{
+			name:    "move into its own child",
+			patch:   `[{"op":"move","from":"/meta","path":"/meta/inner"}]`,
+			wantErr: ErrPath,
+		},
+		{
+			name:  "move onto itself",
+			patch: `[{"op":"move","from":"/meta","path":"/meta"}]`,
+			want:  doc,
+		},
+		{
+			name:    "move missing member",
+			patch:   `[{"op":"move","from":"/year","path":"/published"}]`,
+			wantErr: ErrPath,
+		},
+		{
+			name:  "copy member",
+			patch: `[{"op":"copy","from":"/meta","path":"/extra"},{"op":"replace","path":"/extra/pages","value":1}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["sf","classic"],"meta":{"pages":412},"extra":{"pages":1}}`,
+		},
+		{
+			name:  "copy into array",
+			patch: `[{"op":"copy","from":"/author","path":"/tags/0"}]`,
+			want:  `{"title":"Dune","author":"Herbert","tags":["Herbert","sf","classic"],"meta":{"pages":412}}`,
+		},
+		{
+			name:  "test holds",
+			patch: `[{"op":"test","path":"/meta","value":{"pages":412.0}},{"op":"test","path":"/tags/1","value":"classic"}]`,
+			want:  doc,
+		},
+		{
+			name:    "failed test aborts the whole patch",
+			patch:   `[{"op":"replace","path":"/title","value":"Emma"},{"op":"test","path":"/author","value":"Austen"},{"op":"remove","path":"/tags"}]`,
+			wantErr: ErrTestFailed,
+		},
+		{
+			name:    "test of missing member",
+			patch:   `[{"op":"test","path":"/year","value":1965}]`,
+			wantErr: ErrPath,
+		},
+		{
+			name:  "escaped tokens",
+			doc:   `{"a/b":1,"c~d":2}`,
+			patch: `[{"op":"replace","path":"/a~1b","value":10},{"op":"move","from":"/c~0d","path":"/e~01"}]`,
+			want:  `{"a/b":10,"e~1":2}`,
+		},
+		{
+			name:    "leading zero index",
+			patch:   `[{"op":"replace","path":"/tags/01","value":"epic"}]`,
+			wantErr: ErrPath,
+		},
+		{
+			name:    "unknown op",
+			patch:   `[{"op":"merge","path":"/title","value":"Emma"}]`,
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "missing value",
+			patch:   `[{"op":"add","path":"/year"}]`,
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "path not a pointer",
+			patch:   `[{"op":"remove","path":"title"}]`,
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "patch not an array",
+			patch:   `{"op":"remove","path":"/title"}`,
+			wantErr: ErrMalformed,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.doc
+			if src == "" {
+				src = doc
+			}
+			got, err := Apply([]byte(src), []byte(tt.patch))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Apply error = %v, want %v", err, tt.wantErr)
+				}
+				if got != nil {
+					t.Errorf("Apply = %s on error, want nothing", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if want := canonical(t, tt.want); string(got) != want {
+				t.Errorf("Apply = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	for _, tt := range []struct {
+		name, doc, patch, want string
+	}{
+		{"replace member", `{"title":"Dune","year":1965}`, `{"title":"Emma"}`, `{"title":"Emma","year":1965}`},
+		{"add member", `{"title":"Dune"}`, `{"year":1965}`, `{"title":"Dune","year":1965}`},
+		{"null removes member", `{"title":"Dune","year":1965}`, `{"year":null}`, `{"title":"Dune"}`},
+		{"null removes nested member", `{"meta":{"pages":412,"isbn":"x"}}`, `{"meta":{"isbn":null}}`, `{"meta":{"pages":412}}`},
+		{"null of missing member", `{"title":"Dune"}`, `{"year":null}`, `{"title":"Dune"}`},
+		{"array replaced whole", `{"tags":["sf","classic"]}`, `{"tags":["epic"]}`, `{"tags":["epic"]}`},
+		{"object over scalar", `{"meta":1}`, `{"meta":{"pages":412,"isbn":null}}`, `{"meta":{"pages":412}}`},
+		{"non-object patch replaces document", `{"title":"Dune"}`, `["Dune"]`, `["Dune"]`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("MergePatch: %v", err)
+			}
+			if want := canonical(t, tt.want); string(got) != want {
+				t.Errorf("MergePatch = %s, want %s", got, want)
+			}
+		})
+	}
+
+	if _, err := MergePatch([]byte(`{"title":"Dune"}`), []byte(`{"title":`)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("MergePatch of malformed patch error = %v, want %v", err, ErrMalformed)
+	}
+}
@@ -1,27 +1,50 @@
 package middleware
 
 import (
-	"time"
+	"context"
 
-	"github.com/caohoangphuctd97/go-test/pkg/configs"
+	"github.com/caohoangphuctd97/go-test/pkg/reqctx"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cache"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
 
 // FiberMiddleware provide Fiber's built-in middlewares.
 // See: https://docs.gofiber.io/api/middleware
 func FiberMiddleware(a *fiber.App) {
 	a.Use(
-		// Add CORS to each route.
-		cors.New(),
-		cache.New(cache.Config{
-			Next: func(c *fiber.Ctx) bool {
-				return c.Query("refresh") == "true"
-			},
-			Expiration:   30 * time.Minute,
-			CacheControl: true,
-			Storage:      configs.RedisNew(configs.WithAddr("localhost:6379")),
+		// Turn panics into errors, reported by the app error handler.
+		recover.New(),
+		// Add CORS to each route, letting browsers read the book ETag.
+		cors.New(cors.Config{
+			ExposeHeaders: fiber.HeaderETag + ", " + fiber.HeaderXRequestID,
 		}),
+		// Tag each request with an ID, kept from X-Request-ID when given.
+		requestid.New(),
+		RequestContext,
 	)
 }
+
+// RequestContext middleware hands repositories, through the user context, a
+// context carrying the request ID and canceled once the handler returns, or
+// once the server ReadTimeout is over. fasthttp reads nothing from the
+// connection while the handler runs, so a client hanging up goes unnoticed:
+// the deadline is what stops the queries made for it.
+func RequestContext(c *fiber.Ctx) error {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout := c.App().Config().ReadTimeout; timeout > 0 {
+		ctx, cancel = context.WithTimeout(c.UserContext(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(c.UserContext())
+	}
+	defer cancel()
+	if id, ok := c.Locals("requestid").(string); ok {
+		ctx = reqctx.WithRequestID(ctx, id)
+	}
+	c.SetUserContext(ctx)
+	return c.Next()
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caohoangphuctd97/go-test/pkg/reqctx"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// slowQuery stands for a query running until ctx is done, or for long.
+func slowQuery(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(10 * time.Second):
+		return nil
+	}
+}
+
+func TestRequestContextBoundByReadTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		timeout time.Duration
+		want    error
+	}{
+		{"read timeout", 100 * time.Millisecond, context.DeadlineExceeded},
+		{"no read timeout", 0, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ReadTimeout: tt.timeout})
+			app.Use(RequestContext)
+			app.Get("/slow", func(c *fiber.Ctx) error {
+				ctx, cancel := context.WithTimeout(c.UserContext(), 300*time.Millisecond)
+				defer cancel()
+				err := slowQuery(ctx)
+				if tt.want == nil && errors.Is(err, context.DeadlineExceeded) {
+					// Bound by the test, not by the middleware.
+					err = nil
+				}
+				return c.SendString(fmt.Sprint(err))
+			})
+
+			req, _ := http.NewRequest(fiber.MethodGet, "/slow", nil)
+			res, err := app.Test(req, 5000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			if want := fmt.Sprint(tt.want); string(body) != want {
+				t.Errorf("query error = %s, want %s", body, want)
+			}
+		})
+	}
+}
+
+func TestRequestContextCanceledOnReturn(t *testing.T) {
+	var ctx context.Context
+	app := fiber.New()
+	app.Use(requestid.New(), RequestContext)
+	app.Get("/", func(c *fiber.Ctx) error {
+		ctx = c.UserContext()
+		if err := ctx.Err(); err != nil {
+			t.Errorf("context done within handler: %v", err)
+		}
+		return nil
+	})
+
+	req, _ := http.NewRequest(fiber.MethodGet, "/", nil)
+	req.Header.Set(fiber.HeaderXRequestID, "req-1")
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := reqctx.RequestID(ctx); got != "req-1" {
+		t.Errorf("request ID = %q, want req-1", got)
+	}
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("context error after return = %v, want %v", ctx.Err(), context.Canceled)
+	}
+}
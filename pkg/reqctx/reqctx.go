@@ -0,0 +1,56 @@
+// Package reqctx carries who made a request, and under which request ID,
+// through the context handed to repositories.
+package reqctx
+
+import (
+	"context"
+	"time"
+)
+
+// Anonymous is the actor of requests made by no known user.
+const Anonymous = "anonymous"
+
+type (
+	actorKey     struct{}
+	requestIDKey struct{}
+)
+
+// WithActor returns a copy of ctx carrying given actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor carried by ctx, Anonymous if none.
+func Actor(ctx context.Context) string {
+	if a, ok := ctx.Value(actorKey{}).(string); ok && a != "" {
+		return a
+	}
+	return Anonymous
+}
+
+// WithRequestID returns a copy of ctx carrying given request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// detached is a context carrying the values of its parent, but never
+// canceled.
+type detached struct{ parent context.Context }
+
+func (detached) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detached) Done() <-chan struct{}               { return nil }
+func (detached) Err() error                          { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
+// Detach returns a context carrying the actor and request ID of ctx, but
+// not canceled with it: for work outliving the request, such as streaming
+// a body once the handler returned, or side effects of a committed write.
+func Detach(ctx context.Context) context.Context {
+	return detached{parent: ctx}
+}
@@ -0,0 +1,111 @@
+// Package rowmap maps table rows to structs through their `db:"..."` tags,
+// so queries name their columns explicitly instead of relying on the table
+// column order.
+package rowmap
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Map lists the columns of a struct type and reaches the matching fields of
+// its values. A Map is safe for concurrent use.
+type Map struct {
+	typ     reflect.Type
+	columns []string
+	fields  map[string][]int
+}
+
+// New returns the Map of the struct type of v, which may be a struct or a
+// pointer to one. Every exported field tagged `db:"name"` is a column, in
+// field order; untagged embedded structs are walked into, and fields tagged
+// `db:"-"` or untagged are skipped. New panics when v is not a struct or two
+// fields claim the same column, both being programming errors.
+func New(v interface{}) *Map {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("rowmap: %T is not a struct", v))
+	}
+	m := &Map{typ: t, fields: map[string][]int{}}
+	m.walk(t, nil)
+	return m
+}
+
+func (m *Map) walk(t reflect.Type, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		path := append(append([]int{}, index...), i)
+		name, _, _ := strings.Cut(f.Tag.Get("db"), ",")
+		if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			m.walk(f.Type, path)
+			continue
+		}
+		if name == "" || name == "-" || !f.IsExported() {
+			continue
+		}
+		if _, ok := m.fields[name]; ok {
+			panic(fmt.Sprintf("rowmap: column %q mapped twice in %s", name, m.typ))
+		}
+		m.fields[name] = path
+		m.columns = append(m.columns, name)
+	}
+}
+
+// Columns returns every column of the mapped type, in field order.
+func (m *Map) Columns() []string {
+	return append([]string{}, m.columns...)
+}
+
+// Pointers returns pointers to the fields of v matching given columns, all
+// columns when none is given, ready to be passed to Scan. v must be a
+// pointer to the mapped type.
+func (m *Map) Pointers(v interface{}, columns ...string) []interface{} {
+	s := m.value(v)
+	if len(columns) == 0 {
+		columns = m.columns
+	}
+	out := make([]interface{}, len(columns))
+	for i, c := range columns {
+		out[i] = s.FieldByIndex(m.index(c)).Addr().Interface()
+	}
+	return out
+}
+
+// Values returns the values of the fields of v matching given columns, all
+// columns when none is given, ready to be passed to an INSERT. v must be a
+// pointer to the mapped type.
+func (m *Map) Values(v interface{}, columns ...string) []interface{} {
+	s := m.value(v)
+	if len(columns) == 0 {
+		columns = m.columns
+	}
+	out := make([]interface{}, len(columns))
+	for i, c := range columns {
+		out[i] = s.FieldByIndex(m.index(c)).Interface()
+	}
+	return out
+}
+
+// value returns the struct v points at. It panics when v is not a pointer
+// to the mapped type.
+func (m *Map) value(v interface{}) reflect.Value {
+	p := reflect.ValueOf(v)
+	if p.Kind() != reflect.Ptr || p.IsNil() || p.Elem().Type() != m.typ {
+		panic(fmt.Sprintf("rowmap: %T is not a *%s", v, m.typ))
+	}
+	return p.Elem()
+}
+
+// index returns the field index of given column. It panics on an unknown
+// column.
+func (m *Map) index(column string) []int {
+	i, ok := m.fields[column]
+	if !ok {
+		panic(fmt.Sprintf("rowmap: %s has no column %q", m.typ, column))
+	}
+	return i
+}
@@ -9,7 +9,9 @@ import (
 )
 
 // StartServerWithGracefulShutdown function for starting server with a graceful shutdown.
-func StartServerWithGracefulShutdown(a *fiber.App) {
+// Given functions run first on shutdown, e.g. to end long-lived connections the
+// server would otherwise wait for.
+func StartServerWithGracefulShutdown(a *fiber.App, onShutdown ...func()) {
 	// Create channel for idle connections.
 	idleConnsClosed := make(chan struct{})
 
@@ -19,6 +21,9 @@ func StartServerWithGracefulShutdown(a *fiber.App) {
 		<-sigint
 
 		// Received an interrupt signal, shutdown.
+		for _, fn := range onShutdown {
+			fn()
+		}
 		if err := a.Shutdown(); err != nil {
 			// Error from closing listeners, or context timeout:
 			log.Printf("Oops... Server is not shutting down! Reason: %v", err)
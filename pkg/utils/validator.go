@@ -1,6 +1,11 @@
 package utils
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/caohoangphuctd97/go-test/pkg/errs"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 )
@@ -10,6 +15,15 @@ func NewValidator() *validator.Validate {
 	// Create a new validator for a Book model.
 	validate := validator.New()
 
+	// Name fields after their JSON name in errors.
+	validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
 	// Custom validation for uuid.UUID fields.
 	_ = validate.RegisterValidation("uuid", func(fl validator.FieldLevel) bool {
 		field := fl.Field().String()
@@ -29,8 +43,49 @@ func ValidatorErrors(err error) map[string]string {
 
 	// Make error message for each invalid field.
 	for _, err := range err.(validator.ValidationErrors) {
-		fields[err.Field()] = err.Error()
+		fields[err.StructField()] = err.Error()
 	}
 
 	return fields
 }
+
+// ValidationError func turns validation errors into a domain error listing
+// each invalid field with its JSON Pointer.
+func ValidationError(err error) error {
+	verrs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return errs.Wrap(errs.ErrValidation, err, err.Error())
+	}
+
+	fields := make([]errs.FieldError, 0, len(verrs))
+	for _, err := range verrs {
+		fields = append(fields, errs.FieldError{
+			Name:    err.StructField(),
+			Pointer: jsonPointer(err.Namespace()),
+			Detail:  fieldDetail(err),
+		})
+	}
+	return errs.Invalid(fields...)
+}
+
+// jsonPointer turns a validator namespace such as "Book.items[0].title"
+// into the JSON Pointer "/items/0/title", dropping the root struct name.
+func jsonPointer(namespace string) string {
+	parts := strings.Split(namespace, ".")[1:]
+	var b strings.Builder
+	for _, p := range parts {
+		for _, token := range strings.FieldsFunc(p, func(r rune) bool { return r == '[' || r == ']' }) {
+			b.WriteByte('/')
+			b.WriteString(strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1"))
+		}
+	}
+	return b.String()
+}
+
+// fieldDetail explains which rule a field broke.
+func fieldDetail(err validator.FieldError) string {
+	if err.Param() == "" {
+		return fmt.Sprintf("%s must satisfy %q", err.Field(), err.Tag())
+	}
+	return fmt.Sprintf("%s must satisfy %q with %s", err.Field(), err.Tag(), err.Param())
+}